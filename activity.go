@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"gnomon"
+	"strconv"
+)
+
+// Pages through the contracts an address deployed or invoked
+func searchActivity(address string) {
+	size, _ := strconv.Atoi(getText(`Enter results per page, blank for 20:`))
+	if size <= 0 {
+		size = 20
+	}
+	for offset := 0; ; {
+		activity, total := gnomon.Backend().GetActivityByAddress(address, size, offset)
+		fmt.Println("Activity of", address+":", total)
+		if total == 0 {
+			return
+		}
+		for i, a := range activity {
+			fmt.Printf("[%d] %s %s height: %d\n", offset+i, a.Type, a.SCID, a.Height)
+			if a.Type == "invoke" {
+				fmt.Println("    entrypoint:", a.Entrypoint, "txid:", a.TXID)
+			}
+		}
+		fmt.Printf("Showing %d-%d of %d\n", offset, offset+len(activity)-1, total)
+		switch getText(`Enter "n" for the next page, "p" for the previous, blank to return:`) {
+		case "n":
+			if offset+size < total {
+				offset += size
+			}
+		case "p":
+			offset = max(offset-size, 0)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"gnomon"
+	"gnomon/structs"
+	"strings"
+)
+
+// assets mine, assets collection <scid>, assets history <scid>
+func assets(value string) {
+	if !gnomon.Started {
+		println("Gnomon not started")
+		return
+	}
+	address := ""
+	if dero.Wallet != nil {
+		address = dero.Wallet.GetAddress().String()
+	}
+	sub, scid, _ := strings.Cut(strings.TrimSpace(value), " ")
+	scid = strings.TrimSpace(scid)
+	switch sub {
+	case "mine":
+		if address == "" {
+			println("No wallet open, type help for more info")
+			return
+		}
+		list := gnomon.AssetsOwnedBy(address)
+		fmt.Println("Assets owned by", address+":", len(list))
+		for _, asset := range list {
+			showAsset(asset, address)
+		}
+	case "collection":
+		if scid == "" {
+			scid = getText(`Enter collection SCID:`)
+		}
+		list := gnomon.CollectionAssets(scid)
+		fmt.Println("Assets in collection", scid+":", len(list))
+		for _, asset := range list {
+			showAsset(asset, address)
+		}
+	case "history":
+		if scid == "" {
+			scid = getText(`Enter asset SCID:`)
+		}
+		asset, err := gnomon.GetAsset(scid)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		showAsset(asset, address)
+		for _, transfer := range gnomon.AssetHistory(scid) {
+			fmt.Printf("height: %d %s txid: %s\n", transfer.Height, transfer.Entrypoint, transfer.TXID)
+			fmt.Println("    from:", ownerLabel(transfer.From, address))
+			fmt.Println("    to:  ", ownerLabel(transfer.To, address))
+		}
+	default:
+		fmt.Println(`Use "assets mine", "assets collection <scid>" or "assets history <scid>"`)
+	}
+}
+
+func showAsset(asset structs.Asset, address string) {
+	fmt.Println("")
+	fmt.Println("SCID:", asset.SCID, asset.Standard, "-------------------")
+	fmt.Println("Name:", asset.Name)
+	if asset.Description != "" {
+		fmt.Println("Description:", asset.Description)
+	}
+	if asset.Image != "" {
+		fmt.Println("Image:", asset.Image)
+	}
+	if asset.Collection != "" {
+		fmt.Println("Collection:", asset.Collection)
+	}
+	fmt.Println("Creator:", ownerLabel(asset.Creator, address))
+	fmt.Println("Owner:", ownerLabel(asset.Owner, address))
+}
+
+func ownerLabel(owner string, address string) string {
+	switch {
+	case owner == "":
+		return "(none)"
+	case owner == address:
+		return owner + " (you)"
+	}
+	return owner
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -16,6 +17,7 @@ import (
 	"gnomon/daemon"
 	sql "gnomon/db"
 	"gnomon/show"
+	"gnomon/structs"
 )
 
 type Configuration struct {
@@ -27,6 +29,10 @@ type Configuration struct {
 	Endpoints   []daemon.Connection
 	Port        string
 	CmdFlags    map[string]any
+	// Historical backfill workers, 0 uses one per healthy endpoint and negative disables
+	BackfillWorkers int
+	// PostgreSQL connection string, the index is stored there instead of sqlite when set
+	Postgres string
 }
 
 /* CUSTOM FILTERS */
@@ -56,9 +62,21 @@ func dbPathAndName() (db_path string, db_name string) {
 	return
 }
 func initDB() {
-	//Create the tables now...
-	Sqlite, _ := sql.NewDiskDB(dbPathAndName())
-	sql.CreateTables(Sqlite.DB)
+	//Create or migrate the tables now...
+	Sqlite, err := sql.NewDiskDB(dbPathAndName())
+	if err != nil {
+		fmt.Println("Err opening db:", err)
+		os.Exit(1)
+	}
+	// Drop what an interrupted write or flush left past the last consistent height
+	height, removed, err := Sqlite.CheckIntegrity()
+	if err != nil {
+		fmt.Println("Err checking db:", err)
+		os.Exit(1)
+	}
+	if removed != 0 {
+		fmt.Println("Removed", removed, "rows of a partial write, resuming from height", height)
+	}
 	Sqlite.DB.Close()
 }
 
@@ -67,7 +85,9 @@ func setFlags() {
 	portFlag := flag.Int("port", 0000, "string")
 	envModeFlag := flag.String("mode", "mainnet", "string")
 	simulatorFlag := flag.Bool("simulator", false, "string")
+	postgresFlag := flag.String("postgres", "", "string")
 	flag.Parse()
+	Config.Postgres = *postgresFlag
 	Config.CmdFlags["port"] = strconv.Itoa(*portFlag)
 	Config.CmdFlags["mode"] = *envModeFlag
 	Config.CmdFlags["simulator"] = *simulatorFlag
@@ -170,38 +190,15 @@ func getConfig(update bool) Configuration {
 		println("Enter custom connection or enter n to use the default remote connections eg. node.derofoundation.org:10102 ")
 		_, err = fmt.Scanln(&text)
 		if text != "n" {
-			daemon.Endpoints = []daemon.Connection{}
-			addrs := strings.Split(text, ",")
-			for _, add := range addrs {
-				daemon.Endpoints = append(daemon.Endpoints, daemon.Connection{Address: strings.TrimSpace(add)})
-			}
+			setEndpoints(text)
 			sql.SaveSetting(Sqlite.DB, "Endpoints", text)
 		} else {
-			defaultAddress := ""
-			if !isMainnet() {
-				defaultAddress = "testnetexplorer.dero.io:40402"
-				if isSimulator() {
-					defaultAddress = "127.0.0.1:40402"
-				}
-			}
-			if isSimulator() {
-				defaultAddress = "127.0.0.1:20000"
-			}
-			//Overrides default daemons with specific
-			if defaultAddress != "" {
-				daemon.Endpoints = []daemon.Connection{
-					{Address: defaultAddress},
-				}
-			}
+			setEndpoints("")
 			sql.SaveSetting(Sqlite.DB, "Endpoints", "")
 		}
 	} else {
 		println("Using Connections: ", val)
-		daemon.Endpoints = []daemon.Connection{}
-		addrs := strings.Split(val, ",")
-		for _, add := range addrs {
-			daemon.Endpoints = append(daemon.Endpoints, daemon.Connection{Address: strings.TrimSpace(add)})
-		}
+		setEndpoints(val)
 		config.Endpoints = daemon.Endpoints
 	}
 
@@ -268,6 +265,34 @@ func getConfig(update bool) Configuration {
 	return config
 }
 
+// Sets the daemon connections from a comma separated list, empty uses the network defaults
+func setEndpoints(val string) {
+	if val != "" {
+		daemon.Endpoints = []daemon.Connection{}
+		addrs := strings.Split(val, ",")
+		for _, add := range addrs {
+			daemon.Endpoints = append(daemon.Endpoints, daemon.Connection{Address: strings.TrimSpace(add)})
+		}
+		return
+	}
+	defaultAddress := ""
+	if !isMainnet() {
+		defaultAddress = "testnetexplorer.dero.io:40402"
+		if isSimulator() {
+			defaultAddress = "127.0.0.1:40402"
+		}
+	}
+	if isSimulator() {
+		defaultAddress = "127.0.0.1:20000"
+	}
+	//Overrides default daemons with specific
+	if defaultAddress != "" {
+		daemon.Endpoints = []daemon.Connection{
+			{Address: defaultAddress},
+		}
+	}
+}
+
 func EditFilters(filters map[string]map[string][]string) map[string]map[string][]string {
 	fmt.Println("-- Filters: ")
 	for class, filter := range filters {
@@ -302,10 +327,12 @@ func EditFilters(filters map[string]map[string][]string) map[string]map[string][
 }
 func editFilter(filter map[string][]string) map[string][]string {
 	var text string
-	println("Enter 1 to edit filter or 2 for options:")
+	println("Enter 1 to edit filter, 2 for options or 3 for match rules:")
 	_, _ = fmt.Scanln(&text)
 	if text == "1" {
 		filter = changeTags(filter)
+	} else if text == "3" {
+		filter = changeMatch(filter)
 	} else {
 		filter = changeOption(filter)
 	}
@@ -324,7 +351,7 @@ func changeTags(filter map[string][]string) map[string][]string {
 func changeOption(option map[string][]string) map[string][]string {
 	fmt.Println("Current options:", option["options"])
 	var text string
-	println(`"i" is case-insensitive match and "b" is word boundry match.`)
+	println(`"i" is case-insensitive match, "b" is word boundry match, "co" saves class only and "nc" ignores comments.`)
 	println(`Enter new csv list of options eg, "i,b", or "i" or type done to return:`)
 
 	_, _ = fmt.Scanln(&text)
@@ -337,6 +364,143 @@ func changeOption(option map[string][]string) map[string][]string {
 	return option
 }
 
+func changeMatch(filter map[string][]string) map[string][]string {
+	fmt.Println("Current match rules:", strings.Join(filter["match"], " AND "))
+	println("Rules use func:, sig:, var:, hash:, owner: or tag: terms with &, |, ! and brackets, all rules must match.")
+	println(`eg. func:InitializePrivate & var:nameHdr & var:telaVersion`)
+	print(`Enter a rule to add, "clear" to remove all or "done" to return:`)
+	reader := bufio.NewReader(os.Stdin)
+	text, _ := reader.ReadString('\n')
+	text = strings.TrimSpace(text)
+	reader.Reset(os.Stdin)
+	if text == "done" {
+		return filter
+	} else if text == "clear" {
+		delete(filter, "match")
+	} else if _, err := parseFilterExpr(text); err != nil {
+		fmt.Println("Invalid rule:", err)
+	} else {
+		filter["match"] = append(filter["match"], text)
+	}
+	return changeMatch(filter)
+}
+
+func EditIndexPolicy(policy structs.IndexPolicy) structs.IndexPolicy {
+	if policy.Include == nil {
+		policy.Include = map[string][]string{}
+	}
+	if policy.Exclude == nil {
+		policy.Exclude = map[string][]string{}
+	}
+	fmt.Println("-- Index policy (fields:", strings.Join(structs.IndexPolicyFields, ", ")+"): ")
+	fmt.Println("Include:", policy.Include)
+	fmt.Println("Exclude:", policy.Exclude)
+	fmt.Println("--------------------------------------------")
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print(`Type "include field value", "exclude field value", "remove field value", "clear" or "done" to return:`)
+	text, err := reader.ReadString('\n')
+	if err != nil {
+		println("Error reading input:", err)
+	}
+	text = strings.TrimSpace(text)
+	reader.Reset(os.Stdin)
+
+	parts := strings.Fields(text)
+	if text == "done" {
+		return policy
+	} else if text == "clear" {
+		return EditIndexPolicy(structs.IndexPolicy{})
+	} else if len(parts) != 3 || !slices.Contains(structs.IndexPolicyFields, parts[1]) {
+		fmt.Println("Unknown rule:", text)
+		return EditIndexPolicy(policy)
+	}
+	field, value := parts[1], parts[2]
+	switch parts[0] {
+	case "include":
+		if !slices.Contains(policy.Include[field], value) {
+			policy.Include[field] = append(policy.Include[field], value)
+		}
+	case "exclude":
+		if !slices.Contains(policy.Exclude[field], value) {
+			policy.Exclude[field] = append(policy.Exclude[field], value)
+		}
+	case "remove":
+		policy.Include[field] = slices.DeleteFunc(policy.Include[field], func(v string) bool { return v == value })
+		policy.Exclude[field] = slices.DeleteFunc(policy.Exclude[field], func(v string) bool { return v == value })
+		if len(policy.Include[field]) == 0 {
+			delete(policy.Include, field)
+		}
+		if len(policy.Exclude[field]) == 0 {
+			delete(policy.Exclude, field)
+		}
+	}
+	return EditIndexPolicy(policy)
+}
+
+func EditSpamPolicy(policy structs.SpamPolicy) structs.SpamPolicy {
+	if policy.Rules == nil {
+		policy.Rules = map[string]structs.SpamRule{}
+	}
+	fmt.Println("-- Spam policy (max registration txs per block:", strconv.Itoa(policy.MaxRegistrations)+"): ")
+	for scid, rule := range policy.Rules {
+		fmt.Println(scid)
+		fmt.Println("    max invokes:", rule.MaxInvokes, "blocks:", rule.Blocks, "min fee:", rule.MinFee, "quarantine:", rule.Quarantine)
+		if len(rule.Allow) != 0 {
+			fmt.Println("    allowed:", rule.Allow)
+		}
+	}
+	fmt.Println("--------------------------------------------")
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print(`Type "rule scid max_invokes blocks min_fee quarantine(y/n)", "allow scid signer", "disallow scid signer", "remove scid", "registrations n", "clear" or "done" to return:`)
+	text, err := reader.ReadString('\n')
+	if err != nil {
+		println("Error reading input:", err)
+	}
+	text = strings.TrimSpace(text)
+	reader.Reset(os.Stdin)
+
+	parts := strings.Fields(text)
+	switch {
+	case text == "done":
+		return policy
+	case text == "clear":
+		return EditSpamPolicy(structs.SpamPolicy{MaxRegistrations: policy.MaxRegistrations})
+	case len(parts) == 6 && parts[0] == "rule":
+		rule := policy.Rules[parts[1]]
+		max, err1 := strconv.Atoi(parts[2])
+		blocks, err2 := strconv.ParseInt(parts[3], 10, 64)
+		fee, err3 := strconv.ParseUint(parts[4], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil || max < 0 || blocks < 0 {
+			fmt.Println("Unknown rule:", text)
+			break
+		}
+		rule.MaxInvokes, rule.Blocks, rule.MinFee, rule.Quarantine = max, blocks, fee, parts[5] == "y"
+		policy.Rules[parts[1]] = rule
+	case len(parts) == 3 && parts[0] == "allow":
+		rule := policy.Rules[parts[1]]
+		if !slices.Contains(rule.Allow, parts[2]) {
+			rule.Allow = append(rule.Allow, parts[2])
+		}
+		policy.Rules[parts[1]] = rule
+	case len(parts) == 3 && parts[0] == "disallow":
+		if rule, ok := policy.Rules[parts[1]]; ok {
+			rule.Allow = slices.DeleteFunc(rule.Allow, func(v string) bool { return v == parts[2] })
+			policy.Rules[parts[1]] = rule
+		}
+	case len(parts) == 2 && parts[0] == "remove":
+		delete(policy.Rules, parts[1])
+	case len(parts) == 2 && parts[0] == "registrations":
+		if n, err := strconv.Atoi(parts[1]); err == nil && n >= 0 {
+			policy.MaxRegistrations = n
+		} else {
+			fmt.Println("Unknown rule:", text)
+		}
+	default:
+		fmt.Println("Unknown rule:", text)
+	}
+	return EditSpamPolicy(policy)
+}
+
 func updateCompleted(starting_height int64, lowest_daemon_height int64, completed string, start int, finish int) (string, int64, int64) {
 	ending_height := int64(-1)
 	var complete [][2]int
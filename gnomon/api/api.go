@@ -1,12 +1,16 @@
 package api
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"gnomon/daemon"
@@ -15,10 +19,31 @@ import (
 	"gnomon/structs"
 )
 
-var sqlite = &sql.SqlStore{}
+var sqlite sql.Store
+
+// Local settings, the policies are kept in the sqlite file whatever the backend
+var settings = &sql.SqlStore{}
+
+// Set by gnomon when the index is stored outside of the sqlite file
+var Backend sql.Store
 var StartGnomon = false
 var Port = "0"
 
+// Set by gnomon to apply policy changes to the running indexer
+var PolicyChanged = func(policy structs.IndexPolicy) {}
+
+// Set by gnomon to start and report on background reclassification
+var ReclassifyRequested = func(full bool) bool { return false }
+var ReclassifyProgress = func() structs.ReclassifyStatus { return structs.ReclassifyStatus{} }
+
+// Set by gnomon to report its memory use
+var MemoryUsage = func() structs.MemoryUsage { return structs.MemoryUsage{} }
+
+// Set by gnomon to apply spam policy changes and act on quarantined invokes
+var SpamPolicyChanged = func(policy structs.SpamPolicy) {}
+var QuarantineRelease = func(txid string) error { return errors.New("gnomon not started") }
+var QuarantineDrop = func(txid string) error { return errors.New("gnomon not started") }
+
 func Start(port string, db_dir string) {
 	Port = port
 	go func() {
@@ -27,7 +52,11 @@ func Start(port string, db_dir string) {
 	db_name := fmt.Sprintf("sql%s.db", "GNOMON")
 	wd := db_dir
 	db_path := filepath.Join(wd, "gnomondb")
-	sqlite, _ = sql.NewDiskDB(db_path, db_name)
+	settings, _ = sql.NewDiskDB(db_path, db_name)
+	sqlite = settings
+	if Backend != nil {
+		sqlite = Backend
+	}
 
 	height, err := sqlite.GetLastIndexHeight()
 	if err != nil {
@@ -44,14 +73,40 @@ func Start(port string, db_dir string) {
 	http.HandleFunc("/GetAllOwnersAndSCIDs", GetAllOwnersAndSCIDs)
 	http.HandleFunc("/GetSC", GetSC)
 	http.HandleFunc("/GetInitialSCIDCode", GetInitialSCIDCode)
+	http.HandleFunc("/GetSCCodeHistory", GetSCCodeHistory)
+	http.HandleFunc("/GetSCCodeAtTopoheight", GetSCCodeAtTopoheight)
 	http.HandleFunc("/GetAllSCIDVariableDetails", GetAllSCIDVariableDetails)
 	http.HandleFunc("/GetSCIDVariableDetailsAtTopoheight", GetSCIDVariableDetailsAtTopoheight)
 	http.HandleFunc("/GetSCIDInteractionHeight", GetSCIDInteractionHeight)
 	http.HandleFunc("/GetSCIDValuesByKey", GetSCIDValuesByKey)
 	http.HandleFunc("/GetSCIDKeysByValue", GetSCIDKeysByValue)
+	http.HandleFunc("/GetSCIDVariableTimeline", GetSCIDVariableTimeline)
+	http.HandleFunc("/GetSCIDVariableChanges", GetSCIDVariableChanges)
 	http.HandleFunc("/GetSCIDsByClass", GetSCIDsByClass)
 	http.HandleFunc("/GetSCIDsByTags", GetSCIDsByTags)
 	http.HandleFunc("/GetSCsByTags", GetSCsByTags)
+	http.HandleFunc("/GetFacets", GetFacets)
+	http.HandleFunc("/GetIndexPolicy", GetIndexPolicy)
+	http.HandleFunc("/SetIndexPolicy", SetIndexPolicy)
+	http.HandleFunc("/GetSCIDsWithSameCode", GetSCIDsWithSameCode)
+	http.HandleFunc("/GetCodeFamilies", GetCodeFamilies)
+	http.HandleFunc("/GetSCIDsByFamily", GetSCIDsByFamily)
+	http.HandleFunc("/Reclassify", Reclassify)
+	http.HandleFunc("/GetReclassifyStatus", GetReclassifyStatus)
+	http.HandleFunc("/GetSwaps", GetSwaps)
+	http.HandleFunc("/GetSwap", GetSwap)
+	http.HandleFunc("/GetNameAddress", GetNameAddress)
+	http.HandleFunc("/GetAddressNames", GetAddressNames)
+	http.HandleFunc("/GetActivityByAddress", GetActivityByAddress)
+	http.HandleFunc("/Search", Search)
+	http.HandleFunc("/GetInvalidSCIDDeploys", GetInvalidSCIDDeploys)
+	http.HandleFunc("/GetMiniblockCountByAddress", GetMiniblockCountByAddress)
+	http.HandleFunc("/GetIntegrators", GetIntegrators)
+	http.HandleFunc("/GetSpamPolicy", GetSpamPolicy)
+	http.HandleFunc("/SetSpamPolicy", SetSpamPolicy)
+	http.HandleFunc("/GetQuarantined", GetQuarantined)
+	http.HandleFunc("/ReleaseQuarantined", ReleaseQuarantined)
+	http.HandleFunc("/DropQuarantined", DropQuarantined)
 
 	http.ListenAndServe("localhost:"+port, nil)
 }
@@ -84,7 +139,7 @@ func Info(w http.ResponseWriter, r *http.Request) {
 		paused = true
 	}
 	li := int64(0)
-	if sqlite != nil && sqlite.DB != nil {
+	if sqlite != nil {
 		li, _ = sqlite.GetLastIndexHeight()
 	}
 
@@ -93,6 +148,7 @@ func Info(w http.ResponseWriter, r *http.Request) {
 			"started":    started,
 			"paused":     paused,
 			"last_index": li,
+			"memory":     MemoryUsage(),
 		})
 	fmt.Fprint(w, string(jsonData))
 }
@@ -209,6 +265,30 @@ func GetInitialSCIDCode(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, string(jsonData))
 }
 
+// Every version of the SC code with the height and txid it was set at, add code=1 to include the code
+// http://localhost:8080/GetSCCodeHistory?scid=b77b1f5eeff6ed39c8b979c2aeb1c800081fc2ae8f570ad254bedf47bfa977f0&code=1
+func GetSCCodeHistory(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	versions := sqlite.GetSCCodeHistory(QueryParam("scid", r.URL.RawQuery))
+	if QueryParam("code", r.URL.RawQuery) != "1" {
+		for i := range versions {
+			versions[i].Code = ""
+		}
+	}
+	jsonData, _ := json.Marshal(versions)
+	fmt.Fprint(w, string(jsonData))
+}
+
+// The SC code in place at a height
+// http://localhost:8080/GetSCCodeAtTopoheight?scid=b77b1f5eeff6ed39c8b979c2aeb1c800081fc2ae8f570ad254bedf47bfa977f0&height=50000
+func GetSCCodeAtTopoheight(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	h, _ := strconv.Atoi(QueryParam("height", r.URL.RawQuery))
+	res, _ := sqlite.GetSCCodeAtTopoheight(QueryParam("scid", r.URL.RawQuery), int64(h))
+	jsonData, _ := json.Marshal(res)
+	fmt.Fprint(w, string(jsonData))
+}
+
 // http://localhost:8080/GetAllSCIDVariableDetails?scid=b77b1f5eeff6ed39c8b979c2aeb1c800081fc2ae8f570ad254bedf47bfa977f0
 func GetAllSCIDVariableDetails(w http.ResponseWriter, r *http.Request) {
 	head(w)
@@ -267,6 +347,84 @@ func GetSCIDKeysByValue(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, string(jsonData))
 }
 
+// The value of a key every step heights from from to to, to defaults to and is capped at the last indexed height.
+// key is a string key, keyuint64 a uint64 one. format=csv for csv rows
+// http://localhost:8080/GetSCIDVariableTimeline?scid=bb6e2f7dc7e09dfc42e9f357a66110e85a06c178b0018b38db57a317cbec9cdb&key=nameHdr&from=4000000&step=1000&format=csv
+func GetSCIDVariableTimeline(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	key, from, to, err := timelineQuery(query)
+	if err != nil {
+		timelineError(w, err)
+		return
+	}
+	step, _ := strconv.ParseInt(query.Get("step"), 10, 64)
+	writePoints(w, query.Get("format"), sqlite.GetSCIDVariableTimeline(query.Get("scid"), key, from, to, step))
+}
+
+// Every change of a key from from to to, deletions included, to defaults to and is capped at the last indexed height.
+// key is a string key, keyuint64 a uint64 one. format=csv for csv rows
+// http://localhost:8080/GetSCIDVariableChanges?scid=bb6e2f7dc7e09dfc42e9f357a66110e85a06c178b0018b38db57a317cbec9cdb&key=nameHdr&from=0&format=csv
+func GetSCIDVariableChanges(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	key, from, to, err := timelineQuery(query)
+	if err != nil {
+		timelineError(w, err)
+		return
+	}
+	writePoints(w, query.Get("format"), sqlite.GetSCIDVariableChanges(query.Get("scid"), key, from, to))
+}
+
+// Key and heights of a timeline query, to is capped at the last indexed height
+func timelineQuery(query url.Values) (key any, from int64, to int64, err error) {
+	to, _ = sqlite.GetLastIndexHeight()
+	if query.Has("from") {
+		if from, err = strconv.ParseInt(query.Get("from"), 10, 64); err != nil || from < 0 {
+			return nil, 0, 0, errors.New("invalid from height")
+		}
+	}
+	if query.Has("to") {
+		var end int64
+		if end, err = strconv.ParseInt(query.Get("to"), 10, 64); err != nil || end < 0 {
+			return nil, 0, 0, errors.New("invalid to height")
+		}
+		to = min(to, end)
+	}
+	key = query.Get("key")
+	if query.Has("keyuint64") {
+		if key, err = strconv.ParseUint(query.Get("keyuint64"), 10, 64); err != nil {
+			return nil, 0, 0, errors.New("invalid uint64 key")
+		}
+	}
+	return
+}
+
+func timelineError(w http.ResponseWriter, err error) {
+	head(w)
+	jsonData, _ := json.Marshal(map[string]any{"status": false, "error_msg": err.Error()})
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Writes variable points as json, or as height,value,txid,deleted rows when format is csv
+func writePoints(w http.ResponseWriter, format string, points []structs.VariablePoint) {
+	if format != "csv" {
+		head(w)
+		jsonData, _ := json.Marshal(points)
+		fmt.Fprint(w, string(jsonData))
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	rows := csv.NewWriter(w)
+	rows.Write([]string{"height", "value", "txid", "deleted"})
+	for _, point := range points {
+		value := ""
+		if point.Value != nil {
+			value = fmt.Sprint(point.Value)
+		}
+		rows.Write([]string{strconv.FormatInt(point.Height, 10), value, point.TXID, strconv.FormatBool(point.Deleted)})
+	}
+	rows.Flush()
+}
+
 // http://localhost:8080/GetSCIDsByClass?class=tela
 func GetSCIDsByClass(w http.ResponseWriter, r *http.Request) {
 	head(w)
@@ -291,3 +449,283 @@ func GetSCsByTags(w http.ResponseWriter, r *http.Request) {
 	jsonData, _ := json.Marshal(res)
 	fmt.Fprint(w, string(jsonData))
 }
+
+// Contracts per class and per tag, for filtering
+// http://localhost:8080/GetFacets
+func GetFacets(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	jsonData, _ := json.Marshal(map[string]any{"classes": sqlite.GetClassCounts(), "tags": sqlite.GetTagCounts()})
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Returns the selective indexing policy
+// http://localhost:8080/GetIndexPolicy
+func GetIndexPolicy(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	policy := structs.IndexPolicy{}
+	val, _ := settings.LoadSetting("IndexPolicy")
+	if val != "" {
+		json.Unmarshal([]byte(val), &policy)
+	}
+	jsonData, _ := json.Marshal(policy)
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Replaces the selective indexing policy, rules are field:value with fields scid, class, owner or entrypoint
+// http://localhost:8080/SetIndexPolicy?include=class:tela&include=class:token&exclude=scid:bb43c3eb626ee767c9f305772a6666f7c7300441a0ad8538a0799eb4f12ebcd2
+func SetIndexPolicy(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	query := r.URL.Query()
+	policy := structs.IndexPolicy{
+		Include: map[string][]string{},
+		Exclude: map[string][]string{},
+	}
+	for list, rules := range map[string]map[string][]string{"include": policy.Include, "exclude": policy.Exclude} {
+		for _, rule := range query[list] {
+			field, value, found := strings.Cut(rule, ":")
+			if !found || !slices.Contains(structs.IndexPolicyFields, field) || value == "" {
+				jsonData, _ := json.Marshal(map[string]any{"status": false, "error_msg": "Invalid rule: " + rule})
+				fmt.Fprint(w, string(jsonData))
+				return
+			}
+			rules[field] = append(rules[field], value)
+		}
+	}
+	bytes, _ := json.Marshal(policy)
+	settings.SaveSetting("IndexPolicy", string(bytes))
+	PolicyChanged(policy)
+	jsonData, _ := json.Marshal(map[string]any{"status": true})
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Starts reclassifying in the background, only classes with changed filters are re-evaluated unless full is set
+// http://localhost:8080/Reclassify?full=true
+func Reclassify(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	full := r.URL.Query().Get("full") == "true"
+	jsonData, _ := json.Marshal(map[string]any{"status": ReclassifyRequested(full)})
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Progress of the background reclassification
+// http://localhost:8080/GetReclassifyStatus
+func GetReclassifyStatus(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	jsonData, _ := json.Marshal(ReclassifyProgress())
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Returns the SCIDs with the same normalized code as scid, similar=true includes the whole template family
+// http://localhost:8080/GetSCIDsWithSameCode?scid=a8a2b3f8f6b6e3e0a4c9f1ed7b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c&similar=true
+func GetSCIDsWithSameCode(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	query := r.URL.Query()
+	scid := query.Get("scid")
+	fp, _ := sqlite.GetFingerprint(scid)
+	results := sqlite.GetSCIDsWithSameCode(scid, query.Get("similar") == "true")
+	jsonData, _ := json.Marshal(map[string]any{"fingerprint": fp, "scids": results, "count": len(results)})
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Lists the code families with their member counts
+// http://localhost:8080/GetCodeFamilies
+func GetCodeFamilies(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	jsonData, _ := json.Marshal(sqlite.GetCodeFamilies())
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Returns the members of a code family
+// http://localhost:8080/GetSCIDsByFamily?family=a8a2b3f8f6b6e3e0a4c9f1ed7b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c
+func GetSCIDsByFamily(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	jsonData, _ := json.Marshal(sqlite.GetSCIDsByFamily(r.URL.Query().Get("family")))
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Swap orders newest first, filter by status (open, filled, cancelled, expired) and an offered or requested asset
+// http://localhost:8080/GetSwaps?status=open&asset=0000000000000000000000000000000000000000000000000000000000000000
+func GetSwaps(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	query := r.URL.Query()
+	jsonData, _ := json.Marshal(sqlite.GetSwaps(query.Get("status"), query.Get("asset")))
+	fmt.Fprint(w, string(jsonData))
+}
+
+// The order of a swap contract
+// http://localhost:8080/GetSwap?scid=a8a2b3f8f6b6e3e0a4c9f1ed7b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c
+func GetSwap(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	order, err := sqlite.GetSwap(r.URL.Query().Get("scid"))
+	if err != nil {
+		fmt.Fprint(w, "{}")
+		return
+	}
+	jsonData, _ := json.Marshal(order)
+	fmt.Fprint(w, string(jsonData))
+}
+
+// The address a name service name is registered to
+// http://localhost:8080/GetNameAddress?name=commando
+func GetNameAddress(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	address, _ := sqlite.GetNameAddress(r.URL.Query().Get("name"))
+	jsonData, _ := json.Marshal(address)
+	fmt.Fprint(w, string(jsonData))
+}
+
+// The names held by an address
+// http://localhost:8080/GetAddressNames?address=dero1qy...
+func GetAddressNames(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	jsonData, _ := json.Marshal(sqlite.GetAddressNames(r.URL.Query().Get("address")))
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Contracts an address deployed or invoked, newest first, paged with limit and offset
+// http://localhost:8080/GetActivityByAddress?address=dero1qy...&limit=50&offset=0
+func GetActivityByAddress(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	activity, total := sqlite.GetActivityByAddress(query.Get("address"), limit, max(offset, 0))
+	jsonData, _ := json.Marshal(map[string]any{"total": total, "activity": activity})
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Installs that failed, newest first, with the fee burnt attempting them
+// http://localhost:8080/GetInvalidSCIDDeploys
+func GetInvalidSCIDDeploys(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	jsonData, _ := json.Marshal(sqlite.GetInvalidSCIDDeploys())
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Miniblocks found by an address since indexing started
+// http://localhost:8080/GetMiniblockCountByAddress?address=dero1qy...
+func GetMiniblockCountByAddress(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	jsonData, _ := json.Marshal(sqlite.GetMiniblockCountByAddress(r.URL.Query().Get("address")))
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Blocks integrated by each address since indexing started
+// http://localhost:8080/GetIntegrators
+func GetIntegrators(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	jsonData, _ := json.Marshal(sqlite.GetIntegrators())
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Contract names, descriptions, code and string variables matching all words of q, best first, paged with limit and offset
+// http://localhost:8080/Search?q=dragon%20egg&limit=20&offset=0
+func Search(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	results, total := sqlite.SearchText(query.Get("q"), limit, max(offset, 0))
+	jsonData, _ := json.Marshal(map[string]any{"total": total, "results": results})
+	fmt.Fprint(w, string(jsonData))
+}
+
+func loadSpamPolicy() structs.SpamPolicy {
+	policy := structs.SpamPolicy{Rules: map[string]structs.SpamRule{}, MaxRegistrations: 10}
+	val, _ := settings.LoadSetting("SpamPolicy")
+	if val != "" {
+		json.Unmarshal([]byte(val), &policy)
+	}
+	return policy
+}
+
+// The spam rules by SCID and the registration txs allowed per block
+// http://localhost:8080/GetSpamPolicy
+func GetSpamPolicy(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	jsonData, _ := json.Marshal(loadSpamPolicy())
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Replaces the rule of a SCID, a rule without limits removes it. max_registrations is set when given
+// http://localhost:8080/SetSpamPolicy?scid=0000000000000000000000000000000000000000000000000000000000000001&max_invokes=5&blocks=1000&min_fee=0&allow=dero1qy...&quarantine=true
+func SetSpamPolicy(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	query := r.URL.Query()
+	policy := loadSpamPolicy()
+	if query.Has("max_registrations") {
+		max, err := strconv.Atoi(query.Get("max_registrations"))
+		if err != nil || max < 0 {
+			jsonData, _ := json.Marshal(map[string]any{"status": false, "error_msg": "Invalid max_registrations"})
+			fmt.Fprint(w, string(jsonData))
+			return
+		}
+		policy.MaxRegistrations = max
+	}
+	if scid := query.Get("scid"); scid != "" {
+		rule := structs.SpamRule{
+			Allow:      query["allow"],
+			Quarantine: query.Get("quarantine") == "true",
+		}
+		var errs []error
+		var err error
+		if query.Has("max_invokes") {
+			rule.MaxInvokes, err = strconv.Atoi(query.Get("max_invokes"))
+			errs = append(errs, err)
+		}
+		if query.Has("blocks") {
+			rule.Blocks, err = strconv.ParseInt(query.Get("blocks"), 10, 64)
+			errs = append(errs, err)
+		}
+		if query.Has("min_fee") {
+			rule.MinFee, err = strconv.ParseUint(query.Get("min_fee"), 10, 64)
+			errs = append(errs, err)
+		}
+		if err := errors.Join(errs...); err != nil || rule.MaxInvokes < 0 || rule.Blocks < 0 {
+			jsonData, _ := json.Marshal(map[string]any{"status": false, "error_msg": "Invalid rule for " + scid})
+			fmt.Fprint(w, string(jsonData))
+			return
+		}
+		if rule.MaxInvokes == 0 && rule.MinFee == 0 {
+			delete(policy.Rules, scid)
+		} else {
+			policy.Rules[scid] = rule
+		}
+	}
+	bytes, _ := json.Marshal(policy)
+	settings.SaveSetting("SpamPolicy", string(bytes))
+	SpamPolicyChanged(policy)
+	jsonData, _ := json.Marshal(map[string]any{"status": true})
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Invokes held back by a spam rule, oldest first, optionally for one SCID
+// http://localhost:8080/GetQuarantined?scid=0000000000000000000000000000000000000000000000000000000000000001
+func GetQuarantined(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	jsonData, _ := json.Marshal(sqlite.GetQuarantined(r.URL.Query().Get("scid")))
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Indexes a quarantined invoke, skipping the spam checks
+// http://localhost:8080/ReleaseQuarantined?txid=5c1f...
+func ReleaseQuarantined(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	quarantineResult(w, QuarantineRelease(r.URL.Query().Get("txid")))
+}
+
+// Deletes a quarantined invoke without indexing it
+// http://localhost:8080/DropQuarantined?txid=5c1f...
+func DropQuarantined(w http.ResponseWriter, r *http.Request) {
+	head(w)
+	quarantineResult(w, QuarantineDrop(r.URL.Query().Get("txid")))
+}
+
+func quarantineResult(w http.ResponseWriter, err error) {
+	result := map[string]any{"status": err == nil}
+	if err != nil {
+		result["error_msg"] = err.Error()
+	}
+	jsonData, _ := json.Marshal(result)
+	fmt.Fprint(w, string(jsonData))
+}
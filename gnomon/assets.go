@@ -0,0 +1,160 @@
+package gnomon
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gnomon/structs"
+)
+
+// G45 and NFA assets, read from their header, "owner" and "collection" variables
+
+// Classes treated as assets
+var AssetClasses = []string{"g45", "nfa"}
+
+var scidRegex = regexp.MustCompile(`[0-9a-f]{64}`)
+
+func isAssetClass(class string) bool {
+	for _, cl := range strings.Split(class, ",") {
+		if slices.Contains(AssetClasses, cl) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the asset details of an indexed G45 or NFA contract
+func GetAsset(scid string) (asset structs.Asset, err error) {
+	meta, err := Backend().GetSCMeta(scid)
+	if err != nil {
+		return asset, fmt.Errorf("%s is not indexed", scid)
+	}
+	if !isAssetClass(meta.Class) {
+		return asset, fmt.Errorf("%s is not a G45 or NFA asset", scid)
+	}
+	height, _ := Backend().GetLastIndexHeight()
+	vars := scStringVars(scid, height)
+
+	asset = structs.Asset{SCID: scid, Height: meta.Height, Standard: assetStandard(meta)}
+	if vars["metadata"] != "" {
+		json.Unmarshal([]byte(vars["metadata"]), &asset.Metadata)
+	}
+	asset.Name = firstValue(vars, asset.Metadata, "nameHdr", "name")
+	asset.Description = firstValue(vars, asset.Metadata, "descrHdr", "description")
+	asset.Image = firstValue(vars, asset.Metadata, "iconURLHdr", "image")
+	asset.Collection = vars["collection"]
+	asset.Creator = firstValue(vars, nil, "creatorAddr", "creator")
+	if asset.Creator == "" {
+		asset.Creator = meta.Owner
+	}
+	asset.Owner = vars["owner"]
+	if _, ok := vars["owner"]; !ok {
+		asset.Owner = meta.Owner
+	}
+	return
+}
+
+// The first tag of the asset filters the contract was tagged with
+func assetStandard(meta structs.SCMeta) string {
+	for _, tag := range strings.Split(meta.Tags, ",") {
+		for _, cl := range AssetClasses {
+			for _, t := range Filters[cl]["tags"] {
+				if strings.EqualFold(tag, t) {
+					return t
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// First non empty value of the keys in the variables, then in the metadata
+func firstValue(vars map[string]string, metadata map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if vars[key] != "" {
+			return vars[key]
+		}
+	}
+	for _, key := range keys {
+		if value, ok := metadata[key].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Assets currently owned by address
+func AssetsOwnedBy(address string) (assets []structs.Asset) {
+	scids := Backend().GetSCIDsEverOwnedBy(address)
+	// Assets without an "owner" variable belong to their deployer
+	for scid, owner := range Backend().GetAllOwnersAndSCIDs() {
+		if owner == address && !slices.Contains(scids, scid) {
+			scids = append(scids, scid)
+		}
+	}
+	for _, scid := range scids {
+		if asset, err := GetAsset(scid); err == nil && asset.Owner == address {
+			assets = append(assets, asset)
+		}
+	}
+	sortAssets(assets)
+	return
+}
+
+// Assets of a collection contract
+func CollectionAssets(collection string) (assets []structs.Asset) {
+	scids := Backend().GetSCIDsEverInCollection(collection)
+	height, _ := Backend().GetLastIndexHeight()
+	for key, value := range scStringVars(collection, height) {
+		for _, scid := range scidRegex.FindAllString(key+" "+value, -1) {
+			if scid != collection && !slices.Contains(scids, scid) {
+				scids = append(scids, scid)
+			}
+		}
+	}
+	for _, scid := range scids {
+		asset, err := GetAsset(scid)
+		if err != nil {
+			continue
+		}
+		// Dropped from the collection since
+		if asset.Collection != "" && asset.Collection != collection && scidRegex.MatchString(asset.Collection) {
+			continue
+		}
+		assets = append(assets, asset)
+	}
+	sortAssets(assets)
+	return
+}
+
+// Ownership changes of an asset, oldest first
+func AssetHistory(scid string) (transfers []structs.AssetTransfer) {
+	previous := ""
+	for i, change := range Backend().GetVariableHistory(scid, "owner") {
+		owner := ""
+		if value, ok := change.Value.(string); ok && !change.Deleted {
+			owner = value
+		}
+		if i != 0 && owner == previous {
+			continue
+		}
+		transfer := structs.AssetTransfer{Height: change.Height, TXID: change.TXID, From: previous, To: owner}
+		transfer.Signer, transfer.Entrypoint = Backend().GetInvoke(change.TXID)
+		if transfer.Entrypoint == "" && i == 0 {
+			transfer.Entrypoint = "install"
+		}
+		transfers = append(transfers, transfer)
+		previous = owner
+	}
+	return
+}
+
+func sortAssets(assets []structs.Asset) {
+	slices.SortFunc(assets, func(a, b structs.Asset) int {
+		return cmp.Or(cmp.Compare(a.Height, b.Height), strings.Compare(a.SCID, b.SCID))
+	})
+}
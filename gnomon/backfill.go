@@ -0,0 +1,259 @@
+package gnomon
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"gnomon/daemon"
+	sql "gnomon/db"
+	"gnomon/show"
+
+	"github.com/deroproject/derohe/rpc"
+)
+
+// Historical backfill, indexes the uncovered ranges below the main indexer in concurrent chunks
+
+// Blocks per worker chunk
+var BackfillChunkSize = int64(50000)
+
+// Blocks between worker checkpoints
+var BackfillCheckpoint = int64(500)
+
+type backfillRange struct {
+	Start    int64
+	End      int64
+	Progress int64
+}
+
+var backfillMutex sync.Mutex
+var backfillRanges = []*backfillRange{}
+var backfillRunning = false
+
+// Number of workers to run, 0 uses one per healthy endpoint and negative disables backfill
+func backfillWorkers() int {
+	if Config.BackfillWorkers != 0 {
+		return Config.BackfillWorkers
+	}
+	healthy := 0
+	for _, endpoint := range daemon.Endpoints {
+		if len(endpoint.Errors) == 0 {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+func backfillEnabled() bool {
+	return !UseMem && Postgres == nil && backfillWorkers() > 0
+}
+
+// Adds the unfinished part of the worker ranges to completed so the main indexer plans around them
+func withClaims(completed string) string {
+	var complete [][2]int
+	json.Unmarshal([]byte(completed), &complete)
+	for _, r := range backfillRanges {
+		if r.Progress < r.End {
+			complete = append(complete, [2]int{int(r.Progress), int(r.End)})
+		}
+	}
+	res, _ := json.Marshal(complete)
+	return string(res)
+}
+
+// Finds the next range for the main indexer, skipping ranges claimed by workers
+func planAroundClaims(starting_height int64, completed string) (int64, int64) {
+	backfillMutex.Lock()
+	defer backfillMutex.Unlock()
+	_, starting_height, ending_height := updateCompleted(starting_height, Lowest_daemon_height, withClaims(completed), 0, 0)
+	return starting_height, ending_height
+}
+
+// Returns the uncovered ranges between the lowest daemon height and the main indexer, split into chunks
+func backfillGaps(completed string, main_start int64, main_end int64) (gaps [][2]int64) {
+	var covered [][2]int64
+	var complete [][2]int
+	json.Unmarshal([]byte(completed), &complete)
+	for _, chunk := range complete {
+		covered = append(covered, [2]int64{int64(chunk[0]), int64(chunk[1])})
+	}
+	for _, r := range backfillRanges {
+		covered = append(covered, [2]int64{r.Start, r.End})
+	}
+	if main_end == -1 {
+		main_end = LatestTopoHeight
+	}
+	covered = append(covered, [2]int64{main_start, main_end})
+	sort.Slice(covered, func(i, j int) bool {
+		return covered[i][0] < covered[j][0]
+	})
+
+	// Only fill below the highest covered height, the head belongs to the main indexer
+	top := int64(0)
+	for _, chunk := range covered {
+		top = max(top, chunk[1])
+	}
+	next := Lowest_daemon_height
+	for _, chunk := range covered {
+		if chunk[0] > next {
+			gaps = append(gaps, [2]int64{next, min(chunk[0], top)})
+		}
+		next = max(next, chunk[1])
+	}
+
+	var chunks [][2]int64
+	for _, gap := range gaps {
+		for start := gap[0]; start < gap[1]; start += BackfillChunkSize {
+			chunks = append(chunks, [2]int64{start, min(start+BackfillChunkSize, gap[1])})
+		}
+	}
+	return chunks
+}
+
+// Plans and launches the backfill workers if they are not already running
+func startBackfill(main_start int64, main_end int64) {
+	if !backfillEnabled() {
+		if Postgres != nil && backfillWorkers() > 0 {
+			show.NewMessage(show.Message{Text: "Backfill workers write to sqlite files and are not used with Postgres", ShowNow: true})
+		}
+		return
+	}
+	backfillMutex.Lock()
+	if backfillRunning {
+		backfillMutex.Unlock()
+		return
+	}
+	completed, _ := Sqlite.LoadSetting("completed")
+	gaps := backfillGaps(completed, main_start, main_end)
+	if len(gaps) == 0 {
+		backfillMutex.Unlock()
+		return
+	}
+	backfillRunning = true
+	queue := make(chan *backfillRange, len(gaps))
+	for _, gap := range gaps {
+		r := &backfillRange{Start: gap[0], End: gap[1], Progress: gap[0]}
+		backfillRanges = append(backfillRanges, r)
+		queue <- r
+	}
+	close(queue)
+	backfillMutex.Unlock()
+
+	workers := min(backfillWorkers(), len(gaps))
+	show.NewMessage(show.Message{Text: "Backfill starting, ranges:", Vars: []any{gaps, "workers:", workers}})
+
+	go func() {
+		var wg sync.WaitGroup
+		for range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for r := range queue {
+					if !runBackfillRange(r) {
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		backfillMutex.Lock()
+		backfillRanges = []*backfillRange{}
+		backfillRunning = false
+		backfillMutex.Unlock()
+		show.NewMessage(show.Message{Text: "Backfill finished."})
+	}()
+}
+
+// Indexes a range, returns false if the worker should stop
+func runBackfillRange(r *backfillRange) bool {
+	// Own connection so a cancelled store doesn't stop the main indexer
+	store, err := sql.NewDiskDB(filepath.Dir(Sqlite.Db_path), filepath.Base(Sqlite.Db_path))
+	if err != nil {
+		show.NewMessage(show.Message{Text: "Backfill db error:", Err: err})
+		return false
+	}
+	defer store.DB.Close()
+	indexer := NewSQLIndexer(store, r.Start, CustomActions)
+	// Clear anything left from an interrupted attempt
+	store.TrimHeight(r.Progress, r.End)
+	ok := true
+	for bheight := r.Progress; bheight < r.End; bheight++ {
+		daemon.PauseCheck()
+		if !daemon.OK() || store.Cancel || !backfillBlock(indexer, bheight) {
+			ok = false
+			break
+		}
+		if (bheight+1-r.Start)%BackfillCheckpoint == 0 {
+			saveBackfillProgress(r, bheight+1)
+		}
+	}
+	if store.Cancel {
+		ok = false
+	}
+	if ok {
+		saveBackfillProgress(r, r.End)
+		show.NewMessage(show.Message{Text: "Backfill range complete:", Vars: []any{r.Start, r.End}})
+	} else {
+		saveBackfillProgress(r, r.Progress)
+		show.NewMessage(show.Message{Text: "Backfill range interrupted:", Vars: []any{r.Start, r.Progress, r.End}})
+	}
+	return ok
+}
+
+// Merges the finished part of a range into completed
+func saveBackfillProgress(r *backfillRange, progress int64) {
+	backfillMutex.Lock()
+	defer backfillMutex.Unlock()
+	r.Progress = progress
+	if progress == r.Start {
+		return
+	}
+	completed, _ := Sqlite.LoadSetting("completed")
+	completed, _, _ = updateCompleted(r.Start, Lowest_daemon_height, completed, int(r.Start), int(progress))
+	Sqlite.SaveSetting("completed", completed)
+}
+
+// Same steps as ProcessBlock / DoBatch without the shared block and batch bookkeeping
+func backfillBlock(indexer *Indexer, bheight int64) bool {
+	daemon.Ask("height")
+	result := daemon.GetBlockInfo(rpc.GetBlock_Params{
+		Height: uint64(bheight),
+	})
+	if !daemon.OK() {
+		return false
+	}
+	bl := daemon.GetBlockDeserialized(result.Blob)
+	storeMiners(indexer, bheight, result, bl)
+	tx_str_list, discarding := blockTxIds(bl)
+	if discarding {
+		return true
+	}
+	for start := 0; start < len(tx_str_list); start += int(batchSize) {
+		end := min(start+int(batchSize), len(tx_str_list))
+		daemon.Ask("tx")
+		r := daemon.GetTransaction(rpc.GetTransaction_Params{
+			Tx_Hashes: tx_str_list[start:end],
+		})
+		if !daemon.OK() {
+			return false
+		}
+		for i, tx_hex := range r.Txs_as_hex {
+			tx, err := decodeTx(tx_hex)
+			if err == nil {
+				indexTx(indexer, tx, r.Txs[i].Block_Height, r.Txs[i].Signer)
+			}
+		}
+	}
+	return daemon.OK()
+}
+
+// Current worker ranges for status displays
+func BackfillStatus() (ranges [][3]int64) {
+	backfillMutex.Lock()
+	defer backfillMutex.Unlock()
+	for _, r := range backfillRanges {
+		ranges = append(ranges, [3]int64{r.Start, r.Progress, r.End})
+	}
+	return
+}
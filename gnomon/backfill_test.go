@@ -0,0 +1,53 @@
+package gnomon
+
+import (
+	"slices"
+	"testing"
+)
+
+func setBackfillGlobals(t *testing.T, lowest int64, ranges ...*backfillRange) {
+	savedLowest, savedRanges := Lowest_daemon_height, backfillRanges
+	Lowest_daemon_height, backfillRanges = lowest, ranges
+	t.Cleanup(func() { Lowest_daemon_height, backfillRanges = savedLowest, savedRanges })
+}
+
+func TestPlanAroundClaims(t *testing.T) {
+	tests := []struct {
+		name      string
+		lowest    int64
+		completed string
+		claims    []*backfillRange
+		start     int64
+		end       int64
+	}{
+		{"nothing indexed", 0, "", nil, 0, -1},
+		{"after completed", 0, "[[0,1000]]", nil, 1000, -1},
+		{"up to a claim", 0, "[[0,1000]]", []*backfillRange{{Start: 5000, End: 8000, Progress: 6000}}, 1000, 6000},
+		{"past a claim joined to completed", 0, "[[0,2000]]", []*backfillRange{{Start: 2000, End: 5000, Progress: 2000}}, 5000, -1},
+		{"finished claim ignored", 0, "[[0,1000]]", []*backfillRange{{Start: 5000, End: 8000, Progress: 8000}}, 1000, -1},
+		{"pruned daemon", 500, "", []*backfillRange{{Start: 0, End: 2000, Progress: 1000}}, 500, 1000},
+	}
+	for _, test := range tests {
+		setBackfillGlobals(t, test.lowest, test.claims...)
+		start, end := planAroundClaims(test.lowest, test.completed)
+		if start != test.start || end != test.end {
+			t.Errorf("%s: planned %d-%d, want %d-%d", test.name, start, end, test.start, test.end)
+		}
+	}
+}
+
+func TestBackfillGaps(t *testing.T) {
+	setBackfillGlobals(t, 0, &backfillRange{Start: 5000, End: 6000, Progress: 5500})
+	savedChunk, savedTop := BackfillChunkSize, LatestTopoHeight
+	BackfillChunkSize, LatestTopoHeight = 1000, 10000
+	t.Cleanup(func() { BackfillChunkSize, LatestTopoHeight = savedChunk, savedTop })
+
+	want := [][2]int64{{2000, 3000}, {3000, 4000}, {4000, 5000}, {6000, 7000}, {7000, 7500}}
+	if gaps := backfillGaps("[[0,2000]]", 7500, -1); !slices.Equal(gaps, want) {
+		t.Errorf("gaps %v, want %v", gaps, want)
+	}
+	// The head above the highest covered height is left to the main indexer
+	if gaps := backfillGaps("[[0,2000]]", 3000, 4000); !slices.Equal(gaps, [][2]int64{{2000, 3000}, {4000, 5000}}) {
+		t.Errorf("gaps %v", gaps)
+	}
+}
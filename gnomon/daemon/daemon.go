@@ -675,6 +675,24 @@ func GetSC(scParam rpc.GetSC_Params) rpc.GetSC_Result {
 	return result
 }
 
+// The daemon answers an SCID it has no code for with an OK status and empty code
+var ErrNoSC = errors.New("daemon has no code for this SCID")
+
+// Like GetSC but tells an SCID without code apart from a failed request
+func GetSCChecked(scParam rpc.GetSC_Params) (rpc.GetSC_Result, error) {
+	validator := func(r rpc.GetSC_Result) bool {
+		return r.Status == "OK"
+	}
+	result := callRPC("DERO.GetSC", scParam, validator)
+	if result.Status != "OK" {
+		return result, errors.New("DERO.GetSC failed")
+	}
+	if scParam.Code && result.Code == "" {
+		return result, ErrNoSC
+	}
+	return result, nil
+}
+
 func GetSCCode(scid string) rpc.GetSC_Result {
 	return GetSC(rpc.GetSC_Params{
 		SCID:       scid,
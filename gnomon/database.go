@@ -0,0 +1,170 @@
+package gnomon
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	sql "gnomon/db"
+)
+
+// Database commands, run against the db file without starting the indexer
+
+const dbUsage = `Usage: gnomon db <command>
+  migrate [--dry-run]     apply or list the pending schema migrations
+  integrity               run sqlite's integrity check on the db file
+  vacuum [--incremental]  return free pages to the filesystem
+  prune --keep <blocks> [--scid <scid,...>] [--class <class,...>]
+                          remove variable history older than the last <blocks> blocks
+  stats                   rows and size of every table
+  reindex                 create missing indexes and rebuild all of them`
+
+// Runs a db command, args as after "gnomon db"
+func RunDB(args []string) {
+	if len(args) == 0 {
+		fmt.Println(dbUsage)
+		return
+	}
+	switch args[0] {
+	case "migrate":
+		flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+		dry := flags.Bool("dry-run", false, "list the pending migrations without applying them")
+		if flags.Parse(args[1:]) != nil {
+			return
+		}
+		migrateDB(*dry)
+		return
+	case "integrity", "vacuum", "prune", "stats", "reindex":
+		disk, err := sql.NewDiskDB(dbPathAndName())
+		if err != nil {
+			fmt.Println("Err opening db:", err)
+			return
+		}
+		defer disk.DB.Close()
+		switch args[0] {
+		case "integrity":
+			integrityDB(disk)
+		case "vacuum":
+			flags := flag.NewFlagSet("vacuum", flag.ContinueOnError)
+			incremental := flags.Bool("incremental", false, "switch to incremental auto vacuum and only release free pages")
+			if flags.Parse(args[1:]) != nil {
+				return
+			}
+			vacuumDB(disk, *incremental)
+		case "prune":
+			flags := flag.NewFlagSet("prune", flag.ContinueOnError)
+			keep := flags.Int64("keep", 0, "blocks of variable history to keep below the last indexed height")
+			scids := flags.String("scid", "", "csv of SCIDs to prune")
+			classes := flags.String("class", "", "csv of classes to prune")
+			if flags.Parse(args[1:]) != nil {
+				return
+			}
+			pruneDB(disk, *keep, *scids, *classes)
+		case "stats":
+			statsDB(disk)
+		case "reindex":
+			created, err := disk.RebuildIndexes()
+			if err != nil {
+				fmt.Println("Reindex failed:", err)
+				return
+			}
+			fmt.Println("Indexes rebuilt,", created, "created")
+		}
+		return
+	}
+	fmt.Println("Unknown db command:", args[0])
+	fmt.Println(dbUsage)
+}
+
+func migrateDB(dry bool) {
+	db_path, db_name := dbPathAndName()
+	migrations, err := sql.MigrateFile(db_path, db_name, dry)
+	for _, m := range migrations {
+		if dry {
+			fmt.Println("Pending migration", m.Version, m.Name)
+		} else {
+			fmt.Println("Applied migration", m.Version, m.Name)
+		}
+	}
+	if err != nil {
+		fmt.Println("Migration failed:", err)
+		return
+	}
+	if len(migrations) == 0 {
+		fmt.Println("Schema is up to date at version", sql.SchemaVersion)
+	}
+}
+
+func integrityDB(disk *sql.SqlStore) {
+	problems, err := disk.IntegrityCheck()
+	if err != nil {
+		fmt.Println("Integrity check failed:", err)
+		return
+	}
+	if len(problems) == 0 {
+		fmt.Println("Integrity check ok")
+		return
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	fmt.Println(len(problems), "problems found, restore a snapshot or re-index to repair the db")
+}
+
+func vacuumDB(disk *sql.SqlStore, incremental bool) {
+	fmt.Println("Vacuuming", disk.Db_path+"......")
+	freed, err := disk.Vacuum(incremental)
+	if err != nil {
+		fmt.Println("Vacuum failed:", err)
+		return
+	}
+	fmt.Println("Freed", freed>>20, "MB, db is now", fileSizeMB(disk.Db_path), "MB")
+}
+
+func pruneDB(disk *sql.SqlStore, keep int64, scids string, classes string) {
+	targets := splitList(scids)
+	if classes != "" {
+		targets = append(targets, disk.GetSCIDsByClass(splitList(classes))...)
+	}
+	if keep <= 0 || len(targets) == 0 {
+		fmt.Println("Usage: gnomon db prune --keep <blocks> [--scid <scid,...>] [--class <class,...>]")
+		return
+	}
+	last, err := disk.GetLastIndexHeight()
+	if err != nil || last <= keep {
+		fmt.Println("Nothing indexed below", keep, "blocks")
+		return
+	}
+	removed, err := disk.PruneVariables(targets, last-keep)
+	if err != nil {
+		fmt.Println("Prune failed:", err)
+		return
+	}
+	fmt.Println("Removed", removed, "rows of variable history below height", last-keep, "from", len(targets), "contracts")
+}
+
+func statsDB(disk *sql.SqlStore) {
+	var rows, bytes int64
+	estimated := false
+	for _, table := range disk.TableStats() {
+		fmt.Printf("%-24s %12d rows %10.1f MB\n", table.Name, table.Rows, float64(table.Bytes)/(1<<20))
+		rows += table.Rows
+		bytes += table.Bytes
+		estimated = estimated || table.Estimated
+	}
+	fmt.Printf("%-24s %12d rows %10.1f MB\n", "total", rows, float64(bytes)/(1<<20))
+	if estimated {
+		fmt.Println("Sizes are of the stored values only, indexes and free pages are not included")
+	}
+	fmt.Println("File size:", fileSizeMB(disk.Db_path), "MB")
+}
+
+// Non-blank items of a csv
+func splitList(csv string) (items []string) {
+	for _, item := range strings.Split(csv, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return
+}
@@ -0,0 +1,44 @@
+package sql
+
+import (
+	"fmt"
+
+	"gnomon/structs"
+)
+
+// Contracts an address deployed or invoked
+
+var activityIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS invokes_address_index ON invokes(signer,height);",
+	"CREATE INDEX IF NOT EXISTS scs_owner_index ON scs(owner,height);",
+}
+
+// Deploys and invokes of an address newest first, limit 0 for all, with the total count
+func (ss *SqlStore) GetActivityByAddress(address string, limit int, offset int) (activity []structs.Activity, total int) {
+	if limit <= 0 {
+		limit = -1
+	}
+	ss.DB.QueryRow(
+		`SELECT
+			(SELECT COUNT(*) FROM scs WHERE owner = ?) +
+			(SELECT COUNT(*) FROM invokes WHERE signer = ?);`,
+		address, address).Scan(&total)
+	rows, err := ss.DB.Query(
+		`SELECT scid, 'install', IFNULL(height,0), scid, '' FROM scs WHERE owner = ?
+		UNION ALL
+		SELECT scid, 'invoke', height, txid, IFNULL(entrypoint,'') FROM invokes WHERE signer = ?
+		ORDER BY 3 DESC, 4 ASC
+		LIMIT ? OFFSET ?;`,
+		address, address, limit, offset)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a structs.Activity
+		rows.Scan(&a.SCID, &a.Type, &a.Height, &a.TXID, &a.Entrypoint)
+		activity = append(activity, a)
+	}
+	return
+}
@@ -0,0 +1,80 @@
+package sql
+
+import (
+	"fmt"
+
+	"gnomon/structs"
+)
+
+// Asset queries over the "owner" and "collection" variables
+
+var assetsIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS scvars_owner_index ON scvars(value) WHERE key = 'owner';",
+	"CREATE INDEX IF NOT EXISTS scvars_collection_index ON scvars(value) WHERE key = 'collection';",
+}
+
+// SCIDs that have stored owner as their "owner" at any height
+func (ss *SqlStore) GetSCIDsEverOwnedBy(owner string) []string {
+	return scidsEverStoring(ss.DB, "owner", owner)
+}
+
+// SCIDs that have stored collection as their "collection" at any height
+func (ss *SqlStore) GetSCIDsEverInCollection(collection string) []string {
+	return scidsEverStoring(ss.DB, "collection", collection)
+}
+
+// Every change of a string key, oldest first
+func (ss *SqlStore) GetVariableHistory(scid string, key string) (changes []structs.VariableChange) {
+	return variableHistory(ss.DB, scid, key)
+}
+
+// Signer and entrypoint of an invoke
+func (ss *SqlStore) GetInvoke(txid string) (signer string, entrypoint string) {
+	return getInvoke(ss.DB, txid)
+}
+
+func (ss *SqlStore) GetSCMeta(scid string) (meta structs.SCMeta, err error) {
+	return getSCMeta(ss.DB, scid)
+}
+
+func scidsEverStoring(q queryer, key string, value string) []string {
+	return queryStrings(q,
+		"SELECT DISTINCT scid FROM scvars WHERE key = ? AND ktype = ? AND value = ?;",
+		key, typeString, value)
+}
+
+func variableHistory(q queryer, scid string, key string) (changes []structs.VariableChange) {
+	rows, err := q.Query(
+		`SELECT height, txid, value, vtype, deleted
+		FROM scvars
+		WHERE scid = ? AND key = ? AND ktype = ?
+		ORDER BY height ASC, sv_id ASC;`, scid, key, typeString)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			change structs.VariableChange
+			value  string
+			vtype  int
+		)
+		rows.Scan(&change.Height, &change.TXID, &value, &vtype, &change.Deleted)
+		change.Value = decodeVar(value, vtype)
+		changes = append(changes, change)
+	}
+	return
+}
+
+func getInvoke(q queryer, txid string) (signer string, entrypoint string) {
+	q.QueryRow("SELECT COALESCE(signer,''), COALESCE(entrypoint,'') FROM invokes WHERE txid = ?;", txid).Scan(&signer, &entrypoint)
+	return
+}
+
+func getSCMeta(q queryer, scid string) (meta structs.SCMeta, err error) {
+	err = q.QueryRow(
+		"SELECT scid, COALESCE(owner,''), COALESCE(height,0), COALESCE(class,''), COALESCE(tags,'') FROM scs WHERE scid = ?;",
+		scid).Scan(&meta.SCID, &meta.Owner, &meta.Height, &meta.Class, &meta.Tags)
+	return
+}
@@ -0,0 +1,162 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+
+	"gnomon/structs"
+)
+
+// Failed installs and per address miniblock counts, the counts are never trimmed
+
+const invalidDeploysSchema = "(" +
+	"scid TEXT PRIMARY KEY, " +
+	"signer TEXT, " +
+	"height INTEGER, " +
+	"fee INTEGER)"
+
+const minerBlocksSchema = "(" +
+	"height INTEGER PRIMARY KEY)"
+
+const minersSchema = "(" +
+	"address TEXT PRIMARY KEY, " +
+	"miniblocks INTEGER, " +
+	"integrated INTEGER, " +
+	"height INTEGER)"
+
+var blockStatsIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS invalid_deploys_height_index ON invalid_deploys(height);",
+	"CREATE INDEX IF NOT EXISTS miners_height_index ON miners(height);",
+}
+
+func storeInvalidDeploy(q queryer, deploy structs.InvalidDeploy) error {
+	_, err := q.Exec(
+		`INSERT INTO invalid_deploys (scid,signer,height,fee) VALUES (?,?,?,?)
+		ON CONFLICT (scid) DO UPDATE SET signer = excluded.signer, height = excluded.height, fee = excluded.fee;`,
+		deploy.SCID, deploy.Signer, deploy.Height, int64(deploy.Fee))
+	return err
+}
+
+// Counts the miniblocks of each finder and the integrator of a block, once per height
+func storeMiners(q queryer, height int64, miners []string, integrator string) (changes bool, err error) {
+	result, err := q.Exec("INSERT INTO miner_blocks (height) VALUES (?) ON CONFLICT DO NOTHING;", height)
+	if err != nil {
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		// Already counted
+		return
+	}
+	counts := map[string][2]int64{}
+	for _, miner := range miners {
+		c := counts[miner]
+		c[0]++
+		counts[miner] = c
+	}
+	if integrator != "" {
+		c := counts[integrator]
+		c[1]++
+		counts[integrator] = c
+	}
+	for address, c := range counts {
+		if _, err = q.Exec(
+			`INSERT INTO miners (address,miniblocks,integrated,height) VALUES (?,?,?,?)
+			ON CONFLICT (address) DO UPDATE SET
+				miniblocks = miners.miniblocks + excluded.miniblocks,
+				integrated = miners.integrated + excluded.integrated,
+				height = CASE WHEN excluded.height > miners.height THEN excluded.height ELSE miners.height END;`,
+			address, c[0], c[1], height); err != nil {
+			return
+		}
+	}
+	return true, nil
+}
+
+// Failed installs, newest first
+func getInvalidDeploys(q queryer) (deploys []structs.InvalidDeploy) {
+	rows, err := q.Query("SELECT scid, signer, height, fee FROM invalid_deploys ORDER BY height DESC, scid ASC;")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			deploy structs.InvalidDeploy
+			fee    int64
+		)
+		rows.Scan(&deploy.SCID, &deploy.Signer, &deploy.Height, &fee)
+		deploy.Fee = uint64(fee)
+		deploys = append(deploys, deploy)
+	}
+	return
+}
+
+func getMiniblockCount(q queryer, address string) (miniblocks int64) {
+	q.QueryRow("SELECT miniblocks FROM miners WHERE address = ?;", address).Scan(&miniblocks)
+	return
+}
+
+// Blocks integrated by each address
+func getIntegrators(q queryer) (integrators map[string]int64) {
+	integrators = map[string]int64{}
+	rows, err := q.Query("SELECT address, integrated FROM miners WHERE integrated > 0;")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			address string
+			count   int64
+		)
+		rows.Scan(&address, &count)
+		integrators[address] = count
+	}
+	return
+}
+
+// Stores an install that failed and the fee burnt attempting it
+func (ss *SqlStore) StoreInvalidSCIDDeploy(deploy structs.InvalidDeploy) error {
+	return ss.write(func(tx *sql.Tx) error {
+		return storeInvalidDeploy(tx, deploy)
+	})
+}
+
+// Counts the miniblock finders and integrator of the block at height
+func (ss *SqlStore) StoreMiners(height int64, miners []string, integrator string) (changes bool, err error) {
+	err = ss.write(func(tx *sql.Tx) error {
+		var err error
+		changes, err = storeMiners(tx, height, miners, integrator)
+		return err
+	})
+	return
+}
+
+func (ss *SqlStore) GetInvalidSCIDDeploys() (deploys []structs.InvalidDeploy) {
+	return getInvalidDeploys(ss.DB)
+}
+
+// Miniblocks found by an address
+func (ss *SqlStore) GetMiniblockCountByAddress(address string) (miniblocks int64) {
+	return getMiniblockCount(ss.DB, address)
+}
+
+func (ss *SqlStore) GetIntegrators() (integrators map[string]int64) {
+	return getIntegrators(ss.DB)
+}
+
+// Creates the block statistics tables, they fill from the next block read
+func migrateBlockStats(tx *sql.Tx) error {
+	for _, query := range append([]string{
+		"CREATE TABLE IF NOT EXISTS invalid_deploys " + invalidDeploysSchema,
+		"CREATE TABLE IF NOT EXISTS miner_blocks " + minerBlocksSchema,
+		"CREATE TABLE IF NOT EXISTS miners " + minersSchema,
+	}, blockStatsIndexes...) {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
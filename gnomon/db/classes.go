@@ -0,0 +1,125 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gnomon/structs"
+)
+
+// One row per class and tag of a contract, kept next to the csv in scs
+
+const scClassesSchema = "(" +
+	"scid TEXT, " +
+	"class TEXT, " +
+	"height INTEGER, " +
+	"PRIMARY KEY(scid,class))"
+
+const scTagsSchema = "(" +
+	"scid TEXT, " +
+	"tag TEXT, " +
+	"height INTEGER, " +
+	"PRIMARY KEY(scid,tag))"
+
+var classesIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS sc_classes_class_index ON sc_classes(class);",
+	"CREATE INDEX IF NOT EXISTS sc_tags_tag_index ON sc_tags(tag);",
+}
+
+// Distinct non-blank values of a csv
+func splitCSV(csv string) (values []string) {
+	for _, value := range strings.Split(csv, ",") {
+		if value != "" && !slices.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	return
+}
+
+// Replaces the class and tag rows of a stored contract
+func storeSCMeta(q queryer, scid, class, tags string) error {
+	for _, query := range []string{"DELETE FROM sc_classes WHERE scid = ?;", "DELETE FROM sc_tags WHERE scid = ?;"} {
+		if _, err := q.Exec(query, scid); err != nil {
+			return err
+		}
+	}
+	for _, value := range splitCSV(class) {
+		if _, err := q.Exec("INSERT INTO sc_classes (scid,class,height) SELECT scid, ?, height FROM scs WHERE scid = ?;", value, scid); err != nil {
+			return err
+		}
+	}
+	for _, value := range splitCSV(tags) {
+		if _, err := q.Exec("INSERT INTO sc_tags (scid,tag,height) SELECT scid, ?, height FROM scs WHERE scid = ?;", value, scid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches the scs rows having any value of the IN list in sc_classes or sc_tags
+func metaMatch(table, column string, list string) (where string) {
+	return "scid IN (SELECT scid FROM " + table + " WHERE " + column + " IN (" + list + "))"
+}
+
+// Contracts per value of sc_classes or sc_tags, most used first
+func metaCounts(q queryer, table, column string) (counts []structs.MetaCount) {
+	rows, err := q.Query("SELECT " + column + ", COUNT(*) FROM " + table + " GROUP BY " + column + " ORDER BY 2 DESC, 1 ASC;")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var count structs.MetaCount
+		rows.Scan(&count.Name, &count.Count)
+		counts = append(counts, count)
+	}
+	return
+}
+
+// Creates the join tables and splits the stored csv columns into them
+func migrateClasses(tx *sql.Tx) error {
+	for _, query := range append([]string{
+		"CREATE TABLE IF NOT EXISTS sc_classes " + scClassesSchema,
+		"CREATE TABLE IF NOT EXISTS sc_tags " + scTagsSchema,
+	}, classesIndexes...) {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return splitSCMeta(tx)
+}
+
+// Fills the join tables from the csv columns of scs
+func splitSCMeta(q queryer) error {
+	rows, err := q.Query("SELECT scid, COALESCE(class,''), COALESCE(tags,'') FROM scs WHERE COALESCE(class,'') != '' OR COALESCE(tags,'') != '';")
+	if err != nil {
+		return err
+	}
+	var metas []structs.SCMeta
+	for rows.Next() {
+		var meta structs.SCMeta
+		rows.Scan(&meta.SCID, &meta.Class, &meta.Tags)
+		metas = append(metas, meta)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, meta := range metas {
+		if err = storeSCMeta(q, meta.SCID, meta.Class, meta.Tags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ss *SqlStore) GetClassCounts() (counts []structs.MetaCount) {
+	return metaCounts(ss.DB, "sc_classes", "class")
+}
+
+func (ss *SqlStore) GetTagCounts() (counts []structs.MetaCount) {
+	return metaCounts(ss.DB, "sc_tags", "tag")
+}
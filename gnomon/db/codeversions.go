@@ -0,0 +1,145 @@
+package sql
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+
+	"gnomon/structs"
+)
+
+// Every distinct code of a contract with the height and txid it appeared at
+
+const codeversionsSchema = "(" +
+	"cv_id INTEGER PRIMARY KEY, " +
+	"scid TEXT, " +
+	"height INTEGER, " +
+	"txid TEXT, " +
+	"codehash TEXT, " +
+	"code TEXT)"
+
+var codeversionsIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS codeversions_scid_index ON codeversions(scid,height);",
+}
+
+func CodeHash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Stores the code if it differs from the version in place at that height, returns true if it was a new version
+func (ss *SqlStore) StoreCodeVersion(scid string, txid string, height int64, code string) (changes bool, err error) {
+	if ss.Cancel || code == "" {
+		return
+	}
+	codehash := CodeHash(code)
+	err = ss.write(func(tx *sql.Tx) error {
+		var previous string
+		err := tx.QueryRow(
+			"SELECT codehash FROM codeversions WHERE scid = ? AND height <= ? ORDER BY height DESC, cv_id DESC LIMIT 1;",
+			scid, height).Scan(&previous)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if previous == codehash {
+			return nil
+		}
+		if _, err = tx.Exec(
+			"INSERT INTO codeversions (scid,height,txid,codehash,code) VALUES (?,?,?,?,?);",
+			scid, height, txid, codehash, code); err != nil {
+			return err
+		}
+		changes = true
+		// Blocks are processed concurrently, a later row may have been recorded against an older version
+		_, err = tx.Exec(
+			`DELETE FROM codeversions
+			WHERE cv_id = (
+				SELECT cv_id FROM codeversions
+				WHERE scid = ? AND height > ?
+				ORDER BY height ASC, cv_id ASC LIMIT 1
+			) AND codehash = ?;`,
+			scid, height, codehash)
+		if err != nil {
+			return err
+		}
+		return reindexDocs(tx, contractDocs(scid)[2:], height)
+	})
+	return
+}
+
+// All versions of a contract's code, oldest first
+func (ss *SqlStore) GetSCCodeHistory(scid string) (versions []structs.CodeVersion) {
+	rows, err := ss.DB.Query(
+		`SELECT height, txid, codehash, code
+		FROM codeversions
+		WHERE scid = ?
+		ORDER BY height ASC, cv_id ASC;`, scid)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v structs.CodeVersion
+		rows.Scan(&v.Height, &v.TXID, &v.CodeHash, &v.Code)
+		versions = append(versions, v)
+	}
+	return
+}
+
+// The code in place at a height
+func (ss *SqlStore) GetSCCodeAtTopoheight(scid string, topoheight int64) (sc_code string, err error) {
+	err = ss.DB.QueryRow(
+		`SELECT code
+		FROM codeversions
+		WHERE scid = ? AND height <= ?
+		ORDER BY height DESC, cv_id DESC LIMIT 1;`,
+		scid, topoheight).Scan(&sc_code)
+	return
+}
+
+// One-time fill of the code history from the stored "C" variable changes
+func migrateCodeVersions(tx *sql.Tx) error {
+	var exists int
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name='codeversions');").Scan(&exists)
+	if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS codeversions " + codeversionsSchema); err != nil {
+		return err
+	}
+	if exists == 1 {
+		return nil
+	}
+	rows, err := tx.Query(
+		`SELECT scid, height, txid, value
+		FROM scvars
+		WHERE key = 'C' AND ktype = ? AND deleted = 0
+		ORDER BY scid, height ASC, sv_id ASC;`, typeString)
+	if err != nil {
+		return err
+	}
+	type version struct {
+		scid   string
+		height int64
+		txid   string
+		code   string
+	}
+	var versions []version
+	for rows.Next() {
+		var v version
+		rows.Scan(&v.scid, &v.height, &v.txid, &v.code)
+		versions = append(versions, v)
+	}
+	rows.Close()
+	if len(versions) == 0 {
+		return nil
+	}
+	fmt.Println("Building code history for", len(versions), "code versions...")
+	for _, v := range versions {
+		if _, err := tx.Exec(
+			"INSERT INTO codeversions (scid,height,txid,codehash,code) VALUES (?,?,?,?,?);",
+			v.scid, v.height, v.txid, CodeHash(v.code), v.code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+
+	"gnomon/structs"
+)
+
+// Code fingerprints and the template family of each installed contract
+
+const fingerprintsSchema = "(" +
+	"scid TEXT PRIMARY KEY, " +
+	"height INTEGER, " +
+	"codehash TEXT, " +
+	"simhash TEXT, " +
+	"family TEXT)"
+
+var fingerprintsIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS fingerprints_codehash_index ON fingerprints(codehash);",
+	"CREATE INDEX IF NOT EXISTS fingerprints_family_index ON fingerprints(family);",
+}
+
+func (ss *SqlStore) StoreFingerprint(scid string, height int64, codehash string, simhash string, family string) error {
+	return ss.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			"INSERT OR REPLACE INTO fingerprints (scid,height,codehash,simhash,family) VALUES (?,?,?,?,?);",
+			scid, height, codehash, simhash, family)
+		if err != nil {
+			return err
+		}
+		return ss.changed(tx, height)
+	})
+}
+
+func (ss *SqlStore) GetFingerprint(scid string) (fp structs.Fingerprint, err error) {
+	err = ss.DB.QueryRow(
+		"SELECT scid, height, codehash, simhash, family FROM fingerprints WHERE scid = ?;",
+		scid).Scan(&fp.SCID, &fp.Height, &fp.CodeHash, &fp.SimHash, &fp.Family)
+	return
+}
+
+// Family of any contract with the same normalized code
+func (ss *SqlStore) GetFamilyByCodeHash(codehash string) (family string) {
+	ss.DB.QueryRow("SELECT family FROM fingerprints WHERE codehash = ? LIMIT 1;", codehash).Scan(&family)
+	return
+}
+
+// Similarity hash of the first member of each family
+func (ss *SqlStore) GetFamilySimHashes() (families map[string]string) {
+	families = map[string]string{}
+	rows, err := ss.DB.Query(
+		`SELECT family, simhash
+		FROM fingerprints
+		WHERE family != '' AND scid = family;`)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	var family, simhash string
+	for rows.Next() {
+		rows.Scan(&family, &simhash)
+		families[family] = simhash
+	}
+	return
+}
+
+// Returns the SCIDs with the same normalized code as scid, or the same family when similar is set
+func (ss *SqlStore) GetSCIDsWithSameCode(scid string, similar bool) (results []string) {
+	fp, err := ss.GetFingerprint(scid)
+	if err != nil || fp.CodeHash == "" {
+		return
+	}
+	query := "SELECT scid FROM fingerprints WHERE codehash = ? ORDER BY height ASC;"
+	arg := fp.CodeHash
+	if similar {
+		query = "SELECT scid FROM fingerprints WHERE family = ? ORDER BY height ASC;"
+		arg = fp.Family
+	}
+	return ss.queryStrings(query, arg)
+}
+
+func (ss *SqlStore) GetSCIDsByFamily(family string) (results []string) {
+	return ss.queryStrings("SELECT scid FROM fingerprints WHERE family = ? ORDER BY height ASC;", family)
+}
+
+// Lists the families by size
+func (ss *SqlStore) GetCodeFamilies() (results []structs.CodeFamily) {
+	rows, err := ss.DB.Query(
+		`SELECT family, COUNT(*), COUNT(DISTINCT codehash), MIN(height)
+		FROM fingerprints
+		WHERE family != ''
+		GROUP BY family
+		ORDER BY COUNT(*) DESC, MIN(height) ASC;`)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f structs.CodeFamily
+		rows.Scan(&f.Family, &f.Count, &f.Variants, &f.FirstHeight)
+		results = append(results, f)
+	}
+	return
+}
+
+// SCs without a fingerprint yet
+func (ss *SqlStore) GetUnfingerprintedSCIDs(limit int) (results []structs.SCMeta) {
+	return unfingerprinted(ss.DB, limit)
+}
+
+func unfingerprinted(q queryer, limit int) (results []structs.SCMeta) {
+	rows, err := q.Query(
+		`SELECT scs.scid, COALESCE(scs.height,0)
+		FROM scs
+		LEFT JOIN fingerprints ON fingerprints.scid = scs.scid
+		WHERE fingerprints.scid IS NULL
+		ORDER BY scs.height ASC
+		LIMIT ?;`, limit)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var meta structs.SCMeta
+		rows.Scan(&meta.SCID, &meta.Height)
+		results = append(results, meta)
+	}
+	return
+}
+
+func (ss *SqlStore) queryStrings(query string, args ...any) (results []string) {
+	return queryStrings(ss.DB, query, args...)
+}
@@ -0,0 +1,7 @@
+//go:build !sqlite_fts5 && !fts5
+
+package sql
+
+// The search index is FTS5, which go-sqlite3 only has with the sqlite_fts5 tag:
+// go build -tags sqlite_fts5
+var _ = buildWithTagSqliteFTS5
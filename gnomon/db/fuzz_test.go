@@ -0,0 +1,69 @@
+package sql
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// Arbitrary SCIDs, addresses, keys and values are bound, never written into the query
+// text, so whatever is stored is found again. extra pads the IN sets, past
+// maxBoundValues they go through the temp table
+func FuzzQueries(f *testing.F) {
+	disk, err := NewDiskDB(f.TempDir(), "fuzz.db")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Cleanup(func() { disk.DB.Close() })
+	f.Add("sc", "deto1qy", "name", "token", uint16(0))
+	f.Add("sc'; DROP TABLE scs;--", "a\"b", "k'ey", "x') OR ('1'='1", uint16(600))
+	f.Add("", "", "?", "?,?,?", uint16(maxBoundValues))
+	f.Add("sc", "addr", "%_", "tag,other,tag", uint16(maxBoundValues+1))
+
+	n := 0
+	f.Fuzz(func(t *testing.T, scid string, address string, key string, value string, extra uint16) {
+		n++
+		scid = fmt.Sprintf("%s#%d", scid, n)
+		if _, err := disk.StoreOwner(scid, address, 1, value, value, value, value, value); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := disk.StoreSCIDVariableDetails(scid, "tx", vars(key, value), 10); err != nil {
+			t.Fatal(err)
+		}
+
+		if owner, _ := disk.GetSCOwnerAndClass(scid); owner != address {
+			t.Fatalf("owner %q, stored %q", owner, address)
+		}
+		if values, _ := disk.GetSCIDValuesByKey(scid, key, 10, false); !slices.Equal(values, []string{value}) {
+			t.Fatalf("values of %q = %q, stored %q", key, values, value)
+		}
+		if keys, _ := disk.GetSCIDKeysByValue(scid, value, 10, false); !slices.Contains(keys, key) {
+			t.Fatalf("keys of %q = %q, stored %q", value, keys, key)
+		}
+
+		list := splitCSV(value)
+		for i := range int(extra) % (2 * maxBoundValues) {
+			list = append(list, fmt.Sprint("filler", i))
+		}
+		if len(splitCSV(value)) != 0 {
+			if scids := disk.GetSCIDsByClass(list); !slices.Contains(scids, scid) {
+				t.Fatalf("%d classes, %q not found", len(list), scid)
+			}
+			if scids := disk.GetSCIDsByTags(list); !slices.Contains(scids, scid) {
+				t.Fatalf("%d tags, %q not found", len(list), scid)
+			}
+			if scids := disk.GetSCIDsByClassAbove(list, 0); !slices.Contains(scids, scid) {
+				t.Fatalf("%d classes above 0, %q not found", len(list), scid)
+			}
+		} else if scids := disk.GetSCIDsByClass(list); len(list) == 0 && len(scids) != 0 {
+			t.Fatalf("no classes matched %q", scids)
+		}
+
+		disk.GetSCsByTags(list)
+		disk.GetNameAddress(key)
+		disk.GetAddressNames(address)
+		disk.GetActivityByAddress(address, 10, 0)
+		disk.SearchText(value, 10, 0)
+		disk.GetSCIDVariableChanges(scid, key, 0, 10)
+	})
+}
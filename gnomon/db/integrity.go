@@ -0,0 +1,40 @@
+package sql
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// Removes the rows left above the last consistent height by an interrupted write
+
+// Tables keyed by the height a row was indexed at
+var heightTables = []string{"scs", "scvars", "checkpoints", "invokes", "interactions", "fingerprints", "codeversions", "swaps", "names", "quarantine", "sc_classes", "sc_tags", "invalid_deploys", "name_transfers"}
+
+// Removes the rows at or above the last indexed height outside the completed ranges, returns the height and rows removed
+func (ss *SqlStore) CheckIntegrity() (height int64, removed int64, err error) {
+	err = ss.write(func(tx *sql.Tx) error {
+		if tx.QueryRow("SELECT value FROM state WHERE name = 'lastindexedheight';").Scan(&height) != nil {
+			// Nothing indexed yet
+			return nil
+		}
+		var completed string
+		tx.QueryRow("SELECT value FROM settings WHERE name = 'completed';").Scan(&completed)
+		var err error
+		where, args := heightRange(height, -1)
+		filter, ranges := completedFilter(completed)
+		removed, err = deleteHeights(tx, height, where+filter, append(args, ranges...)...)
+		return err
+	})
+	return
+}
+
+// Excludes the heights of the completed ranges, [start, end) pairs
+func completedFilter(completed string) (filter string, args []any) {
+	var complete [][2]int
+	json.Unmarshal([]byte(completed), &complete)
+	for _, r := range complete {
+		filter += " AND NOT (height >= ? AND height < ?)"
+		args = append(args, r[0], r[1])
+	}
+	return
+}
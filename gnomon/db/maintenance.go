@@ -0,0 +1,156 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gnomon/structs"
+)
+
+// Integrity check, vacuum, pruning and stats of the sqlite file
+
+// Every secondary index, as the migrations and feature files create them
+func allIndexes() []string {
+	return slices.Concat(tableIndexes, varsIndexes, fingerprintsIndexes, codeversionsIndexes, assetsIndexes, swapsIndexes, namesIndexes, nameTransfersIndexes, spamIndexes, activityIndexes, searchIndexes, classesIndexes, blockStatsIndexes)
+}
+
+// Problems found by PRAGMA integrity_check, none when the file is sound. Unlike
+// CheckIntegrity this checks the file structure, not the indexed heights
+func (ss *SqlStore) IntegrityCheck() (problems []string, err error) {
+	rows, err := ss.DB.Query("PRAGMA integrity_check;")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var problem string
+		rows.Scan(&problem)
+		if problem != "ok" {
+			problems = append(problems, problem)
+		}
+	}
+	return problems, rows.Err()
+}
+
+// Returns the free pages to the filesystem and the bytes freed. Incremental switches
+// the file to auto_vacuum=incremental the first time, which takes a full vacuum, then
+// only releases the free pages
+func (ss *SqlStore) Vacuum(incremental bool) (freed int64, err error) {
+	before, _ := ss.PageUsage()
+	var mode int
+	ss.DB.QueryRow("PRAGMA auto_vacuum;").Scan(&mode)
+	switch {
+	case incremental && mode == 2:
+		// Each row of incremental_vacuum is a page freed, read them all
+		var rows *sql.Rows
+		if rows, err = ss.DB.Query("PRAGMA incremental_vacuum;"); err == nil {
+			for rows.Next() {
+			}
+			err = rows.Err()
+			rows.Close()
+		}
+	case incremental:
+		if _, err = ss.DB.Exec("PRAGMA auto_vacuum = INCREMENTAL;"); err == nil {
+			_, err = ss.DB.Exec("VACUUM;")
+		}
+	default:
+		_, err = ss.DB.Exec("VACUUM;")
+	}
+	after, _ := ss.PageUsage()
+	return before - after, err
+}
+
+// Removes the variable history of the contracts below height. The latest row of each
+// key at or below height is kept, so the state from height on is unchanged, as is the
+// installed code. Checkpoints below height are dropped, states are rebuilt from the kept rows
+func (ss *SqlStore) PruneVariables(scids []string, height int64) (removed int64, err error) {
+	err = ss.write(func(tx *sql.Tx) error {
+		for _, scid := range scids {
+			result, err := tx.Exec(
+				`DELETE FROM scvars
+				WHERE scid = ? AND height <= ? AND NOT (key = 'C' AND ktype = ?)
+				AND EXISTS (
+					SELECT 1 FROM scvars n
+					WHERE n.scid = scvars.scid AND n.key = scvars.key AND n.ktype = scvars.ktype AND n.height <= ?
+					AND (n.height > scvars.height OR (n.height = scvars.height AND n.sv_id > scvars.sv_id))
+				);`,
+				scid, height, typeString, height)
+			if err != nil {
+				return err
+			}
+			affected, _ := result.RowsAffected()
+			removed += affected
+			if result, err = tx.Exec("DELETE FROM checkpoints WHERE scid = ? AND height < ?;", scid, height); err != nil {
+				return err
+			}
+			affected, _ = result.RowsAffected()
+			removed += affected
+		}
+		return nil
+	})
+	return
+}
+
+// Rows and bytes of every table. Bytes include the table's indexes when go-sqlite3 has
+// the dbstat table, otherwise they are the size of the stored values
+func (ss *SqlStore) TableStats() (stats []structs.TableStats) {
+	names := ss.queryStrings("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name;")
+	sizes := map[string]int64{}
+	rows, dbstat := ss.DB.Query("SELECT m.tbl_name, SUM(s.pgsize) FROM dbstat s JOIN sqlite_master m ON m.name = s.name GROUP BY m.tbl_name;")
+	if dbstat == nil {
+		for rows.Next() {
+			var (
+				name string
+				size int64
+			)
+			rows.Scan(&name, &size)
+			sizes[name] = size
+		}
+		rows.Close()
+	}
+	for _, name := range names {
+		table := structs.TableStats{Name: name, Estimated: dbstat != nil}
+		ss.DB.QueryRow("SELECT COUNT(*) FROM " + quoteIdent(name) + ";").Scan(&table.Rows)
+		table.Bytes = sizes[name]
+		if dbstat != nil {
+			table.Bytes = ss.valueBytes(name)
+		}
+		stats = append(stats, table)
+	}
+	return
+}
+
+// Total length of the values stored in a table
+func (ss *SqlStore) valueBytes(table string) (size int64) {
+	columns := ss.queryStrings("SELECT name FROM pragma_table_info(?);", table)
+	if len(columns) == 0 {
+		return
+	}
+	var lengths []string
+	for _, column := range columns {
+		lengths = append(lengths, "IFNULL(LENGTH(CAST("+quoteIdent(column)+" AS BLOB)),0)")
+	}
+	ss.DB.QueryRow("SELECT IFNULL(SUM(" + strings.Join(lengths, "+") + "),0) FROM " + quoteIdent(table) + ";").Scan(&size)
+	return
+}
+
+// Creates any missing secondary index then rebuilds them all, returns the indexes created
+func (ss *SqlStore) RebuildIndexes() (created int, err error) {
+	count := func() (n int) {
+		ss.DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index';").Scan(&n)
+		return
+	}
+	before := count()
+	err = ss.write(func(tx *sql.Tx) error {
+		for _, index := range allIndexes() {
+			if _, err := tx.Exec(index); err != nil {
+				return fmt.Errorf("%v: %s", err, index)
+			}
+		}
+		_, err := tx.Exec("REINDEX;")
+		return err
+	})
+	return count() - before, err
+}
@@ -0,0 +1,1202 @@
+package sql
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gnomon/structs"
+)
+
+// In-memory store, nothing is persisted
+type MemStore struct {
+	mu           sync.RWMutex
+	settings     map[string]string
+	state        map[string]int64
+	scs          map[string]memSC
+	invokes      []memInvoke
+	interactions []memInteraction
+	scvars       map[string][]memVar
+	codeversions map[string][]structs.CodeVersion
+	fingerprints map[string]structs.Fingerprint
+	swaps        map[string]structs.SwapOrder
+	names        map[string]memName
+	transfers    map[string][]memTransfer
+	quarantine   map[string]structs.QuarantinedTx
+	invalid      map[string]structs.InvalidDeploy
+	minerBlocks  map[int64]bool
+	miners       map[string]memMiner
+}
+
+type memSC struct {
+	owner, scname, scdescr, scimgurl, class, tags string
+	height                                        int64
+}
+
+type memInvoke struct {
+	scid, signer, txid, entrypoint string
+	height                         int64
+}
+
+type memInteraction struct {
+	txid, scid string
+	height     int64
+}
+
+// One scvars row, appended in height order
+type memVar struct {
+	height  int64
+	txid    string
+	key     varKey
+	value   varValue
+	deleted bool
+}
+
+// Cumulative counts of an address, never trimmed
+type memMiner struct {
+	miniblocks, integrated int64
+}
+
+type memName struct {
+	structs.Name
+	registrant string
+}
+
+type memTransfer struct {
+	from, to string
+	height   int64
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{
+		settings:     map[string]string{},
+		state:        map[string]int64{},
+		scs:          map[string]memSC{},
+		scvars:       map[string][]memVar{},
+		codeversions: map[string][]structs.CodeVersion{},
+		fingerprints: map[string]structs.Fingerprint{},
+		swaps:        map[string]structs.SwapOrder{},
+		names:        map[string]memName{},
+		transfers:    map[string][]memTransfer{},
+		quarantine:   map[string]structs.QuarantinedTx{},
+		invalid:      map[string]structs.InvalidDeploy{},
+		minerBlocks:  map[int64]bool{},
+		miners:       map[string]memMiner{},
+	}
+}
+
+// Latest row of every key at height, deleted keys included
+func (ms *MemStore) varsAt(scid string, height int64) map[varKey]memVar {
+	latest := map[varKey]memVar{}
+	for _, v := range ms.scvars[scid] {
+		if v.height <= height {
+			latest[v.key] = v
+		}
+	}
+	return latest
+}
+
+func (ms *MemStore) stateAt(scid string, height int64) map[varKey]varValue {
+	state := map[varKey]varValue{}
+	for k, v := range ms.varsAt(scid, height) {
+		if !v.deleted {
+			state[k] = v.value
+		}
+	}
+	return state
+}
+
+func trimMap[T any](m map[string]T, height func(T) int64, trim func(int64) bool) {
+	for k, v := range m {
+		if trim(height(v)) {
+			delete(m, k)
+		}
+	}
+}
+
+// Matches a csv column against any of the values
+func csvContains(column string, values []string) bool {
+	for _, item := range strings.Split(column, ",") {
+		if slices.Contains(values, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// -- Settings and indexing progress
+
+func (ms *MemStore) SaveSetting(name, value string) {
+	ms.mu.Lock()
+	ms.settings[name] = value
+	ms.mu.Unlock()
+}
+
+func (ms *MemStore) LoadSetting(name string) (value string, err error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.settings[name], nil
+}
+
+func (ms *MemStore) SaveInitialHeight(startat int64) {
+	ms.mu.Lock()
+	ms.state["lastindexedheight"] = startat
+	ms.mu.Unlock()
+}
+
+func (ms *MemStore) SaveInitialSessionStart(startat int64) {
+	ms.mu.Lock()
+	ms.state["sessionstart"] = startat
+	ms.mu.Unlock()
+}
+
+func (ms *MemStore) LoadState(name string) (value int, err error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return int(ms.state[name]), nil
+}
+
+func (ms *MemStore) StoreSessionStart(start int64) {
+	ms.SaveInitialSessionStart(start)
+}
+
+func (ms *MemStore) StoreLastIndexHeight(last_indexedheight int64) (changes bool, err error) {
+	ms.SaveInitialHeight(last_indexedheight)
+	return true, nil
+}
+
+func (ms *MemStore) GetLastIndexHeight() (topoheight int64, err error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return max(ms.state["lastindexedheight"], 0), nil
+}
+
+func (ms *MemStore) TrimHeight(start int64, end int64) int64 {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	trim := func(height int64) bool {
+		return height >= start && (end == -1 || height < end)
+	}
+	trimMap(ms.scs, func(v memSC) int64 { return v.height }, trim)
+	trimMap(ms.fingerprints, func(v structs.Fingerprint) int64 { return v.Height }, trim)
+	trimMap(ms.swaps, func(v structs.SwapOrder) int64 { return v.Height }, trim)
+	trimMap(ms.names, func(v memName) int64 { return v.Height }, trim)
+	for name, transfers := range ms.transfers {
+		ms.transfers[name] = slices.DeleteFunc(transfers, func(t memTransfer) bool { return trim(t.height) })
+	}
+	trimMap(ms.quarantine, func(v structs.QuarantinedTx) int64 { return v.Height }, trim)
+	trimMap(ms.invalid, func(v structs.InvalidDeploy) int64 { return v.Height }, trim)
+	ms.invokes = slices.DeleteFunc(ms.invokes, func(i memInvoke) bool { return trim(i.height) })
+	ms.interactions = slices.DeleteFunc(ms.interactions, func(i memInteraction) bool { return trim(i.height) })
+	for scid, vars := range ms.scvars {
+		// The first change set kept after trimmed ones becomes a full diff, like rebaseChanges
+		type rebase struct {
+			height int64
+			txid   string
+			txids  map[varKey]string
+			state  map[varKey]varValue
+		}
+		var rebases []rebase
+		gap := false
+		for _, v := range vars {
+			if trim(v.height) {
+				gap = true
+			} else if gap {
+				txids, first := memTxids(vars, v.height)
+				rebases = append(rebases, rebase{v.height, first, txids, ms.stateAt(scid, v.height)})
+				gap = false
+			}
+		}
+		ms.scvars[scid] = slices.DeleteFunc(vars, func(v memVar) bool { return trim(v.height) })
+		for _, r := range rebases {
+			prev := ms.stateAt(scid, r.height-1)
+			kept := slices.DeleteFunc(ms.scvars[scid], func(v memVar) bool { return v.height == r.height })
+			kept = append(kept, memDiff(r.txid, r.txids, r.height, prev, r.state)...)
+			sort.SliceStable(kept, func(i, j int) bool { return kept[i].height < kept[j].height })
+			ms.scvars[scid] = kept
+		}
+	}
+	for scid, versions := range ms.codeversions {
+		ms.codeversions[scid] = slices.DeleteFunc(versions, func(v structs.CodeVersion) bool { return trim(v.Height) })
+	}
+	return start
+}
+
+// -- Indexing
+
+func (ms *MemStore) StoreOwner(scid string, owner string, height int, scname string, scdescr string, scimgurl string, class string, tags string) (changes bool, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.scs[scid]; ok {
+		return false, errors.New("scid already stored: " + scid)
+	}
+	ms.scs[scid] = memSC{owner, scname, scdescr, scimgurl, class, tags, int64(height)}
+	return true, nil
+}
+
+func (ms *MemStore) StoreSCIDInvoke(scidstoadd structs.SCIDToIndexStage, height int64) (changes bool, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if slices.ContainsFunc(ms.invokes, func(i memInvoke) bool { return i.txid == scidstoadd.TXHash }) {
+		return
+	}
+	ms.invokes = append(ms.invokes, memInvoke{scidstoadd.Params.SCID, scidstoadd.Fsi.Signer, scidstoadd.TXHash, scidstoadd.Entrypoint, height})
+	return true, nil
+}
+
+func (ms *MemStore) StoreSCIDInteractionHeight(scidstoadd structs.SCIDToIndexStage, height int64) (changes bool, err error) {
+	if scidstoadd.Type == "install" {
+		return
+	}
+	ms.mu.Lock()
+	ms.interactions = append(ms.interactions, memInteraction{scidstoadd.TXHash, scidstoadd.Params.SCID, height})
+	ms.mu.Unlock()
+	return true, nil
+}
+
+func (ms *MemStore) StoreSCIDVariableDetails(scid string, txid string, variables []*structs.SCIDVariable, topoheight int64) (changes bool, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	current := toState(variables)
+	prev := ms.stateAt(scid, topoheight)
+	vars := ms.scvars[scid]
+	// A later change set already stored is re-diffed against this state, like storeChanges
+	i := sort.Search(len(vars), func(i int) bool { return vars[i].height > topoheight })
+	if i < len(vars) {
+		next := vars[i].height
+		nexttxids, nexttxid := memTxids(vars, next)
+		nextstate := ms.stateAt(scid, next)
+		vars = slices.DeleteFunc(vars, func(v memVar) bool { return v.height == next })
+		vars = append(vars, memDiff(nexttxid, nexttxids, next, current, nextstate)...)
+	}
+	vars = append(vars, memDiff(txid, nil, topoheight, prev, current)...)
+	sort.SliceStable(vars, func(i, j int) bool { return vars[i].height < vars[j].height })
+	ms.scvars[scid] = vars
+	return true, nil
+}
+
+// Rows for the keys that differ between two states
+func memDiff(txid string, txids map[varKey]string, height int64, from, to map[varKey]varValue) (rows []memVar) {
+	txidOf := func(k varKey) string {
+		if t, ok := txids[k]; ok {
+			return t
+		}
+		return txid
+	}
+	for k, v := range to {
+		if p, ok := from[k]; !ok || p != v {
+			rows = append(rows, memVar{height: height, txid: txidOf(k), key: k, value: v})
+		}
+	}
+	for k := range from {
+		if _, ok := to[k]; !ok {
+			rows = append(rows, memVar{height: height, txid: txidOf(k), key: k, deleted: true})
+		}
+	}
+	return
+}
+
+// The txid of every key in the change set at height, like changeTxids
+func memTxids(vars []memVar, height int64) (txids map[varKey]string, first string) {
+	txids = map[varKey]string{}
+	for _, v := range vars {
+		if v.height == height {
+			if first == "" {
+				first = v.txid
+			}
+			txids[v.key] = v.txid
+		}
+	}
+	return
+}
+
+func (ms *MemStore) StoreCodeVersion(scid string, txid string, height int64, code string) (changes bool, err error) {
+	if code == "" {
+		return
+	}
+	codehash := CodeHash(code)
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	versions := ms.codeversions[scid]
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].Height > height })
+	if i > 0 && versions[i-1].CodeHash == codehash {
+		return
+	}
+	// The next version becomes redundant when it has the same code
+	if i < len(versions) && versions[i].CodeHash == codehash {
+		versions = slices.Delete(versions, i, i+1)
+	}
+	ms.codeversions[scid] = slices.Insert(versions, i, structs.CodeVersion{Height: height, TXID: txid, CodeHash: codehash, Code: code})
+	return true, nil
+}
+
+func (ms *MemStore) StoreFingerprint(scid string, height int64, codehash string, simhash string, family string) error {
+	ms.mu.Lock()
+	ms.fingerprints[scid] = structs.Fingerprint{SCID: scid, Height: height, CodeHash: codehash, SimHash: simhash, Family: family}
+	ms.mu.Unlock()
+	return nil
+}
+
+func (ms *MemStore) StoreSwap(order structs.SwapOrder) error {
+	ms.mu.Lock()
+	if stored, ok := ms.swaps[order.SCID]; !ok || order.Height >= stored.Height {
+		ms.swaps[order.SCID] = order
+	}
+	ms.mu.Unlock()
+	return nil
+}
+
+func (ms *MemStore) StoreInvalidSCIDDeploy(deploy structs.InvalidDeploy) error {
+	ms.mu.Lock()
+	ms.invalid[deploy.SCID] = deploy
+	ms.mu.Unlock()
+	return nil
+}
+
+func (ms *MemStore) StoreMiners(height int64, miners []string, integrator string) (changes bool, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.minerBlocks[height] {
+		return
+	}
+	ms.minerBlocks[height] = true
+	for _, miner := range miners {
+		m := ms.miners[miner]
+		m.miniblocks++
+		ms.miners[miner] = m
+	}
+	if integrator != "" {
+		m := ms.miners[integrator]
+		m.integrated++
+		ms.miners[integrator] = m
+	}
+	return true, nil
+}
+
+func (ms *MemStore) StoreName(name string, address string, height int64, txid string) (changes bool, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if NamesPerAddress > 0 {
+		count := 0
+		for _, n := range ms.names {
+			if n.Address == address {
+				count++
+			}
+		}
+		if count >= NamesPerAddress {
+			return
+		}
+	}
+	if n, ok := ms.names[name]; ok && n.Height <= height {
+		return
+	}
+	ms.names[name] = memName{structs.Name{Name: name, Address: address, Height: height, TXID: txid}, address}
+	ms.resolveName(name)
+	return true, nil
+}
+
+func (ms *MemStore) TransferName(name string, from string, to string, height int64) (changes bool, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	transfer := memTransfer{from, to, height}
+	if slices.Contains(ms.transfers[name], transfer) {
+		return
+	}
+	transfers := append(ms.transfers[name], transfer)
+	sort.SliceStable(transfers, func(i, j int) bool { return transfers[i].height < transfers[j].height })
+	ms.transfers[name] = transfers
+	return ms.resolveName(name), nil
+}
+
+// Replays the transfers of a name from its registrant, like resolveName
+func (ms *MemStore) resolveName(name string) (changes bool) {
+	n, ok := ms.names[name]
+	if !ok {
+		return
+	}
+	address := n.registrant
+	for _, t := range ms.transfers[name] {
+		if t.height >= n.Height && t.from == address {
+			address = t.to
+		}
+	}
+	changes = n.Address != address
+	n.Address = address
+	ms.names[name] = n
+	return
+}
+
+func (ms *MemStore) UpdateSCMeta(scid, class, tags string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if sc, ok := ms.scs[scid]; ok {
+		sc.class, sc.tags = class, tags
+		ms.scs[scid] = sc
+	}
+}
+
+func (ms *MemStore) GetSCOwnerAndClass(scid string) (owner string, class string) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	sc := ms.scs[scid]
+	return sc.owner, sc.class
+}
+
+func (ms *MemStore) GetFamilyByCodeHash(codehash string) (family string) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, fp := range ms.fingerprints {
+		if fp.CodeHash == codehash {
+			return fp.Family
+		}
+	}
+	return
+}
+
+func (ms *MemStore) GetFamilySimHashes() (families map[string]string) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	families = map[string]string{}
+	for scid, fp := range ms.fingerprints {
+		if fp.Family != "" && fp.Family == scid {
+			families[fp.Family] = fp.SimHash
+		}
+	}
+	return
+}
+
+// -- Spam control
+
+func (ms *MemStore) CountSignerInvokes(scid string, signer string, from int64, to int64) (count int) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, i := range ms.invokes {
+		if i.scid == scid && i.signer == signer && i.height >= from && i.height <= to {
+			count++
+		}
+	}
+	for _, q := range ms.quarantine {
+		if q.SCID == scid && q.Signer == signer && q.Height >= from && q.Height <= to {
+			count++
+		}
+	}
+	return
+}
+
+func (ms *MemStore) GetInvokesOverLimit(scid string, max int) (txs []structs.QuarantinedTx) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	var invokes []memInvoke
+	for _, i := range ms.invokes {
+		if i.scid == scid && i.signer != "" {
+			invokes = append(invokes, i)
+		}
+	}
+	sort.SliceStable(invokes, func(a, b int) bool { return invokes[a].height < invokes[b].height })
+	seen := map[string]int{}
+	for _, i := range invokes {
+		if seen[i.signer]++; seen[i.signer] > max {
+			txs = append(txs, structs.QuarantinedTx{TXID: i.txid, SCID: scid, Signer: i.signer, Height: i.height, Entrypoint: i.entrypoint})
+		}
+	}
+	return
+}
+
+func (ms *MemStore) RemoveInvokes(txs []structs.QuarantinedTx, quarantine bool) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, tx := range txs {
+		ms.invokes = slices.DeleteFunc(ms.invokes, func(i memInvoke) bool { return i.txid == tx.TXID })
+		ms.scvars[tx.SCID] = slices.DeleteFunc(ms.scvars[tx.SCID], func(v memVar) bool { return v.txid == tx.TXID })
+		if quarantine {
+			ms.quarantine[tx.TXID] = tx
+		}
+	}
+	return nil
+}
+
+func (ms *MemStore) StoreQuarantined(tx structs.QuarantinedTx) error {
+	ms.mu.Lock()
+	ms.quarantine[tx.TXID] = tx
+	ms.mu.Unlock()
+	return nil
+}
+
+func (ms *MemStore) GetQuarantined(scid string) (txs []structs.QuarantinedTx) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, tx := range ms.quarantine {
+		if scid == "" || tx.SCID == scid {
+			txs = append(txs, tx)
+		}
+	}
+	sort.SliceStable(txs, func(i, j int) bool { return txs[i].Height < txs[j].Height })
+	return
+}
+
+func (ms *MemStore) GetQuarantinedTx(txid string) (tx structs.QuarantinedTx, err error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	tx, ok := ms.quarantine[txid]
+	if !ok {
+		err = errors.New("not quarantined: " + txid)
+	}
+	return
+}
+
+func (ms *MemStore) DeleteQuarantined(txid string) error {
+	ms.mu.Lock()
+	delete(ms.quarantine, txid)
+	ms.mu.Unlock()
+	return nil
+}
+
+// -- Queries
+
+func (ms *MemStore) GetAllOwnersAndSCIDs() map[string]string {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	results := make(map[string]string)
+	for scid, sc := range ms.scs {
+		results[scid] = sc.owner
+	}
+	return results
+}
+
+func (ms *MemStore) GetSCMeta(scid string) (meta structs.SCMeta, err error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	sc, ok := ms.scs[scid]
+	if !ok {
+		return meta, errors.New("scid not stored: " + scid)
+	}
+	return structs.SCMeta{SCID: scid, Owner: sc.owner, Height: sc.height, Class: sc.class, Tags: sc.tags}, nil
+}
+
+// SC metadata ordered by scid, after the given scid
+func (ms *MemStore) GetSCMetaAfter(after string, limit int) (results []structs.SCMeta) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for scid, sc := range ms.scs {
+		if scid > after {
+			results = append(results, structs.SCMeta{SCID: scid, Owner: sc.owner, Class: sc.class, Tags: sc.tags})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].SCID < results[j].SCID })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return
+}
+
+func (ms *MemStore) CountSCs() (count int) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return len(ms.scs)
+}
+
+// SCs without a fingerprint, oldest first
+func (ms *MemStore) GetUnfingerprintedSCIDs(limit int) (results []structs.SCMeta) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for scid, sc := range ms.scs {
+		if _, ok := ms.fingerprints[scid]; !ok {
+			results = append(results, structs.SCMeta{SCID: scid, Height: sc.height})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Height != results[j].Height {
+			return results[i].Height < results[j].Height
+		}
+		return results[i].SCID < results[j].SCID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return
+}
+
+func (ms *MemStore) GetUndecodedSwaps() (results []string) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for scid, sc := range ms.scs {
+		if _, ok := ms.swaps[scid]; !ok && csvContains(sc.class, []string{"swaps"}) {
+			results = append(results, scid)
+		}
+	}
+	return
+}
+
+func (ms *MemStore) GetSCIDsByClass(class_list []string) (results []string) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for scid, sc := range ms.scs {
+		if csvContains(sc.class, class_list) {
+			results = append(results, scid)
+		}
+	}
+	return
+}
+
+func (ms *MemStore) GetSCIDsByClassAbove(class_list []string, height int64) (results []string) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for scid, sc := range ms.scs {
+		if sc.height > height && csvContains(sc.class, class_list) {
+			results = append(results, scid)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if a, b := ms.scs[results[i]].height, ms.scs[results[j]].height; a != b {
+			return a < b
+		}
+		return results[i] < results[j]
+	})
+	return
+}
+
+func (ms *MemStore) GetSCIDsByTags(tags_list []string) (results []string) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for scid, sc := range ms.scs {
+		if csvContains(sc.tags, tags_list) {
+			results = append(results, scid)
+		}
+	}
+	return
+}
+
+func (ms *MemStore) GetSCsByTags(tags_list []string) (results []map[string]any) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for scid, sc := range ms.scs {
+		if !csvContains(sc.tags, tags_list) {
+			continue
+		}
+		results = append(results, map[string]any{
+			"scid":     scid,
+			"owner":    sc.owner,
+			"height":   int(sc.height),
+			"scname":   sc.scname,
+			"scdescr":  sc.scdescr,
+			"scimgurl": sc.scimgurl,
+			"class":    sc.class,
+			"tags":     sc.tags,
+		})
+	}
+	return
+}
+
+func (ms *MemStore) GetClassCounts() (counts []structs.MetaCount) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.metaCounts(func(sc memSC) string { return sc.class })
+}
+
+func (ms *MemStore) GetTagCounts() (counts []structs.MetaCount) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.metaCounts(func(sc memSC) string { return sc.tags })
+}
+
+func (ms *MemStore) GetInvalidSCIDDeploys() (deploys []structs.InvalidDeploy) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, deploy := range ms.invalid {
+		deploys = append(deploys, deploy)
+	}
+	sort.SliceStable(deploys, func(i, j int) bool {
+		if deploys[i].Height != deploys[j].Height {
+			return deploys[i].Height > deploys[j].Height
+		}
+		return deploys[i].SCID < deploys[j].SCID
+	})
+	return
+}
+
+func (ms *MemStore) GetMiniblockCountByAddress(address string) (miniblocks int64) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.miners[address].miniblocks
+}
+
+func (ms *MemStore) GetIntegrators() (integrators map[string]int64) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	integrators = map[string]int64{}
+	for address, m := range ms.miners {
+		if m.integrated > 0 {
+			integrators[address] = m.integrated
+		}
+	}
+	return
+}
+
+// Contracts per value of a csv column, most used first
+func (ms *MemStore) metaCounts(column func(memSC) string) (counts []structs.MetaCount) {
+	count := map[string]int{}
+	for _, sc := range ms.scs {
+		for _, value := range splitCSV(column(sc)) {
+			count[value]++
+		}
+	}
+	for name, n := range count {
+		counts = append(counts, structs.MetaCount{Name: name, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	return
+}
+
+func (ms *MemStore) GetInitialSCIDCode(scid string) (sc_code string, err error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, v := range ms.scvars[scid] {
+		if v.key == (varKey{"C", typeString}) && !v.deleted {
+			return v.value.value, nil
+		}
+	}
+	return "", errors.New("no code for " + scid)
+}
+
+func (ms *MemStore) GetSC(scid string) (sc_code string, hVars []*structs.SCIDVariable) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	state := ms.stateAt(scid, maxHeight)
+	return state[varKey{"C", typeString}].value, fromState(state)
+}
+
+func (ms *MemStore) GetSCCodeHistory(scid string) (versions []structs.CodeVersion) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return slices.Clone(ms.codeversions[scid])
+}
+
+func (ms *MemStore) GetSCCodeAtTopoheight(scid string, topoheight int64) (sc_code string, err error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	versions := ms.codeversions[scid]
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].Height > topoheight })
+	if i == 0 {
+		return "", errors.New("no code for " + scid)
+	}
+	return versions[i-1].Code, nil
+}
+
+func (ms *MemStore) GetSCIDVariableDetailsAtTopoheight(scid string, topoheight int64) (hVars []*structs.SCIDVariable) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return fromState(ms.stateAt(scid, topoheight))
+}
+
+func (ms *MemStore) GetAllSCIDVariableDetails(scid string) (hVars []*structs.SCIDVariable) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	// Last stored value of every key, deleted or not
+	latest := map[varKey]varValue{}
+	for _, v := range ms.scvars[scid] {
+		if !v.deleted {
+			latest[v.key] = v.value
+		}
+	}
+	return fromState(latest)
+}
+
+func (ms *MemStore) GetSCIDVariableHeight(scid string, rmax bool) (scidinteractions int64) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	vars := ms.scvars[scid]
+	if len(vars) == 0 {
+		return 0
+	}
+	if rmax {
+		return vars[len(vars)-1].height
+	}
+	return vars[0].height
+}
+
+// SCIDs that have stored value as key at any height
+func (ms *MemStore) scidsEverStoring(key string, value string) (results []string) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	k := varKey{key, typeString}
+	for scid, vars := range ms.scvars {
+		if slices.ContainsFunc(vars, func(v memVar) bool { return v.key == k && v.value.value == value }) {
+			results = append(results, scid)
+		}
+	}
+	return
+}
+
+func (ms *MemStore) GetSCIDsEverOwnedBy(owner string) []string {
+	return ms.scidsEverStoring("owner", owner)
+}
+
+func (ms *MemStore) GetSCIDsEverInCollection(collection string) []string {
+	return ms.scidsEverStoring("collection", collection)
+}
+
+func (ms *MemStore) GetVariableHistory(scid string, key string) (changes []structs.VariableChange) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	k := varKey{key, typeString}
+	for _, v := range ms.scvars[scid] {
+		if v.key == k {
+			changes = append(changes, structs.VariableChange{Height: v.height, TXID: v.txid, Value: decodeVar(v.value.value, v.value.vtype), Deleted: v.deleted})
+		}
+	}
+	return
+}
+
+func (ms *MemStore) GetInvoke(txid string) (signer string, entrypoint string) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, i := range ms.invokes {
+		if i.txid == txid {
+			return i.signer, i.entrypoint
+		}
+	}
+	return
+}
+
+func (ms *MemStore) GetSCIDInteractionHeight(scid string) (scidinteractions []int64) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, i := range ms.interactions {
+		if i.txid == scid {
+			scidinteractions = append(scidinteractions, i.height)
+		}
+	}
+	return
+}
+
+func (ms *MemStore) lookupHeight(scid string, height int64, rmax bool) int64 {
+	if rmax {
+		return maxHeight
+	}
+	if height > 0 {
+		return height
+	}
+	if vars := ms.scvars[scid]; len(vars) != 0 {
+		return vars[0].height
+	}
+	return 0
+}
+
+func (ms *MemStore) GetSCIDValuesByKey(scid string, key interface{}, height int64, rmax bool) (valuesstring []string, valuesuint64 []uint64) {
+	k, ktype, ok := encodeVar(key)
+	if !ok {
+		return
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	v, ok := ms.stateAt(scid, ms.lookupHeight(scid, height, rmax))[varKey{k, ktype}]
+	if !ok {
+		return
+	}
+	switch v := decodeVar(v.value, v.vtype).(type) {
+	case uint64:
+		valuesuint64 = append(valuesuint64, v)
+	case string:
+		valuesstring = append(valuesstring, v)
+	}
+	return
+}
+
+func (ms *MemStore) GetSCIDKeysByValue(scid string, val interface{}, height int64, rmax bool) (keysstring []string, keysuint64 []uint64) {
+	v, vtype, ok := encodeVar(val)
+	if !ok {
+		return
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for k, value := range ms.stateAt(scid, ms.lookupHeight(scid, height, rmax)) {
+		if value != (varValue{v, vtype}) {
+			continue
+		}
+		switch k := decodeVar(k.key, k.ktype).(type) {
+		case uint64:
+			keysuint64 = append(keysuint64, k)
+		case string:
+			keysstring = append(keysstring, k)
+		}
+	}
+	return
+}
+
+// Change rows of a key up to height, in the order they were written
+func (ms *MemStore) keyChanges(scid string, key any, to int64) (changes []structs.VariablePoint) {
+	k, ktype, ok := encodeVar(key)
+	if !ok {
+		return
+	}
+	for _, v := range ms.scvars[scid] {
+		if v.key != (varKey{k, ktype}) || v.height > to {
+			continue
+		}
+		point := structs.VariablePoint{Height: v.height, TXID: v.txid, Deleted: v.deleted}
+		if !v.deleted {
+			point.Value = decodeVar(v.value.value, v.value.vtype)
+		}
+		changes = append(changes, point)
+	}
+	return
+}
+
+func (ms *MemStore) GetSCIDVariableTimeline(scid string, key any, from int64, to int64, step int64) (points []structs.VariablePoint) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return sampleChanges(ms.keyChanges(scid, key, to), from, to, step)
+}
+
+func (ms *MemStore) GetSCIDVariableChanges(scid string, key any, from int64, to int64) (changes []structs.VariablePoint) {
+	if from < 0 {
+		return
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, change := range ms.keyChanges(scid, key, to) {
+		if change.Height >= from {
+			changes = append(changes, change)
+		}
+	}
+	return
+}
+
+func (ms *MemStore) GetFingerprint(scid string) (fp structs.Fingerprint, err error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	fp, ok := ms.fingerprints[scid]
+	if !ok {
+		err = errors.New("no fingerprint for " + scid)
+	}
+	return
+}
+
+// SCIDs of the matching fingerprints oldest first
+func (ms *MemStore) fingerprintsWhere(match func(structs.Fingerprint) bool) (results []string) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	var fps []structs.Fingerprint
+	for _, fp := range ms.fingerprints {
+		if match(fp) {
+			fps = append(fps, fp)
+		}
+	}
+	sort.SliceStable(fps, func(i, j int) bool { return fps[i].Height < fps[j].Height })
+	for _, fp := range fps {
+		results = append(results, fp.SCID)
+	}
+	return
+}
+
+func (ms *MemStore) GetSCIDsWithSameCode(scid string, similar bool) (results []string) {
+	fp, err := ms.GetFingerprint(scid)
+	if err != nil || fp.CodeHash == "" {
+		return
+	}
+	if similar {
+		return ms.GetSCIDsByFamily(fp.Family)
+	}
+	return ms.fingerprintsWhere(func(f structs.Fingerprint) bool { return f.CodeHash == fp.CodeHash })
+}
+
+func (ms *MemStore) GetSCIDsByFamily(family string) (results []string) {
+	return ms.fingerprintsWhere(func(f structs.Fingerprint) bool { return f.Family == family })
+}
+
+func (ms *MemStore) GetCodeFamilies() (results []structs.CodeFamily) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	families := map[string]*structs.CodeFamily{}
+	hashes := map[string]map[string]bool{}
+	for _, fp := range ms.fingerprints {
+		if fp.Family == "" {
+			continue
+		}
+		f, ok := families[fp.Family]
+		if !ok {
+			f = &structs.CodeFamily{Family: fp.Family, FirstHeight: fp.Height}
+			families[fp.Family] = f
+			hashes[fp.Family] = map[string]bool{}
+		}
+		f.Count++
+		f.FirstHeight = min(f.FirstHeight, fp.Height)
+		hashes[fp.Family][fp.CodeHash] = true
+	}
+	for family, f := range families {
+		f.Variants = len(hashes[family])
+		results = append(results, *f)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Count != results[j].Count {
+			return results[i].Count > results[j].Count
+		}
+		return results[i].FirstHeight < results[j].FirstHeight
+	})
+	return
+}
+
+func (ms *MemStore) GetSwap(scid string) (order structs.SwapOrder, err error) {
+	height, _ := ms.GetLastIndexHeight()
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	order, ok := ms.swaps[scid]
+	if !ok {
+		return order, errors.New("no swap order for " + scid)
+	}
+	return expireSwap(order, height, time.Now().Unix()), nil
+}
+
+func (ms *MemStore) GetSwaps(status string, asset string) (orders []structs.SwapOrder) {
+	height, _ := ms.GetLastIndexHeight()
+	now := time.Now().Unix()
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, order := range ms.swaps {
+		if asset != "" && order.OfferAsset != asset && order.RequestAsset != asset {
+			continue
+		}
+		if order = expireSwap(order, height, now); status == "" || order.Status == status {
+			orders = append(orders, order)
+		}
+	}
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].Height > orders[j].Height })
+	return
+}
+
+func (ms *MemStore) GetNameAddress(name string) (address string, err error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	n, ok := ms.names[name]
+	if !ok {
+		return "", errors.New("name not registered: " + name)
+	}
+	return n.Address, nil
+}
+
+func (ms *MemStore) GetAddressNames(address string) (names []structs.Name) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, n := range ms.names {
+		if n.Address == address {
+			names = append(names, n.Name)
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool { return names[i].Height < names[j].Height })
+	return
+}
+
+func (ms *MemStore) GetActivityByAddress(address string, limit int, offset int) (activity []structs.Activity, total int) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for scid, sc := range ms.scs {
+		if sc.owner == address {
+			activity = append(activity, structs.Activity{SCID: scid, Type: "install", Height: sc.height, TXID: scid})
+		}
+	}
+	for _, i := range ms.invokes {
+		if i.signer == address {
+			activity = append(activity, structs.Activity{SCID: i.scid, Type: "invoke", Height: i.height, TXID: i.txid, Entrypoint: i.entrypoint})
+		}
+	}
+	sort.SliceStable(activity, func(i, j int) bool {
+		if activity[i].Height != activity[j].Height {
+			return activity[i].Height > activity[j].Height
+		}
+		return activity[i].TXID < activity[j].TXID
+	})
+	total = len(activity)
+	activity = activity[min(max(offset, 0), total):]
+	if limit > 0 {
+		activity = activity[:min(limit, len(activity))]
+	}
+	return
+}
+
+// -- Search
+
+// Scans the current values on every query, there's no index to keep up to date
+func (ms *MemStore) SearchText(query string, limit int, offset int) (results []structs.SearchResult, total int) {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	match := func(scid, field, key string, text string, height int64, weight int) {
+		lower := strings.ToLower(text)
+		hits := 0
+		for _, word := range words {
+			n := strings.Count(lower, word)
+			if n == 0 {
+				return
+			}
+			hits += n
+		}
+		results = append(results, structs.SearchResult{
+			SCID:    scid,
+			Name:    ms.scs[scid].scname,
+			Field:   field,
+			Key:     key,
+			Height:  height,
+			Snippet: memSnippet(text, lower, words[0]),
+			Score:   float64(hits * weight),
+		})
+	}
+	for scid, sc := range ms.scs {
+		match(scid, fieldName, "", sc.scname, sc.height, 4)
+		match(scid, fieldDescr, "", sc.scdescr, sc.height, 2)
+		if versions := ms.codeversions[scid]; len(versions) != 0 {
+			latest := versions[len(versions)-1]
+			match(scid, fieldCode, "", latest.Code, latest.Height, 1)
+		}
+	}
+	for scid := range ms.scvars {
+		for k, v := range ms.varsAt(scid, maxHeight) {
+			if !v.deleted && v.value.vtype == typeString {
+				match(scid, fieldVar, k.key, v.value.value, v.height, 1)
+			}
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Height != results[j].Height {
+			return results[i].Height > results[j].Height
+		}
+		return results[i].SCID+results[i].Field+results[i].Key < results[j].SCID+results[j].Field+results[j].Key
+	})
+	total = len(results)
+	results = results[min(max(offset, 0), total):]
+	if limit > 0 {
+		results = results[:min(limit, len(results))]
+	}
+	return
+}
+
+// A few words either side of the first match of word, highlighted
+func memSnippet(text, lower, word string) string {
+	if len(lower) != len(text) {
+		// Lowering changed the byte offsets
+		text = lower
+	}
+	i := strings.Index(lower, word)
+	start, end := max(i-40, 0), min(i+len(word)+40, len(text))
+	snippet := text[start:i] + HighlightStart + text[i:i+len(word)] + HighlightEnd + text[i+len(word):end]
+	if start > 0 {
+		snippet = "..." + snippet
+	}
+	if end < len(text) {
+		snippet += "..."
+	}
+	return snippet
+}
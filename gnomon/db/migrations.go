@@ -0,0 +1,179 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Numbered schema migrations, each run once in its own transaction
+type Migration struct {
+	Version int
+	Name    string
+	up      func(tx *sql.Tx) error
+}
+
+var migrations = []Migration{
+	{1, "base tables", createTables(
+		"state "+stateSchema,
+		"settings "+settingsSchema,
+		"scs "+scsSchema,
+		"scvars "+scvarsSchema,
+		"checkpoints "+checkpointsSchema,
+		"invokes "+invokesSchema,
+		"interactions "+interactionsSchema,
+	)},
+	{2, "variable change sets", migrateVariables},
+	{3, "code versions", migrateCodeVersions},
+	{4, "fingerprints", createTables("fingerprints " + fingerprintsSchema)},
+	{5, "swap orders", createTables("swaps " + swapsSchema)},
+	{6, "name registry", migrateNames},
+	{7, "spam quarantine", createTables("quarantine " + quarantineSchema)},
+	{8, "indexes", createIndexes(slices.Concat(
+		tableIndexes,
+		varsIndexes, fingerprintsIndexes, codeversionsIndexes, assetsIndexes, swapsIndexes, namesIndexes, spamIndexes, activityIndexes,
+	))},
+	{9, "full-text search", migrateSearch},
+	{10, "class and tag tables", migrateClasses},
+	{11, "block statistics", migrateBlockStats},
+	{12, "name transfers", migrateNameTransfers},
+	{13, "fts5 search", migrateSearchFTS5},
+}
+
+// Indexes of the base tables
+var tableIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS height_index ON interactions(scid,txid);",
+	"CREATE INDEX IF NOT EXISTS invokes_height_index ON invokes(txid);",
+}
+
+// Version of the table layout, checked when importing snapshots
+var SchemaVersion = migrations[len(migrations)-1].Version
+
+const schemaVersionSchema = "(" +
+	"version INTEGER PRIMARY KEY, " +
+	"name TEXT, " +
+	"applied INTEGER)"
+
+// Opening the same file concurrently must not apply a migration twice
+var migrateMutex sync.Mutex
+
+func createTables(tables ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, table := range tables {
+			if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS " + table); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func createIndexes(indexes []string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, index := range indexes {
+			if _, err := tx.Exec(index); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Highest applied migration, 0 for a db made before versioning
+func GetSchemaVersion(Db *sql.DB) (version int) {
+	Db.QueryRow("SELECT IFNULL(MAX(version),0) FROM schema_version;").Scan(&version)
+	return
+}
+
+// Migrations not yet applied to the db
+func PendingMigrations(Db *sql.DB) (pending []Migration, err error) {
+	version := GetSchemaVersion(Db)
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("db schema version %d is newer than %d", version, SchemaVersion)
+	}
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return
+}
+
+// Applies the pending migrations in order, stops at the first failure
+func Migrate(Db *sql.DB) (applied []Migration, err error) {
+	migrateMutex.Lock()
+	defer migrateMutex.Unlock()
+	if _, err = Db.Exec("CREATE TABLE IF NOT EXISTS schema_version " + schemaVersionSchema); err != nil {
+		return
+	}
+	pending, err := PendingMigrations(Db)
+	if err != nil {
+		return
+	}
+	for _, m := range pending {
+		if err = runMigration(Db, m); err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return
+}
+
+func runMigration(Db *sql.DB, m Migration) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = m.up(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.Exec(
+		"INSERT INTO schema_version (version,name,applied) VALUES (?,?,?);",
+		m.Version, m.Name, time.Now().Unix()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Migrates the db file at path, with dry set only the pending migrations are returned
+func MigrateFile(db_path, db_name string, dry bool) ([]Migration, error) {
+	Db, err := sql.Open("sqlite3", "file:"+filepath.Join(db_path, db_name)+"?mode=rw")
+	if err != nil {
+		return nil, err
+	}
+	defer Db.Close()
+	if err = Db.Ping(); err != nil {
+		return nil, err
+	}
+	if dry {
+		var exists int
+		Db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name='schema_version');").Scan(&exists)
+		if exists == 0 {
+			return migrations, nil
+		}
+		return PendingMigrations(Db)
+	}
+	return Migrate(Db)
+}
+
+// Migrates the db on open and reports what was applied
+func migrateOnOpen(Db *sql.DB) error {
+	applied, err := Migrate(Db)
+	for _, m := range applied {
+		fmt.Println("Applied migration", m.Version, m.Name)
+	}
+	return err
+}
+
+// Creates or upgrades the tables, exits when the db can't be migrated
+func CreateTables(Db *sql.DB) {
+	if err := migrateOnOpen(Db); err != nil {
+		log.Fatalf("migrate db: %v", err)
+	}
+}
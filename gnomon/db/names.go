@@ -0,0 +1,205 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+
+	"gnomon/structs"
+)
+
+// Name service registry, the earliest registration of a name wins
+
+const nameServiceSCID = "0000000000000000000000000000000000000000000000000000000000000001"
+
+// Max names kept per address, 0 for no limit
+var NamesPerAddress = 100
+
+const namesSchema = "(" +
+	"name TEXT PRIMARY KEY, " +
+	"address TEXT, " +
+	"height INTEGER, " +
+	"txid TEXT, " +
+	"updated INTEGER, " +
+	"registrant TEXT)"
+
+const nameTransfersSchema = "(" +
+	"nt_id INTEGER PRIMARY KEY, " +
+	"name TEXT, " +
+	"from_address TEXT, " +
+	"to_address TEXT, " +
+	"height INTEGER, " +
+	"UNIQUE(name,from_address,to_address,height))"
+
+var namesIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS names_address_index ON names(address);",
+	"CREATE INDEX IF NOT EXISTS names_updated_index ON names(updated);",
+}
+
+var nameTransfersIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS name_transfers_name_index ON name_transfers(name,height);",
+	"CREATE INDEX IF NOT EXISTS name_transfers_height_index ON name_transfers(height);",
+}
+
+// Records a registration, returns false if an earlier one exists or the address is over NamesPerAddress
+func (ss *SqlStore) StoreName(name string, address string, height int64, txid string) (changes bool, err error) {
+	err = ss.write(func(tx *sql.Tx) (err error) {
+		changes, err = storeName(tx, name, address, height, txid)
+		return
+	})
+	return
+}
+
+// Records a transfer, returns true if it changed the owner
+func (ss *SqlStore) TransferName(name string, from string, to string, height int64) (changes bool, err error) {
+	err = ss.write(func(tx *sql.Tx) (err error) {
+		changes, err = transferName(tx, name, from, to, height)
+		return
+	})
+	return
+}
+
+func storeName(q queryer, name string, address string, height int64, txid string) (changes bool, err error) {
+	if NamesPerAddress > 0 {
+		var count int
+		q.QueryRow("SELECT COUNT(*) FROM names WHERE address = ?;", address).Scan(&count)
+		if count >= NamesPerAddress {
+			return
+		}
+	}
+	result, err := q.Exec(
+		`INSERT INTO names (name,address,height,txid,updated,registrant) VALUES (?,?,?,?,?,?)
+		ON CONFLICT(name) DO UPDATE SET address = excluded.address, height = excluded.height, txid = excluded.txid, updated = excluded.updated, registrant = excluded.registrant
+		WHERE excluded.height < names.height;`,
+		name, address, height, txid, height, address)
+	if err != nil {
+		return
+	}
+	affected, _ := result.RowsAffected()
+	// Transfers indexed before the registration apply now
+	resolved, err := resolveName(q, name, height)
+	return affected != 0 || resolved, err
+}
+
+func transferName(q queryer, name string, from string, to string, height int64) (changes bool, err error) {
+	if _, err = q.Exec(
+		"INSERT INTO name_transfers (name,from_address,to_address,height) VALUES (?,?,?,?) ON CONFLICT DO NOTHING;",
+		name, from, to, height); err != nil {
+		return
+	}
+	return resolveName(q, name, height)
+}
+
+// Sets the owner of a name by replaying its transfers from the registrant, a transfer
+// applies if it is from the owner at its height. updated is the indexed height that
+// last changed the owner
+func resolveName(q queryer, name string, indexed int64) (changes bool, err error) {
+	var (
+		address string
+		height  int64
+	)
+	err = q.QueryRow("SELECT registrant, height FROM names WHERE name = ?;", name).Scan(&address, &height)
+	if err == sql.ErrNoRows {
+		// Not registered yet, the transfers are replayed when it is
+		return false, nil
+	}
+	if err != nil {
+		return
+	}
+	rows, err := q.Query(
+		"SELECT from_address, to_address FROM name_transfers WHERE name = ? AND height >= ? ORDER BY height ASC, nt_id ASC;",
+		name, height)
+	if err != nil {
+		return
+	}
+	var transfers [][2]string
+	for rows.Next() {
+		var transfer [2]string
+		rows.Scan(&transfer[0], &transfer[1])
+		transfers = append(transfers, transfer)
+	}
+	rows.Close()
+	for _, transfer := range transfers {
+		if transfer[0] == address {
+			address = transfer[1]
+		}
+	}
+	result, err := q.Exec(
+		"UPDATE names SET address = ?, updated = CASE WHEN updated > ? THEN updated ELSE ? END WHERE name = ? AND address != ?;",
+		address, indexed, indexed, name, address)
+	if err != nil {
+		return
+	}
+	affected, _ := result.RowsAffected()
+	return affected != 0, nil
+}
+
+func (ss *SqlStore) GetNameAddress(name string) (address string, err error) {
+	err = ss.DB.QueryRow("SELECT address FROM names WHERE name = ?;", name).Scan(&address)
+	return
+}
+
+// Names held by an address, oldest registration first
+func (ss *SqlStore) GetAddressNames(address string) (names []structs.Name) {
+	rows, err := ss.DB.Query(
+		"SELECT name, address, height, txid FROM names WHERE address = ? ORDER BY height ASC;",
+		address)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n structs.Name
+		rows.Scan(&n.Name, &n.Address, &n.Height, &n.TXID)
+		names = append(names, n)
+	}
+	return
+}
+
+// One-time fill of the registry from the stored name service variables
+func migrateNames(tx *sql.Tx) error {
+	var exists int
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name='names');").Scan(&exists)
+	if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS names " + namesSchema); err != nil {
+		return err
+	}
+	if exists == 1 {
+		return nil
+	}
+	// First value of each key is the registration, the latest is the current owner
+	_, err := tx.Exec(
+		`INSERT OR IGNORE INTO names (name,address,height,txid,updated)
+		SELECT first.key, latest.value, first.height, first.txid, latest.height
+		FROM (
+			SELECT key, height, txid, ROW_NUMBER() OVER (PARTITION BY key ORDER BY height ASC, sv_id ASC) AS rn
+			FROM scvars
+			WHERE scid = ? AND ktype = ? AND vtype = ? AND deleted = 0
+		) AS first
+		JOIN (
+			SELECT key, value, height, ROW_NUMBER() OVER (PARTITION BY key ORDER BY height DESC, sv_id DESC) AS rn
+			FROM scvars
+			WHERE scid = ? AND ktype = ? AND vtype = ? AND deleted = 0
+		) AS latest ON latest.key = first.key AND latest.rn = 1
+		WHERE first.rn = 1 AND first.key != 'C';`,
+		nameServiceSCID, typeString, typeString, nameServiceSCID, typeString, typeString)
+	return err
+}
+
+// Transfers as their own rows, names stored before start from their current owner
+func migrateNameTransfers(tx *sql.Tx) error {
+	var exists int
+	tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info('names') WHERE name = 'registrant';").Scan(&exists)
+	queries := []string{
+		"CREATE TABLE IF NOT EXISTS name_transfers " + nameTransfersSchema,
+		"UPDATE names SET registrant = address WHERE registrant IS NULL;",
+	}
+	if exists == 0 {
+		queries = append([]string{"ALTER TABLE names ADD COLUMN registrant TEXT;"}, queries...)
+	}
+	for _, query := range append(queries, nameTransfersIndexes...) {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Values are always bound to placeholders, large IN sets go through a temp table
+
+// Largest IN set bound one placeholder per value, sqlite allows 32766 per statement
+const maxBoundValues = 500
+
+// ?,?,? for the values, with the values as args
+func placeholders(values []string) (list string, args []any) {
+	for _, value := range values {
+		args = append(args, value)
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", len(values)), ","), args
+}
+
+// " WHERE height >= ? AND height < ?" and its args, no upper bound when end is -1
+func heightRange(start int64, end int64) (where string, args []any) {
+	where, args = " WHERE height >= ?", []any{start}
+	if end != -1 {
+		where += " AND height < ?"
+		args = append(args, end)
+	}
+	return
+}
+
+// Quotes a table or column name read back from sqlite_master
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// Runs fn with an IN list of the values and its args. Large sets are inserted into
+// temp.in_values on the connection of a transaction that is rolled back afterwards,
+// so the temp table never outlives fn
+func (ss *SqlStore) inValues(values []string, fn func(q queryer, list string, args []any)) {
+	if len(values) <= maxBoundValues {
+		list, args := placeholders(values)
+		fn(ss.DB, list, args)
+		return
+	}
+	tx, err := ss.DB.Begin()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("CREATE TEMP TABLE IF NOT EXISTS in_values (value TEXT PRIMARY KEY);"); err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, value := range values {
+		if _, err = tx.Exec("INSERT OR IGNORE INTO temp.in_values (value) VALUES (?);", value); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	fn(tx, "SELECT value FROM temp.in_values", nil)
+}
+
+// First column of every row
+func queryStrings(q queryer, query string, args ...any) (results []string) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	var value string
+	for rows.Next() {
+		rows.Scan(&value)
+		results = append(results, value)
+	}
+	return
+}
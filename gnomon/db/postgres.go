@@ -0,0 +1,1045 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gnomon/structs"
+)
+
+// PostgreSQL store, queries use ? placeholders and are rewritten to $n
+
+// database/sql driver name used to open PgStore
+var PostgresDriver = "postgres"
+
+type PgStore struct {
+	DB *sql.DB
+}
+
+// Tables created if missing, integers are 64 bit
+var pgTables = []string{
+	"CREATE TABLE IF NOT EXISTS state (name TEXT PRIMARY KEY, value BIGINT)",
+	"CREATE TABLE IF NOT EXISTS settings (name TEXT PRIMARY KEY, value TEXT)",
+	"CREATE TABLE IF NOT EXISTS scs (" +
+		"scs_id BIGSERIAL PRIMARY KEY, " +
+		"scid TEXT UNIQUE NOT NULL, " +
+		"owner TEXT NOT NULL, " +
+		"height BIGINT, " +
+		"scname TEXT, " +
+		"scdescr TEXT, " +
+		"scimgurl TEXT, " +
+		"class TEXT, " +
+		"tags TEXT)",
+	"CREATE TABLE IF NOT EXISTS invokes (" +
+		"iv_id BIGSERIAL PRIMARY KEY, " +
+		"scid TEXT, " +
+		"signer TEXT, " +
+		"txid TEXT UNIQUE, " +
+		"height BIGINT, " +
+		"entrypoint TEXT)",
+	"CREATE TABLE IF NOT EXISTS interactions (height BIGINT, txid TEXT UNIQUE, scid TEXT)",
+	"CREATE TABLE IF NOT EXISTS scvars " + pgSchema(scvarsSchema),
+	"CREATE TABLE IF NOT EXISTS checkpoints " + pgSchema(checkpointsSchema),
+	"CREATE TABLE IF NOT EXISTS fingerprints " + pgSchema(fingerprintsSchema),
+	"CREATE TABLE IF NOT EXISTS codeversions " + pgSchema(codeversionsSchema),
+	"CREATE TABLE IF NOT EXISTS swaps " + pgSchema(swapsSchema),
+	"CREATE TABLE IF NOT EXISTS names " + pgSchema(namesSchema),
+	"ALTER TABLE names ADD COLUMN IF NOT EXISTS registrant TEXT",
+	"CREATE TABLE IF NOT EXISTS name_transfers " + pgSchema(nameTransfersSchema),
+	"CREATE TABLE IF NOT EXISTS quarantine " + pgSchema(quarantineSchema),
+	"CREATE TABLE IF NOT EXISTS sc_classes " + pgSchema(scClassesSchema),
+	"CREATE TABLE IF NOT EXISTS sc_tags " + pgSchema(scTagsSchema),
+	"CREATE TABLE IF NOT EXISTS invalid_deploys " + pgSchema(invalidDeploysSchema),
+	"CREATE TABLE IF NOT EXISTS miner_blocks (height BIGINT PRIMARY KEY)",
+	"CREATE TABLE IF NOT EXISTS miners " + pgSchema(minersSchema),
+	"CREATE TABLE IF NOT EXISTS search_docs (" +
+		"doc_id BIGSERIAL PRIMARY KEY, " +
+		"scid TEXT, " +
+		"field TEXT, " +
+		"key TEXT, " +
+		"ktype BIGINT, " +
+		"height BIGINT, " +
+		"text TEXT, " +
+		"UNIQUE(scid,field,key,ktype))",
+}
+
+// Search docs keep their text, matched with the simple text search configuration
+var pgSearchIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS search_docs_height_index ON search_docs(height);",
+	"CREATE INDEX IF NOT EXISTS search_docs_text_index ON search_docs USING GIN (to_tsvector('simple', text));",
+}
+
+// Tables trimmed by height on a restart
+var pgHeightTables = []string{"scs", "scvars", "checkpoints", "invokes", "interactions", "fingerprints", "codeversions", "swaps", "names", "quarantine", "sc_classes", "sc_tags", "invalid_deploys", "name_transfers"}
+
+// Converts a SQLite schema to Postgres types
+func pgSchema(schema string) string {
+	return strings.NewReplacer("INTEGER PRIMARY KEY", "BIGSERIAL PRIMARY KEY", "INTEGER", "BIGINT").Replace(schema)
+}
+
+// Rewrites ? placeholders outside of string literals to $1, $2...
+func rebind(query string) string {
+	var b strings.Builder
+	n, quoted := 0, false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Runs ? queries on a Postgres *sql.DB or *sql.Tx
+type pgQueryer struct {
+	q queryer
+}
+
+func (p pgQueryer) Exec(query string, args ...any) (sql.Result, error) {
+	return p.q.Exec(rebind(query), args...)
+}
+
+func (p pgQueryer) Query(query string, args ...any) (*sql.Rows, error) {
+	return p.q.Query(rebind(query), args...)
+}
+
+func (p pgQueryer) QueryRow(query string, args ...any) *sql.Row {
+	return p.q.QueryRow(rebind(query), args...)
+}
+
+// Opens the database at dsn and creates the tables
+func NewPostgresDB(dsn string) (*PgStore, error) {
+	db, err := sql.Open(PostgresDriver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	ps := &PgStore{DB: db}
+	for _, query := range slices.Concat(pgTables, varsIndexes, fingerprintsIndexes, codeversionsIndexes, assetsIndexes, swapsIndexes, namesIndexes, nameTransfersIndexes, spamIndexes, activityIndexes, pgSearchIndexes, classesIndexes, blockStatsIndexes) {
+		if _, err = db.Exec(query); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("%v: %s", err, query)
+		}
+	}
+	// Databases made before the search index get it filled once
+	var docs, contracts int
+	ps.q().QueryRow("SELECT (SELECT COUNT(*) FROM search_docs), (SELECT COUNT(*) FROM scs);").Scan(&docs, &contracts)
+	if docs == 0 && contracts != 0 {
+		all, err := allDocs(ps.q())
+		if err == nil {
+			fmt.Println("Indexing", len(all), "search docs...")
+			err = pgReindexDocs(ps.q(), all)
+		}
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	// Likewise the class and tag tables, split from scs
+	var split int
+	ps.q().QueryRow("SELECT (SELECT COUNT(*) FROM sc_classes) + (SELECT COUNT(*) FROM sc_tags);").Scan(&split)
+	if split == 0 && contracts != 0 {
+		if err = splitSCMeta(ps.q()); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	// Names stored before transfers were kept start from their current owner
+	if _, err = ps.q().Exec("UPDATE names SET registrant = address WHERE registrant IS NULL;"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (ps *PgStore) q() pgQueryer {
+	return pgQueryer{ps.DB}
+}
+
+func (ps *PgStore) queryStrings(query string, args ...any) (results []string) {
+	return queryStrings(ps.q(), query, args...)
+}
+
+// -- Settings and indexing progress
+
+func (ps *PgStore) SaveSetting(name, value string) {
+	ps.q().Exec("INSERT INTO settings (name,value) VALUES (?,?) ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value;", name, value)
+}
+
+func (ps *PgStore) LoadSetting(name string) (value string, err error) {
+	ps.q().QueryRow("SELECT value FROM settings WHERE name = ?;", name).Scan(&value)
+	return
+}
+
+func (ps *PgStore) saveState(name string, value int64) {
+	ps.q().Exec("INSERT INTO state (name,value) VALUES (?,?) ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value;", name, value)
+}
+
+func (ps *PgStore) SaveInitialHeight(startat int64) {
+	ps.saveState("lastindexedheight", startat)
+}
+
+func (ps *PgStore) SaveInitialSessionStart(startat int64) {
+	ps.saveState("sessionstart", startat)
+}
+
+func (ps *PgStore) LoadState(name string) (value int, err error) {
+	ps.q().QueryRow("SELECT value FROM state WHERE name = ?;", name).Scan(&value)
+	return
+}
+
+func (ps *PgStore) StoreSessionStart(start int64) {
+	if _, err := ps.q().Exec("UPDATE state SET value = ? WHERE name = 'sessionstart';", start); err != nil {
+		fmt.Println("Error storing session start")
+	}
+}
+
+func (ps *PgStore) StoreLastIndexHeight(last_indexedheight int64) (changes bool, err error) {
+	result, err := ps.q().Exec("UPDATE state SET value = ? WHERE name = 'lastindexedheight';", last_indexedheight)
+	if err != nil {
+		fmt.Println("Error storing last index height")
+		return
+	}
+	affected, _ := result.RowsAffected()
+	return affected != 0, nil
+}
+
+func (ps *PgStore) GetLastIndexHeight() (topoheight int64, err error) {
+	err = ps.q().QueryRow("SELECT value FROM state WHERE name = 'lastindexedheight';").Scan(&topoheight)
+	topoheight = max(topoheight, 0)
+	return
+}
+
+func (ps *PgStore) TrimHeight(start int64, end int64) int64 {
+	where, args := heightRange(start, end)
+	docs, _ := docsWhere(ps.q(), where, args...)
+	rebases, err := rebasesWhere(ps.q(), where, args...)
+	if err != nil {
+		fmt.Println(err)
+	}
+	for _, table := range pgHeightTables {
+		ps.q().Exec("DELETE FROM "+table+where+";", args...)
+	}
+	if err = rebaseChanges(ps.q(), rebases); err != nil {
+		fmt.Println(err)
+	}
+	pgReindexDocs(ps.q(), docs)
+	return start
+}
+
+// -- Indexing
+
+func (ps *PgStore) StoreOwner(scid string, owner string, height int, scname string, scdescr string, scimgurl string, class string, tags string) (changes bool, err error) {
+	_, err = ps.q().Exec(
+		"INSERT INTO scs (scid,owner,height,scname,scdescr,scimgurl,class,tags) VALUES (?,?,?,?,?,?,?,?);",
+		scid, owner, height, scname, scdescr, scimgurl, class, tags)
+	if err == nil {
+		err = storeSCMeta(ps.q(), scid, class, tags)
+	}
+	if err != nil {
+		return
+	}
+	return true, pgReindexDocs(ps.q(), contractDocs(scid)[:2])
+}
+
+func (ps *PgStore) StoreSCIDInvoke(scidstoadd structs.SCIDToIndexStage, height int64) (changes bool, err error) {
+	result, err := ps.q().Exec(
+		"INSERT INTO invokes (scid,signer,txid,height,entrypoint) VALUES (?,?,?,?,?) ON CONFLICT (txid) DO NOTHING;",
+		scidstoadd.Params.SCID, scidstoadd.Fsi.Signer, scidstoadd.TXHash, height, scidstoadd.Entrypoint)
+	if err != nil {
+		return
+	}
+	affected, _ := result.RowsAffected()
+	return affected != 0, nil
+}
+
+func (ps *PgStore) StoreSCIDInteractionHeight(scidstoadd structs.SCIDToIndexStage, height int64) (changes bool, err error) {
+	if scidstoadd.Type == "install" {
+		return
+	}
+	_, err = ps.q().Exec(
+		"INSERT INTO interactions (height,txid,scid) VALUES (?,?,?);",
+		height, scidstoadd.TXHash, scidstoadd.Params.SCID)
+	return err == nil, err
+}
+
+func (ps *PgStore) StoreSCIDVariableDetails(scid string, txid string, variables []*structs.SCIDVariable, topoheight int64) (changes bool, err error) {
+	varsMutex.Lock()
+	defer varsMutex.Unlock()
+	tx, err := ps.DB.Begin()
+	if err != nil {
+		return
+	}
+	keys, err := storeChanges(pgQueryer{tx}, scid, txid, topoheight, toState(variables))
+	if err == nil {
+		err = pgReindexDocs(pgQueryer{tx}, varDocs(scid, keys))
+	}
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	return true, tx.Commit()
+}
+
+func (ps *PgStore) StoreCodeVersion(scid string, txid string, height int64, code string) (changes bool, err error) {
+	if code == "" {
+		return
+	}
+	codehash := CodeHash(code)
+	varsMutex.Lock()
+	defer varsMutex.Unlock()
+	var previous string
+	err = ps.q().QueryRow(
+		"SELECT codehash FROM codeversions WHERE scid = ? AND height <= ? ORDER BY height DESC, cv_id DESC LIMIT 1;",
+		scid, height).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		return
+	}
+	err = nil
+	if previous == codehash {
+		return
+	}
+	if _, err = ps.q().Exec(
+		"INSERT INTO codeversions (scid,height,txid,codehash,code) VALUES (?,?,?,?,?);",
+		scid, height, txid, codehash, code); err != nil {
+		return
+	}
+	_, err = ps.q().Exec(
+		`DELETE FROM codeversions
+		WHERE cv_id = (
+			SELECT cv_id FROM codeversions
+			WHERE scid = ? AND height > ?
+			ORDER BY height ASC, cv_id ASC LIMIT 1
+		) AND codehash = ?;`,
+		scid, height, codehash)
+	if err != nil {
+		return
+	}
+	return true, pgReindexDocs(ps.q(), contractDocs(scid)[2:])
+}
+
+func (ps *PgStore) StoreFingerprint(scid string, height int64, codehash string, simhash string, family string) error {
+	_, err := ps.q().Exec(
+		`INSERT INTO fingerprints (scid,height,codehash,simhash,family) VALUES (?,?,?,?,?)
+		ON CONFLICT (scid) DO UPDATE SET height = EXCLUDED.height, codehash = EXCLUDED.codehash, simhash = EXCLUDED.simhash, family = EXCLUDED.family;`,
+		scid, height, codehash, simhash, family)
+	return err
+}
+
+func (ps *PgStore) StoreSwap(order structs.SwapOrder) error {
+	_, err := ps.q().Exec(
+		`INSERT INTO swaps (scid,height,maker,offer_asset,offer_amount,request_asset,request_amount,expiry,status) VALUES (?,?,?,?,?,?,?,?,?)
+		ON CONFLICT (scid) DO UPDATE SET height = EXCLUDED.height, maker = EXCLUDED.maker,
+			offer_asset = EXCLUDED.offer_asset, offer_amount = EXCLUDED.offer_amount,
+			request_asset = EXCLUDED.request_asset, request_amount = EXCLUDED.request_amount,
+			expiry = EXCLUDED.expiry, status = EXCLUDED.status
+		WHERE EXCLUDED.height >= swaps.height;`,
+		order.SCID, order.Height, order.Maker, order.OfferAsset, int64(order.OfferAmount), order.RequestAsset, int64(order.RequestAmount), order.Expiry, order.Status)
+	return err
+}
+
+func (ps *PgStore) StoreInvalidSCIDDeploy(deploy structs.InvalidDeploy) error {
+	return storeInvalidDeploy(ps.q(), deploy)
+}
+
+func (ps *PgStore) StoreMiners(height int64, miners []string, integrator string) (changes bool, err error) {
+	tx, err := ps.DB.Begin()
+	if err != nil {
+		return
+	}
+	if changes, err = storeMiners(pgQueryer{tx}, height, miners, integrator); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return changes, tx.Commit()
+}
+
+func (ps *PgStore) StoreName(name string, address string, height int64, txid string) (changes bool, err error) {
+	tx, err := ps.DB.Begin()
+	if err != nil {
+		return
+	}
+	if changes, err = storeName(pgQueryer{tx}, name, address, height, txid); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return changes, tx.Commit()
+}
+
+func (ps *PgStore) TransferName(name string, from string, to string, height int64) (changes bool, err error) {
+	tx, err := ps.DB.Begin()
+	if err != nil {
+		return
+	}
+	if changes, err = transferName(pgQueryer{tx}, name, from, to, height); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return changes, tx.Commit()
+}
+
+func (ps *PgStore) UpdateSCMeta(scid, class, tags string) {
+	tx, err := ps.DB.Begin()
+	if err != nil {
+		fmt.Println("Error updating metadata")
+		return
+	}
+	q := pgQueryer{tx}
+	_, err = q.Exec("UPDATE scs SET class = ?, tags = ? WHERE scid = ?;", class, tags, scid)
+	if err == nil {
+		err = storeSCMeta(q, scid, class, tags)
+	}
+	if err != nil {
+		tx.Rollback()
+		fmt.Println("Error updating metadata")
+		return
+	}
+	tx.Commit()
+}
+
+func (ps *PgStore) GetSCOwnerAndClass(scid string) (owner string, class string) {
+	ps.q().QueryRow("SELECT owner, COALESCE(class,'') FROM scs WHERE scid = ?;", scid).Scan(&owner, &class)
+	return
+}
+
+func (ps *PgStore) GetFamilyByCodeHash(codehash string) (family string) {
+	ps.q().QueryRow("SELECT family FROM fingerprints WHERE codehash = ? LIMIT 1;", codehash).Scan(&family)
+	return
+}
+
+func (ps *PgStore) GetFamilySimHashes() (families map[string]string) {
+	families = map[string]string{}
+	rows, err := ps.q().Query("SELECT family, simhash FROM fingerprints WHERE family != '' AND scid = family;")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	var family, simhash string
+	for rows.Next() {
+		rows.Scan(&family, &simhash)
+		families[family] = simhash
+	}
+	return
+}
+
+// -- Spam control
+
+func (ps *PgStore) CountSignerInvokes(scid string, signer string, from int64, to int64) (count int) {
+	ps.q().QueryRow(
+		`SELECT
+			(SELECT COUNT(*) FROM invokes WHERE scid = ? AND signer = ? AND height BETWEEN ? AND ?) +
+			(SELECT COUNT(*) FROM quarantine WHERE scid = ? AND signer = ? AND height BETWEEN ? AND ?);`,
+		scid, signer, from, to, scid, signer, from, to).Scan(&count)
+	return
+}
+
+func (ps *PgStore) GetInvokesOverLimit(scid string, max int) (txs []structs.QuarantinedTx) {
+	rows, err := ps.q().Query(
+		`SELECT txid, signer, height, entrypoint FROM (
+			SELECT txid, signer, height, COALESCE(entrypoint,'') AS entrypoint,
+				ROW_NUMBER() OVER (PARTITION BY signer ORDER BY height ASC, iv_id ASC) AS rn
+			FROM invokes
+			WHERE scid = ? AND signer != ''
+		) AS ranked WHERE rn > ?;`, scid, max)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		tx := structs.QuarantinedTx{SCID: scid}
+		rows.Scan(&tx.TXID, &tx.Signer, &tx.Height, &tx.Entrypoint)
+		txs = append(txs, tx)
+	}
+	return
+}
+
+const pgStoreQuarantined = `INSERT INTO quarantine (txid,scid,signer,height,entrypoint,reason) VALUES (?,?,?,?,?,?)
+	ON CONFLICT (txid) DO UPDATE SET scid = EXCLUDED.scid, signer = EXCLUDED.signer, height = EXCLUDED.height,
+		entrypoint = EXCLUDED.entrypoint, reason = EXCLUDED.reason;`
+
+func (ps *PgStore) RemoveInvokes(txs []structs.QuarantinedTx, quarantine bool) error {
+	varsMutex.Lock()
+	defer varsMutex.Unlock()
+	tx, err := ps.DB.Begin()
+	if err != nil {
+		return err
+	}
+	q := pgQueryer{tx}
+	var docs []searchDoc
+	for _, t := range txs {
+		written, err := txDocs(q, t.TXID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		docs = append(docs, written...)
+		for _, query := range []string{
+			"DELETE FROM invokes WHERE txid = ?;",
+			"DELETE FROM scvars WHERE txid = ?;",
+			"DELETE FROM checkpoints WHERE txid = ?;",
+		} {
+			if _, err := q.Exec(query, t.TXID); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if quarantine {
+			if _, err := q.Exec(pgStoreQuarantined, t.TXID, t.SCID, t.Signer, t.Height, t.Entrypoint, t.Reason); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	if err := pgReindexDocs(q, docs); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (ps *PgStore) StoreQuarantined(tx structs.QuarantinedTx) error {
+	_, err := ps.q().Exec(pgStoreQuarantined, tx.TXID, tx.SCID, tx.Signer, tx.Height, tx.Entrypoint, tx.Reason)
+	return err
+}
+
+func (ps *PgStore) GetQuarantined(scid string) (txs []structs.QuarantinedTx) {
+	query := "SELECT txid, scid, signer, height, entrypoint, reason FROM quarantine"
+	var args []any
+	if scid != "" {
+		query += " WHERE scid = ?"
+		args = append(args, scid)
+	}
+	rows, err := ps.q().Query(query+" ORDER BY height ASC;", args...)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tx structs.QuarantinedTx
+		rows.Scan(&tx.TXID, &tx.SCID, &tx.Signer, &tx.Height, &tx.Entrypoint, &tx.Reason)
+		txs = append(txs, tx)
+	}
+	return
+}
+
+func (ps *PgStore) GetQuarantinedTx(txid string) (tx structs.QuarantinedTx, err error) {
+	err = ps.q().QueryRow(
+		"SELECT txid, scid, signer, height, entrypoint, reason FROM quarantine WHERE txid = ?;",
+		txid).Scan(&tx.TXID, &tx.SCID, &tx.Signer, &tx.Height, &tx.Entrypoint, &tx.Reason)
+	return
+}
+
+func (ps *PgStore) DeleteQuarantined(txid string) error {
+	_, err := ps.q().Exec("DELETE FROM quarantine WHERE txid = ?;", txid)
+	return err
+}
+
+// -- Queries
+
+func (ps *PgStore) GetAllOwnersAndSCIDs() map[string]string {
+	results := make(map[string]string)
+	rows, err := ps.q().Query("SELECT scid, owner FROM scs;")
+	if err != nil {
+		fmt.Println(err)
+		return results
+	}
+	defer rows.Close()
+	var scid, owner string
+	for rows.Next() {
+		rows.Scan(&scid, &owner)
+		results[scid] = owner
+	}
+	return results
+}
+
+func (ps *PgStore) GetSCIDsByClass(class_list []string) (results []string) {
+	if len(class_list) == 0 {
+		return
+	}
+	list, args := placeholders(class_list)
+	where := metaMatch("sc_classes", "class", list)
+	return ps.queryStrings("SELECT scid FROM scs WHERE "+where+" ORDER BY height ASC, scid ASC;", args...)
+}
+
+func (ps *PgStore) GetSCIDsByClassAbove(class_list []string, height int64) (results []string) {
+	if len(class_list) == 0 {
+		return
+	}
+	list, args := placeholders(class_list)
+	where := metaMatch("sc_classes", "class", list)
+	return ps.queryStrings("SELECT scid FROM scs WHERE height > ? AND "+where+" ORDER BY height ASC, scid ASC;", append([]any{height}, args...)...)
+}
+
+func (ps *PgStore) GetSCIDsByTags(tags_list []string) (results []string) {
+	if len(tags_list) == 0 {
+		return
+	}
+	list, args := placeholders(tags_list)
+	where := metaMatch("sc_tags", "tag", list)
+	return ps.queryStrings("SELECT scid FROM scs WHERE "+where+" ORDER BY height ASC, scid ASC;", args...)
+}
+
+func (ps *PgStore) GetSCsByTags(tags_list []string) (results []map[string]any) {
+	if len(tags_list) == 0 {
+		return
+	}
+	list, args := placeholders(tags_list)
+	where := metaMatch("sc_tags", "tag", list)
+	rows, err := ps.q().Query(
+		`SELECT scid, owner, COALESCE(height,0), COALESCE(scname,''), COALESCE(scdescr,''), COALESCE(scimgurl,''), COALESCE(class,''), COALESCE(tags,'')
+		FROM scs WHERE `+where+" ORDER BY height ASC, scid ASC;", args...)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	var (
+		scid, owner, scname, scdescr, scimgurl, class, tags string
+		height                                              int
+	)
+	for rows.Next() {
+		rows.Scan(&scid, &owner, &height, &scname, &scdescr, &scimgurl, &class, &tags)
+		results = append(results, map[string]any{
+			"scid":     scid,
+			"owner":    owner,
+			"height":   height,
+			"scname":   scname,
+			"scdescr":  scdescr,
+			"scimgurl": scimgurl,
+			"class":    class,
+			"tags":     tags,
+		})
+	}
+	return
+}
+
+func (ps *PgStore) GetClassCounts() (counts []structs.MetaCount) {
+	return metaCounts(ps.q(), "sc_classes", "class")
+}
+
+func (ps *PgStore) GetTagCounts() (counts []structs.MetaCount) {
+	return metaCounts(ps.q(), "sc_tags", "tag")
+}
+
+func (ps *PgStore) GetInvalidSCIDDeploys() (deploys []structs.InvalidDeploy) {
+	return getInvalidDeploys(ps.q())
+}
+
+func (ps *PgStore) GetMiniblockCountByAddress(address string) (miniblocks int64) {
+	return getMiniblockCount(ps.q(), address)
+}
+
+func (ps *PgStore) GetIntegrators() (integrators map[string]int64) {
+	return getIntegrators(ps.q())
+}
+
+func (ps *PgStore) GetSCMeta(scid string) (meta structs.SCMeta, err error) {
+	return getSCMeta(ps.q(), scid)
+}
+
+func (ps *PgStore) GetSCMetaAfter(after string, limit int) (results []structs.SCMeta) {
+	return scMetaAfter(ps.q(), after, limit)
+}
+
+func (ps *PgStore) CountSCs() (count int) {
+	ps.q().QueryRow("SELECT COUNT(*) FROM scs;").Scan(&count)
+	return
+}
+
+func (ps *PgStore) GetUnfingerprintedSCIDs(limit int) (results []structs.SCMeta) {
+	return unfingerprinted(ps.q(), limit)
+}
+
+func (ps *PgStore) GetUndecodedSwaps() []string {
+	return ps.queryStrings(undecodedSwapsQuery)
+}
+
+func (ps *PgStore) GetSCIDsEverOwnedBy(owner string) []string {
+	return scidsEverStoring(ps.q(), "owner", owner)
+}
+
+func (ps *PgStore) GetSCIDsEverInCollection(collection string) []string {
+	return scidsEverStoring(ps.q(), "collection", collection)
+}
+
+func (ps *PgStore) GetVariableHistory(scid string, key string) (changes []structs.VariableChange) {
+	return variableHistory(ps.q(), scid, key)
+}
+
+func (ps *PgStore) GetInvoke(txid string) (signer string, entrypoint string) {
+	return getInvoke(ps.q(), txid)
+}
+
+func (ps *PgStore) GetSCIDVariableHeight(scid string, rmax bool) (scidinteractions int64) {
+	return variableHeight(ps.q(), scid, rmax)
+}
+
+func (ps *PgStore) GetInitialSCIDCode(scid string) (sc_code string, err error) {
+	err = ps.q().QueryRow(
+		`SELECT value
+		FROM scvars
+		WHERE scid = ? AND key = 'C' AND ktype = ? AND deleted = 0
+		ORDER BY height ASC, sv_id ASC LIMIT 1;`,
+		scid, typeString).Scan(&sc_code)
+	return
+}
+
+func (ps *PgStore) GetSC(scid string) (sc_code string, hVars []*structs.SCIDVariable) {
+	state, err := loadState(ps.q(), scid, maxHeight)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return state[varKey{"C", typeString}].value, fromState(state)
+}
+
+func (ps *PgStore) GetSCCodeHistory(scid string) (versions []structs.CodeVersion) {
+	rows, err := ps.q().Query(
+		"SELECT height, txid, codehash, code FROM codeversions WHERE scid = ? ORDER BY height ASC, cv_id ASC;", scid)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v structs.CodeVersion
+		rows.Scan(&v.Height, &v.TXID, &v.CodeHash, &v.Code)
+		versions = append(versions, v)
+	}
+	return
+}
+
+func (ps *PgStore) GetSCCodeAtTopoheight(scid string, topoheight int64) (sc_code string, err error) {
+	err = ps.q().QueryRow(
+		"SELECT code FROM codeversions WHERE scid = ? AND height <= ? ORDER BY height DESC, cv_id DESC LIMIT 1;",
+		scid, topoheight).Scan(&sc_code)
+	return
+}
+
+func (ps *PgStore) GetSCIDVariableDetailsAtTopoheight(scid string, topoheight int64) (hVars []*structs.SCIDVariable) {
+	state, err := loadState(ps.q(), scid, topoheight)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return fromState(state)
+}
+
+func (ps *PgStore) GetAllSCIDVariableDetails(scid string) (hVars []*structs.SCIDVariable) {
+	rows, err := ps.q().Query(
+		`SELECT key, ktype, value, vtype FROM (
+			SELECT key, ktype, value, vtype,
+				ROW_NUMBER() OVER (PARTITION BY key, ktype ORDER BY height DESC, sv_id DESC) AS rn
+			FROM scvars
+			WHERE scid = ? AND deleted = 0
+		) AS latest WHERE rn = 1;`,
+		scid)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	var (
+		key, value   string
+		ktype, vtype int
+	)
+	for rows.Next() {
+		rows.Scan(&key, &ktype, &value, &vtype)
+		hVars = append(hVars, &structs.SCIDVariable{Key: decodeVar(key, ktype), Value: decodeVar(value, vtype)})
+	}
+	return
+}
+
+func (ps *PgStore) GetSCIDInteractionHeight(scid string) (scidinteractions []int64) {
+	rows, err := ps.q().Query("SELECT height FROM interactions WHERE txid = ?;", scid)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	var height int64
+	for rows.Next() {
+		rows.Scan(&height)
+		scidinteractions = append(scidinteractions, height)
+	}
+	return
+}
+
+func (ps *PgStore) lookupHeight(scid string, height int64, rmax bool) (at int64) {
+	if rmax {
+		return maxHeight
+	}
+	if height > 0 {
+		return height
+	}
+	ps.q().QueryRow("SELECT COALESCE(MIN(height),0) FROM scvars WHERE scid = ?;", scid).Scan(&at)
+	return
+}
+
+func (ps *PgStore) GetSCIDValuesByKey(scid string, key interface{}, height int64, rmax bool) (valuesstring []string, valuesuint64 []uint64) {
+	k, ktype, ok := encodeVar(key)
+	if !ok {
+		return
+	}
+	var (
+		value          string
+		vtype, deleted int
+	)
+	err := ps.q().QueryRow(
+		`SELECT value, vtype, deleted
+		FROM scvars
+		WHERE scid = ? AND key = ? AND ktype = ? AND height <= ?
+		ORDER BY height DESC, sv_id DESC LIMIT 1;`,
+		scid, k, ktype, ps.lookupHeight(scid, height, rmax)).Scan(&value, &vtype, &deleted)
+	if err != nil || deleted != 0 {
+		return
+	}
+	switch v := decodeVar(value, vtype).(type) {
+	case uint64:
+		valuesuint64 = append(valuesuint64, v)
+	case string:
+		valuesstring = append(valuesstring, v)
+	}
+	return
+}
+
+func (ps *PgStore) GetSCIDKeysByValue(scid string, val interface{}, height int64, rmax bool) (keysstring []string, keysuint64 []uint64) {
+	v, vtype, ok := encodeVar(val)
+	if !ok {
+		return
+	}
+	rows, err := ps.q().Query(
+		`SELECT key, ktype FROM (
+			SELECT key, ktype, value, vtype, deleted,
+				ROW_NUMBER() OVER (PARTITION BY key, ktype ORDER BY height DESC, sv_id DESC) AS rn
+			FROM scvars
+			WHERE scid = ? AND height <= ?
+		) AS latest WHERE rn = 1 AND deleted = 0 AND value = ? AND vtype = ?;`,
+		scid, ps.lookupHeight(scid, height, rmax), v, vtype)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	var (
+		key   string
+		ktype int
+	)
+	for rows.Next() {
+		rows.Scan(&key, &ktype)
+		switch k := decodeVar(key, ktype).(type) {
+		case uint64:
+			keysuint64 = append(keysuint64, k)
+		case string:
+			keysstring = append(keysstring, k)
+		}
+	}
+	return
+}
+
+func (ps *PgStore) GetSCIDVariableTimeline(scid string, key any, from int64, to int64, step int64) (points []structs.VariablePoint) {
+	return keyTimeline(ps.q(), scid, key, from, to, step)
+}
+
+func (ps *PgStore) GetSCIDVariableChanges(scid string, key any, from int64, to int64) (changes []structs.VariablePoint) {
+	return keyChanges(ps.q(), scid, key, from, to)
+}
+
+func (ps *PgStore) GetFingerprint(scid string) (fp structs.Fingerprint, err error) {
+	err = ps.q().QueryRow(
+		"SELECT scid, height, codehash, simhash, family FROM fingerprints WHERE scid = ?;",
+		scid).Scan(&fp.SCID, &fp.Height, &fp.CodeHash, &fp.SimHash, &fp.Family)
+	return
+}
+
+func (ps *PgStore) GetSCIDsWithSameCode(scid string, similar bool) (results []string) {
+	fp, err := ps.GetFingerprint(scid)
+	if err != nil || fp.CodeHash == "" {
+		return
+	}
+	if similar {
+		return ps.GetSCIDsByFamily(fp.Family)
+	}
+	return ps.queryStrings("SELECT scid FROM fingerprints WHERE codehash = ? ORDER BY height ASC;", fp.CodeHash)
+}
+
+func (ps *PgStore) GetSCIDsByFamily(family string) (results []string) {
+	return ps.queryStrings("SELECT scid FROM fingerprints WHERE family = ? ORDER BY height ASC;", family)
+}
+
+func (ps *PgStore) GetCodeFamilies() (results []structs.CodeFamily) {
+	rows, err := ps.q().Query(
+		`SELECT family, COUNT(*), COUNT(DISTINCT codehash), MIN(height)
+		FROM fingerprints
+		WHERE family != ''
+		GROUP BY family
+		ORDER BY COUNT(*) DESC, MIN(height) ASC;`)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f structs.CodeFamily
+		rows.Scan(&f.Family, &f.Count, &f.Variants, &f.FirstHeight)
+		results = append(results, f)
+	}
+	return
+}
+
+func (ps *PgStore) querySwaps(query string, args ...any) (orders []structs.SwapOrder) {
+	height, _ := ps.GetLastIndexHeight()
+	now := time.Now().Unix()
+	rows, err := ps.q().Query(query, args...)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			order          structs.SwapOrder
+			offer, request int64
+		)
+		rows.Scan(&order.SCID, &order.Height, &order.Maker, &order.OfferAsset, &offer, &order.RequestAsset, &request, &order.Expiry, &order.Status)
+		order.OfferAmount, order.RequestAmount = uint64(offer), uint64(request)
+		orders = append(orders, expireSwap(order, height, now))
+	}
+	return
+}
+
+const pgSwapColumns = "scid, height, maker, offer_asset, offer_amount, request_asset, request_amount, expiry, status"
+
+func (ps *PgStore) GetSwap(scid string) (order structs.SwapOrder, err error) {
+	orders := ps.querySwaps("SELECT "+pgSwapColumns+" FROM swaps WHERE scid = ?;", scid)
+	if len(orders) == 0 {
+		return order, fmt.Errorf("no swap order for %s", scid)
+	}
+	return orders[0], nil
+}
+
+func (ps *PgStore) GetSwaps(status string, asset string) (orders []structs.SwapOrder) {
+	query := "SELECT " + pgSwapColumns + " FROM swaps"
+	var args []any
+	if asset != "" {
+		query += " WHERE offer_asset = ? OR request_asset = ?"
+		args = append(args, asset, asset)
+	}
+	for _, order := range ps.querySwaps(query+" ORDER BY height DESC;", args...) {
+		if status == "" || order.Status == status {
+			orders = append(orders, order)
+		}
+	}
+	return
+}
+
+func (ps *PgStore) GetNameAddress(name string) (address string, err error) {
+	err = ps.q().QueryRow("SELECT address FROM names WHERE name = ?;", name).Scan(&address)
+	return
+}
+
+func (ps *PgStore) GetAddressNames(address string) (names []structs.Name) {
+	rows, err := ps.q().Query("SELECT name, address, height, txid FROM names WHERE address = ? ORDER BY height ASC;", address)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n structs.Name
+		rows.Scan(&n.Name, &n.Address, &n.Height, &n.TXID)
+		names = append(names, n)
+	}
+	return
+}
+
+func (ps *PgStore) GetActivityByAddress(address string, limit int, offset int) (activity []structs.Activity, total int) {
+	// A null limit returns every row
+	var rowlimit any
+	if limit > 0 {
+		rowlimit = limit
+	}
+	ps.q().QueryRow(
+		"SELECT (SELECT COUNT(*) FROM scs WHERE owner = ?) + (SELECT COUNT(*) FROM invokes WHERE signer = ?);",
+		address, address).Scan(&total)
+	rows, err := ps.q().Query(
+		`SELECT scid, 'install', COALESCE(height,0), scid, '' FROM scs WHERE owner = ?
+		UNION ALL
+		SELECT scid, 'invoke', height, txid, COALESCE(entrypoint,'') FROM invokes WHERE signer = ?
+		ORDER BY 3 DESC, 4 ASC
+		LIMIT ? OFFSET ?;`,
+		address, address, rowlimit, offset)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a structs.Activity
+		rows.Scan(&a.SCID, &a.Type, &a.Height, &a.TXID, &a.Entrypoint)
+		activity = append(activity, a)
+	}
+	return
+}
+
+// -- Search
+
+// Rebuilds the docs, their text is kept in search_docs
+func pgReindexDocs(q queryer, docs []searchDoc) error {
+	for _, doc := range docs {
+		text, height := docText(q, doc)
+		if _, err := q.Exec(
+			`INSERT INTO search_docs (scid,field,key,ktype,height,text) VALUES (?,?,?,?,?,?)
+			ON CONFLICT (scid,field,key,ktype) DO UPDATE SET height = EXCLUDED.height, text = EXCLUDED.text;`,
+			doc.scid, doc.field, doc.key, doc.ktype, height, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ps *PgStore) SearchText(query string, limit int, offset int) (results []structs.SearchResult, total int) {
+	if strings.TrimSpace(query) == "" {
+		return
+	}
+	var rowlimit any
+	if limit > 0 {
+		rowlimit = limit
+	}
+	ps.q().QueryRow("SELECT COUNT(*) FROM search_docs WHERE to_tsvector('simple', text) @@ plainto_tsquery('simple', ?);", query).Scan(&total)
+	rows, err := ps.q().Query(
+		`SELECT d.scid, COALESCE(scs.scname,''), d.field, d.key, d.height,
+			ts_headline('simple', d.text, query, ?),
+			ts_rank(to_tsvector('simple', d.text), query) * `+fieldWeight+`
+		FROM search_docs d
+		CROSS JOIN plainto_tsquery('simple', ?) AS query
+		LEFT JOIN scs ON scs.scid = d.scid
+		WHERE to_tsvector('simple', d.text) @@ query
+		ORDER BY 7 DESC, d.doc_id ASC
+		LIMIT ? OFFSET ?;`,
+		"StartSel="+HighlightStart+", StopSel="+HighlightEnd+", MaxWords=12, MinWords=4", query, rowlimit, offset)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r structs.SearchResult
+		rows.Scan(&r.SCID, &r.Name, &r.Field, &r.Key, &r.Height, &r.Snippet, &r.Score)
+		results = append(results, r)
+	}
+	return
+}
@@ -0,0 +1,6 @@
+//go:build postgres
+
+package sql
+
+// Built with -tags postgres lib/pq is linked, it registers the "postgres" driver PgStore opens
+import _ "github.com/lib/pq"
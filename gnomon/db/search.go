@@ -0,0 +1,289 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"gnomon/structs"
+)
+
+// Full-text search over contract names, descriptions, code and string variables
+
+const searchDocsSchema = "(" +
+	"doc_id INTEGER PRIMARY KEY, " +
+	"scid TEXT, " +
+	"field TEXT, " +
+	"key TEXT, " +
+	"ktype INTEGER, " +
+	"height INTEGER, " +
+	"updated INTEGER, " +
+	"UNIQUE(scid,field,key,ktype))"
+
+var searchIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS search_docs_updated_index ON search_docs(updated);",
+}
+
+// Marks the matched terms in snippets
+const (
+	HighlightStart = "<b>"
+	HighlightEnd   = "</b>"
+)
+
+// Searchable fields of a contract
+const (
+	fieldName  = "name"
+	fieldDescr = "descr"
+	fieldCode  = "code"
+	fieldVar   = "var"
+)
+
+type searchDoc struct {
+	scid  string
+	field string
+	key   string
+	ktype int
+}
+
+// Name, description and code docs of a contract
+func contractDocs(scid string) []searchDoc {
+	return []searchDoc{{scid: scid, field: fieldName}, {scid: scid, field: fieldDescr}, {scid: scid, field: fieldCode}}
+}
+
+func varDocs(scid string, keys []varKey) (docs []searchDoc) {
+	for _, k := range keys {
+		docs = append(docs, searchDoc{scid, fieldVar, k.key, k.ktype})
+	}
+	return
+}
+
+// Keys whose string value was added, changed or removed between two states
+func textChanges(from, to map[varKey]varValue) (keys []varKey) {
+	for k, v := range to {
+		if old, ok := from[k]; (!ok || old != v) && (v.vtype == typeString || old.vtype == typeString && ok) {
+			keys = append(keys, k)
+		}
+	}
+	for k, v := range from {
+		if _, ok := to[k]; !ok && v.vtype == typeString {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
+
+// Current text of a doc and the height it was set at, blank when the value is gone
+func docText(q queryer, doc searchDoc) (text string, height int64) {
+	switch doc.field {
+	case fieldName, fieldDescr:
+		column := "scname"
+		if doc.field == fieldDescr {
+			column = "scdescr"
+		}
+		q.QueryRow("SELECT COALESCE("+column+",''), COALESCE(height,0) FROM scs WHERE scid = ?;", doc.scid).Scan(&text, &height)
+	case fieldCode:
+		q.QueryRow("SELECT code, height FROM codeversions WHERE scid = ? ORDER BY height DESC, cv_id DESC LIMIT 1;", doc.scid).Scan(&text, &height)
+	case fieldVar:
+		var vtype, deleted int
+		q.QueryRow(
+			"SELECT value, vtype, deleted, height FROM scvars WHERE scid = ? AND key = ? AND ktype = ? ORDER BY height DESC, sv_id DESC LIMIT 1;",
+			doc.scid, doc.key, doc.ktype).Scan(&text, &vtype, &deleted, &height)
+		if vtype != typeString || deleted != 0 {
+			text = ""
+		}
+	}
+	return
+}
+
+// Rebuilds the docs in the sqlite search index for a change at updated
+func reindexDocs(q queryer, docs []searchDoc, updated int64) error {
+	for _, doc := range docs {
+		text, height := docText(q, doc)
+		if _, err := q.Exec(
+			`INSERT INTO search_docs (scid,field,key,ktype,height,updated) VALUES (?,?,?,?,?,?)
+			ON CONFLICT(scid,field,key,ktype) DO UPDATE SET height = excluded.height, updated = excluded.updated;`,
+			doc.scid, doc.field, doc.key, doc.ktype, height, updated); err != nil {
+			return err
+		}
+		var id int64
+		if err := q.QueryRow(
+			"SELECT doc_id FROM search_docs WHERE scid = ? AND field = ? AND key = ? AND ktype = ?;",
+			doc.scid, doc.field, doc.key, doc.ktype).Scan(&id); err != nil {
+			return err
+		}
+		if _, err := q.Exec("DELETE FROM search WHERE rowid = ?;", id); err != nil {
+			return err
+		}
+		if text == "" {
+			continue
+		}
+		if _, err := q.Exec("INSERT INTO search (rowid,text) VALUES (?,?);", id, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Docs whose text is from a height matching where, to rebuild once the rows are deleted
+func docsWhere(q queryer, where string, args ...any) (docs []searchDoc, err error) {
+	rows, err := q.Query("SELECT scid, field, key, ktype FROM search_docs"+where+";", args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var doc searchDoc
+		rows.Scan(&doc.scid, &doc.field, &doc.key, &doc.ktype)
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// Docs of the string variables written by a tx
+func txDocs(q queryer, txid string) (docs []searchDoc, err error) {
+	rows, err := q.Query("SELECT DISTINCT scid, key, ktype FROM scvars WHERE txid = ?;", txid)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		doc := searchDoc{field: fieldVar}
+		rows.Scan(&doc.scid, &doc.key, &doc.ktype)
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// Every doc that can be built from the stored contracts
+func allDocs(q queryer) (docs []searchDoc, err error) {
+	rows, err := q.Query(
+		`SELECT scid, '', 0 FROM scs
+		UNION
+		SELECT DISTINCT scid, key, ktype FROM scvars WHERE vtype = ? AND deleted = 0;`, typeString)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			scid, key string
+			ktype     int
+		)
+		rows.Scan(&scid, &key, &ktype)
+		if key == "" {
+			docs = append(docs, contractDocs(scid)...)
+		} else {
+			docs = append(docs, searchDoc{scid, fieldVar, key, ktype})
+		}
+	}
+	return docs, rows.Err()
+}
+
+// Creates the search tables and indexes the stored contracts
+func migrateSearch(tx *sql.Tx) error {
+	for _, query := range append([]string{
+		"CREATE TABLE IF NOT EXISTS search_docs " + searchDocsSchema,
+		"CREATE VIRTUAL TABLE IF NOT EXISTS search USING fts5(text);",
+	}, searchIndexes...) {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	docs, err := allDocs(tx)
+	if err != nil {
+		return err
+	}
+	if len(docs) != 0 {
+		fmt.Println("Indexing", len(docs), "search docs...")
+	}
+	return reindexDocs(tx, docs, 0)
+}
+
+// Recreates a search table built as FTS4, by builds without the sqlite_fts5 tag, as FTS5
+func migrateSearchFTS5(tx *sql.Tx) error {
+	var module string
+	tx.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'search';").Scan(&module)
+	if strings.Contains(strings.ToLower(module), "fts5") {
+		return nil
+	}
+	for _, query := range []string{"DROP TABLE IF EXISTS search;", "CREATE VIRTUAL TABLE search USING fts5(text);"} {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	rows, err := tx.Query("SELECT doc_id, scid, field, key, ktype FROM search_docs;")
+	if err != nil {
+		return err
+	}
+	ids := map[int64]searchDoc{}
+	for rows.Next() {
+		var (
+			id  int64
+			doc searchDoc
+		)
+		rows.Scan(&id, &doc.scid, &doc.field, &doc.key, &doc.ktype)
+		ids[id] = doc
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(ids) != 0 {
+		fmt.Println("Rebuilding", len(ids), "search docs as FTS5...")
+	}
+	// The docs keep their ids and updated heights, only the text is written again
+	for id, doc := range ids {
+		if text, _ := docText(tx, doc); text != "" {
+			if _, err := tx.Exec("INSERT INTO search (rowid,text) VALUES (?,?);", id, text); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Quotes every word so user input is never read as FTS syntax, the words must all match
+func matchQuery(query string) string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		if word = strings.ReplaceAll(word, `"`, ""); word != "" {
+			terms = append(terms, `"`+word+`"`)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// Names rank above descriptions, descriptions above code and variables
+const fieldWeight = "CASE d.field WHEN 'name' THEN 4 WHEN 'descr' THEN 2 ELSE 1 END"
+
+// Ranked matches of the query with highlighted snippets, limit 0 for all, with the total count
+func (ss *SqlStore) SearchText(query string, limit int, offset int) (results []structs.SearchResult, total int) {
+	match := matchQuery(query)
+	if match == "" {
+		return
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+	ss.DB.QueryRow("SELECT COUNT(*) FROM search WHERE search MATCH ?;", match).Scan(&total)
+	rows, err := ss.DB.Query(
+		`SELECT d.scid, COALESCE(scs.scname,''), d.field, d.key, d.height, snippet(search, 0, ?, ?, '...', 12), -bm25(search) * `+fieldWeight+`
+		FROM search
+		JOIN search_docs d ON d.doc_id = search.rowid
+		LEFT JOIN scs ON scs.scid = d.scid
+		WHERE search MATCH ?
+		ORDER BY 7 DESC, d.doc_id ASC
+		LIMIT ? OFFSET ?;`,
+		HighlightStart, HighlightEnd, match, limit, offset)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r structs.SearchResult
+		rows.Scan(&r.SCID, &r.Name, &r.Field, &r.Key, &r.Height, &r.Snippet, &r.Score)
+		results = append(results, r)
+	}
+	return
+}
@@ -0,0 +1,44 @@
+package sql
+
+import (
+	"testing"
+)
+
+// A search table left as FTS4 by an earlier build is rebuilt as FTS5 with the same docs
+func TestMigrateSearchFTS5(t *testing.T) {
+	disk, err := NewDiskDB(t.TempDir(), "test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.DB.Close()
+	disk.StoreOwner("sc1", "owner", 100, "Gnomon Registry", "names for everyone", "", "", "")
+	if results, _ := disk.SearchText("registry", 10, 0); len(results) != 1 {
+		t.Fatalf("results before %v", results)
+	}
+
+	for _, query := range []string{"DROP TABLE search;", "CREATE VIRTUAL TABLE search USING fts4(text);"} {
+		if _, err := disk.DB.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tx, err := disk.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = migrateSearchFTS5(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var module string
+	disk.DB.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'search';").Scan(&module)
+	if module != "CREATE VIRTUAL TABLE search USING fts5(text)" {
+		t.Fatalf("search table %q", module)
+	}
+	results, total := disk.SearchText("registry", 10, 0)
+	if total != 1 || len(results) != 1 || results[0].SCID != "sc1" || results[0].Score <= 0 {
+		t.Fatalf("results after %v total %d", results, total)
+	}
+}
@@ -0,0 +1,142 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+
+	"gnomon/structs"
+)
+
+// Spam queries, flagged invokes are dropped or kept in quarantine
+
+const quarantineSchema = "(" +
+	"txid TEXT PRIMARY KEY, " +
+	"scid TEXT, " +
+	"signer TEXT, " +
+	"height INTEGER, " +
+	"entrypoint TEXT, " +
+	"reason TEXT)"
+
+var spamIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS invokes_signer_index ON invokes(scid,signer,height);",
+	"CREATE INDEX IF NOT EXISTS quarantine_scid_index ON quarantine(scid,signer,height);",
+}
+
+// Invokes of scid by signer between the heights, indexed and quarantined
+func (ss *SqlStore) CountSignerInvokes(scid string, signer string, from int64, to int64) (count int) {
+	ss.DB.QueryRow(
+		`SELECT
+			(SELECT COUNT(*) FROM invokes WHERE scid = ? AND signer = ? AND height BETWEEN ? AND ?) +
+			(SELECT COUNT(*) FROM quarantine WHERE scid = ? AND signer = ? AND height BETWEEN ? AND ?);`,
+		scid, signer, from, to, scid, signer, from, to).Scan(&count)
+	return
+}
+
+// Indexed invokes of scid past the first max of each signer
+func (ss *SqlStore) GetInvokesOverLimit(scid string, max int) (txs []structs.QuarantinedTx) {
+	rows, err := ss.DB.Query(
+		`SELECT txid, signer, height, entrypoint FROM (
+			SELECT txid, signer, height, IFNULL(entrypoint,'') AS entrypoint,
+				ROW_NUMBER() OVER (PARTITION BY signer ORDER BY height ASC, rowid ASC) AS rn
+			FROM invokes
+			WHERE scid = ? AND signer != ''
+		) WHERE rn > ?;`, scid, max)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		tx := structs.QuarantinedTx{SCID: scid}
+		rows.Scan(&tx.TXID, &tx.Signer, &tx.Height, &tx.Entrypoint)
+		txs = append(txs, tx)
+	}
+	return
+}
+
+func (ss *SqlStore) StoreQuarantined(tx structs.QuarantinedTx) error {
+	return ss.write(func(t *sql.Tx) error {
+		_, err := t.Exec(
+			"INSERT OR REPLACE INTO quarantine (txid,scid,signer,height,entrypoint,reason) VALUES (?,?,?,?,?,?);",
+			tx.TXID, tx.SCID, tx.Signer, tx.Height, tx.Entrypoint, tx.Reason)
+		return err
+	})
+}
+
+// Quarantined invokes oldest first, blank scid for all
+func (ss *SqlStore) GetQuarantined(scid string) (txs []structs.QuarantinedTx) {
+	rows, err := ss.DB.Query(
+		`SELECT txid, scid, signer, height, entrypoint, reason
+		FROM quarantine
+		WHERE ? = '' OR scid = ?
+		ORDER BY height ASC;`, scid, scid)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tx structs.QuarantinedTx
+		rows.Scan(&tx.TXID, &tx.SCID, &tx.Signer, &tx.Height, &tx.Entrypoint, &tx.Reason)
+		txs = append(txs, tx)
+	}
+	return
+}
+
+func (ss *SqlStore) GetQuarantinedTx(txid string) (tx structs.QuarantinedTx, err error) {
+	err = ss.DB.QueryRow(
+		"SELECT txid, scid, signer, height, entrypoint, reason FROM quarantine WHERE txid = ?;",
+		txid).Scan(&tx.TXID, &tx.SCID, &tx.Signer, &tx.Height, &tx.Entrypoint, &tx.Reason)
+	return
+}
+
+func (ss *SqlStore) DeleteQuarantined(txid string) error {
+	return ss.write(func(tx *sql.Tx) error {
+		var height int64
+		if err := tx.QueryRow("SELECT height FROM quarantine WHERE txid = ?;", txid).Scan(&height); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM quarantine WHERE txid = ?;", txid); err != nil {
+			return err
+		}
+		return ss.changed(tx, height)
+	})
+}
+
+// Removes indexed invokes and their variable changes, moving them to quarantine when set
+func (ss *SqlStore) RemoveInvokes(txs []structs.QuarantinedTx, quarantine bool) error {
+	return ss.write(func(tx *sql.Tx) error {
+		var (
+			docs    []searchDoc
+			updated int64
+		)
+		for _, q := range txs {
+			updated = max(updated, q.Height)
+			if err := ss.changed(tx, q.Height); err != nil {
+				return err
+			}
+			written, err := txDocs(tx, q.TXID)
+			if err != nil {
+				return err
+			}
+			docs = append(docs, written...)
+			for _, query := range []string{
+				"DELETE FROM invokes WHERE txid = ?;",
+				"DELETE FROM scvars WHERE txid = ?;",
+				"DELETE FROM checkpoints WHERE txid = ?;",
+			} {
+				if _, err := tx.Exec(query, q.TXID); err != nil {
+					return err
+				}
+			}
+			if quarantine {
+				if _, err := tx.Exec(
+					"INSERT OR REPLACE INTO quarantine (txid,scid,signer,height,entrypoint,reason) VALUES (?,?,?,?,?,?);",
+					q.TXID, q.SCID, q.Signer, q.Height, q.Entrypoint, q.Reason); err != nil {
+					return err
+				}
+			}
+		}
+		return reindexDocs(tx, docs, updated)
+	})
+}
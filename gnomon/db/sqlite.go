@@ -3,15 +3,11 @@ package sql
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
-	"reflect"
-	"strconv"
 	"strings"
-	"sync"
 
 	"gnomon/show"
 	"gnomon/structs"
@@ -20,8 +16,6 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var Mutex sync.Mutex
-
 // copied from main
 var UseMem = false
 var StartAt = int64(0) // Start at Block Height, will be auto-set when using 0
@@ -29,109 +23,129 @@ type SqlStore struct {
 	DB      *sql.DB
 	Db_path string
 	Cancel  bool
+	writer  *writer
+	memory  bool // rows live in memory and are flushed to Db_path
 }
 
-var dbready = true
-var callers = 0
-
-// For external use
-func SetReady(r bool) {
-	ready(r)
-}
-func ready(ready bool) {
-	if UseMem {
-		return
+// Copies the rows indexed in memory to the disk db in one transaction, state and the completed setting included
+func (ss *SqlStore) WriteToDisk(end int64) error {
+	ctx := context.Background()
+	// ATTACH is per connection, keep the whole flush on one
+	conn, err := ss.DB.Conn(ctx)
+	if err != nil {
+		return err
 	}
-	Mutex.Lock()
-	if ready == false {
-		callers++
-	} else if ready == true {
-		callers--
-		if callers == 0 {
-			dbready = true
-		}
+	defer conn.Close()
+	conn.ExecContext(ctx, "PRAGMA busy_timeout = 10000;")
+	if _, err = conn.ExecContext(ctx, "ATTACH DATABASE ? AS diskdb;", ss.Db_path); err != nil {
+		return fmt.Errorf("attach disk DB: %w", err)
 	}
-	Mutex.Unlock()
-}
+	defer conn.ExecContext(ctx, "DETACH DATABASE diskdb;")
 
-func Ask() bool {
-	if UseMem {
-		return true
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
-	for {
-		Mutex.Lock()
-		if dbready {
-			dbready = false
-			Mutex.Unlock()
-			return true
-		}
-		Mutex.Unlock()
+	if err = flushTables(tx, end); err != nil {
+		tx.Rollback()
+		log.Printf("Error copying tables: %v", err)
+		return err
 	}
+	return tx.Commit()
 }
-func (ss *SqlStore) WriteToDisk(end int64) error {
-	ready(false)
-	addon := ";"
-	if end != -1 {
-		addon = " AND height < " + strconv.Itoa(int(end)) + ";"
+
+// Replaces the disk rows from the last flushed height, or the session start if lower, with the memory ones
+func flushTables(tx *sql.Tx, end int64) error {
+	var from, sessionstart int64
+	tx.QueryRow("SELECT IFNULL(MAX(value),0) FROM diskdb.state WHERE name = 'lastindexedheight';").Scan(&from)
+	if tx.QueryRow("SELECT value FROM main.state WHERE name = 'sessionstart';").Scan(&sessionstart) == nil && sessionstart < from {
+		from = sessionstart
+	}
+	where, args := heightRange(from, end)
+
+	for _, q := range []string{
+		"DELETE FROM diskdb.state WHERE name IN ('lastindexedheight','sessionstart');",
+		"INSERT INTO diskdb.state (name,value) SELECT name, value FROM main.state WHERE name IN ('lastindexedheight','sessionstart');",
+		"REPLACE INTO diskdb.settings (name,value) SELECT name, value FROM main.settings WHERE name = 'completed';",
+	} {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
 	}
-	dest, err := sql.Open("sqlite3", ss.Db_path)
-	if err != nil {
-		ready(true)
-		return fmt.Errorf("failed to open destination DB: %w", err)
+	// The rest are bound to the height range
+	var query []string
+	for _, table := range heightTables {
+		if table == "names" {
+			continue
+		}
+		query = append(query,
+			"DELETE FROM diskdb."+table+where+";",
+			"INSERT OR REPLACE INTO diskdb."+table+" SELECT * FROM main."+table+where+";",
+		)
 	}
-	defer dest.Close()
+	// Transfers change older names, so copy by the last change
+	query = append(query, "INSERT OR REPLACE INTO diskdb.names SELECT * FROM main.names"+strings.ReplaceAll(where, "height", "updated")+";")
+	// Search docs rebuilt in the range, with their text
+	rebuilt := "(SELECT doc_id FROM main.search_docs" + strings.ReplaceAll(where, "height", "updated") + ")"
+	query = append(query,
+		"DELETE FROM diskdb.search WHERE rowid IN "+rebuilt+";",
+		"INSERT OR REPLACE INTO diskdb.search_docs SELECT * FROM main.search_docs WHERE doc_id IN "+rebuilt+";",
+		"INSERT INTO diskdb.search (rowid,text) SELECT rowid, text FROM main.search WHERE rowid IN "+rebuilt+";",
+	)
+	// Miner counts are cumulative, copy the addresses counted in the range
+	query = append(query,
+		"INSERT OR REPLACE INTO diskdb.miner_blocks SELECT * FROM main.miner_blocks"+where+";",
+		"INSERT OR REPLACE INTO diskdb.miners SELECT * FROM main.miners"+where+";",
+	)
 
-	_, err = ss.DB.Exec(fmt.Sprintf("ATTACH DATABASE '%s' AS diskdb", ss.Db_path))
-	if err != nil {
-		log.Fatalf("attach disk DB: %v", err)
+	for _, q := range query {
+		if _, err := tx.Exec(q, args...); err != nil {
+			return err
+		}
 	}
 
-	query := ""
-
-	var lastindexedheight int
-	err = dest.QueryRow("SELECT value FROM state WHERE name = 'lastindexedheight' ").Scan(&lastindexedheight)
-	height := ""
-	if lastindexedheight >= 0 {
-		height = strconv.Itoa(lastindexedheight)
+	// Heights with rows changed below the range, eg. reclassified SCs or purged spam, are copied whole
+	query = nil
+	changed := " WHERE height IN (SELECT height FROM main.changed_heights)"
+	for _, table := range heightTables {
+		if table == "names" {
+			continue
+		}
+		query = append(query,
+			"DELETE FROM diskdb."+table+changed+";",
+			"INSERT OR REPLACE INTO diskdb."+table+" SELECT * FROM main."+table+changed+";",
+		)
 	}
-	if err != nil {
-		query += "INSERT INTO diskdb.state (name,value) SELECT * FROM main.state WHERE  name = 'lastindexedheight';"
-		query += "INSERT INTO diskdb.state (name,value) SELECT * FROM main.state WHERE  name = 'sessionstart';"
-	} else {
-		query += "UPDATE diskdb.state SET value = (SELECT value FROM main.state WHERE name = 'lastindexedheight') WHERE name = 'lastindexedheight';"
-		query += "UPDATE diskdb.state SET value = (SELECT value FROM main.state WHERE name = 'sessionstart') WHERE name = 'sessionstart';"
+	for _, q := range append(query, "DELETE FROM main.changed_heights;") {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	//see if the completed record exists yet
-	var checkcompleted string
-	newerr := dest.QueryRow("SELECT value FROM settings WHERE name = 'completed' ").Scan(&checkcompleted)
+// Heights whose rows changed after they may have been flushed, memory mode only
+const changedHeightsSchema = "(" +
+	"height INTEGER PRIMARY KEY)"
 
-	if newerr != nil {
-		query += "INSERT INTO diskdb.settings (name,value) SELECT * FROM main.settings WHERE name = 'completed';"
-	} else {
-		query += "UPDATE diskdb.settings SET value = (SELECT value FROM main.settings WHERE name = 'completed') WHERE name = 'completed';"
+// Records heights with rows changed outside of indexing, the next flush copies them again
+func (ss *SqlStore) changed(q queryer, heights ...int64) error {
+	if !ss.memory {
+		return nil
 	}
-
-	var scs_count int
-	ss.DB.QueryRow("SELECT count(*) as scs_count FROM scs").Scan(&scs_count)
-
-	if scs_count > 0 {
-		query += "INSERT INTO diskdb.scs (scs_id,scid,owner,height,scname,scdescr,scimgurl,class,tags) SELECT * FROM main.scs WHERE height >= " + height + addon
-
-		query += "INSERT INTO diskdb.invokes (scid,signer,txid,height,entrypoint) SELECT * FROM invokes WHERE height >= " + height + addon
-
-		query += "INSERT INTO diskdb.interactions (height,txid,scid) SELECT * FROM interactions WHERE height >= " + height + addon
-
-		query += "INSERT INTO diskdb.variables (v_id,height,txid,vars) SELECT * FROM variables WHERE height >= " + height + addon
+	for _, height := range heights {
+		if _, err := q.Exec("INSERT OR IGNORE INTO changed_heights (height) VALUES (?);", height); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	_, err = ss.DB.Exec(query)
-	if err != nil {
-		log.Printf("Last error copying tables: %v", err)
-	}
-	_, _ = ss.DB.Exec("DETACH DATABASE diskdb")
-	ready(true)
-	return err
+// Marks a height indexed again below the flushed one, eg. a released invoke, so memory mode flushes it
+func (ss *SqlStore) MarkChanged(height int64) error {
+	return ss.write(func(tx *sql.Tx) error {
+		return ss.changed(tx, height)
+	})
 }
 
 func (ss *SqlStore) BackupToDisk() error {
@@ -200,12 +214,44 @@ func NewDiskDB(db_path, db_name string) (*SqlStore, error) {
 		return nil, fmt.Errorf("directory creation err %s - dirpath %s", err, db_path)
 	}
 	full_path := filepath.Join(db_path, db_name)
-	Sql_backend.DB, err = sql.Open("sqlite3", full_path)
-
+	Sql_backend.DB, err = sql.Open("sqlite3", diskDSN(full_path))
+	if err != nil {
+		return nil, err
+	}
 	Sql_backend.Db_path = full_path
+	if err = migrateOnOpen(Sql_backend.DB); err != nil {
+		Sql_backend.DB.Close()
+		return nil, err
+	}
+	if Sql_backend.writer, err = diskWriter(full_path); err != nil {
+		Sql_backend.DB.Close()
+		return nil, err
+	}
 
-	return Sql_backend, err
+	return Sql_backend, nil
+}
+
+// Writes a consistent copy of the db to path, safe while the indexer is writing
+func (ss *SqlStore) VacuumInto(path string) error {
+	_, err := ss.DB.Exec("VACUUM INTO ?;", path)
+	return err
 }
+
+// Bytes of the db pages, and of the free pages among them
+func (ss *SqlStore) PageUsage() (used int64, free int64) {
+	var count, freelist, size int64
+	ss.DB.QueryRow("PRAGMA page_count;").Scan(&count)
+	ss.DB.QueryRow("PRAGMA freelist_count;").Scan(&freelist)
+	ss.DB.QueryRow("PRAGMA page_size;").Scan(&size)
+	return count * size, freelist * size
+}
+
+// Rebuilds the db without its free pages, run while nothing is writing
+func (ss *SqlStore) Shrink() error {
+	_, err := ss.DB.Exec("VACUUM;")
+	return err
+}
+
 func NewSqlDB(db_path, db_name string) (*SqlStore, error) {
 	var err error
 	var SqlBackend *SqlStore = &SqlStore{}
@@ -214,125 +260,82 @@ func NewSqlDB(db_path, db_name string) (*SqlStore, error) {
 		return nil, fmt.Errorf("directory creation err %s - dirpath %s", err, db_path)
 	}
 	full_path := filepath.Join(db_path, db_name)
-	hard, err := sql.Open("sqlite3", full_path)
+	hard, err := sql.Open("sqlite3", diskDSN(full_path))
 	CreateTables(hard)
 	hard.Close()
 
-	SqlBackend.DB, err = sql.Open("sqlite3", "file:diskdb?mode=memory&cache=shared")
+	SqlBackend.DB, err = sql.Open(memoryDriver, "file:diskdb?mode=memory&cache=shared")
 
 	// Load from disk into memory
-	_, err = SqlBackend.DB.Exec(fmt.Sprintf("ATTACH DATABASE '%s' AS diskdb", full_path))
+	_, err = SqlBackend.DB.Exec("ATTACH DATABASE ? AS diskdb;", full_path)
 	if err != nil {
 		log.Fatalf("attach disk DB: %v", err)
 	}
-	_, err = SqlBackend.DB.Exec(
-
-		"CREATE TABLE IF NOT EXISTS main.state AS SELECT * FROM diskdb.state;" +
-			"CREATE TABLE IF NOT EXISTS main.settings (" +
-			"name  TEXT PRIMARY KEY, " +
-			"value  TEXT) ; " +
-			"INSERT INTO settings (name,value) SELECT * FROM diskdb.settings;" +
-			"CREATE TABLE IF NOT EXISTS main.scs (" +
-			"scs_id INTEGER PRIMARY KEY, " +
-			"scid TEXT UNIQUE NOT NULL, " +
-			"owner TEXT NOT NULL, " +
-			"height INTEGER, " +
-			"scname TEXT, " +
-			"scdescr TEXT, " +
-			"scimgurl TEXT, " +
-			"class TEXT, " +
-			"tags TEXT);" +
-			"INSERT INTO scs (scs_id,scid,owner,height,scname,scdescr,scimgurl,class,tags) SELECT * FROM diskdb.scs;" +
-			"CREATE TABLE IF NOT EXISTS main.variables AS SELECT * FROM diskdb.variables;" +
-			"CREATE TABLE IF NOT EXISTS main.invokes (" +
-			"scid TEXT, " +
-			"signer TEXT, " +
-			"txid TEXT UNIQUE, " +
-			"height INTEGER, " +
-			"entrypoint TEXT); " +
-			"INSERT INTO invokes (scid,signer,txid,height,entrypoint) SELECT * FROM diskdb.invokes;" +
-			"CREATE TABLE IF NOT EXISTS main.interactions (" +
-			"height INTEGER, " +
-			"txid TEXT UNIQUE, " +
-			"scid TEXT);" +
-			"INSERT INTO interactions (height,txid,scid) SELECT * FROM diskdb.interactions;")
-	if err != nil {
-		log.Printf("No existing table to copy: %v", err)
+	// Same layout as the disk copy, then the rows
+	if _, err = Migrate(SqlBackend.DB); err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		if _, err = SqlBackend.DB.Exec("INSERT INTO main." + table + " SELECT * FROM diskdb." + table + ";"); err != nil {
+			log.Printf("No existing table to copy: %v", err)
+		}
+	}
+	// The FTS table is copied by rowid, its columns aren't all selectable with *
+	if _, err = SqlBackend.DB.Exec("INSERT INTO main.search (rowid,text) SELECT rowid, text FROM diskdb.search;"); err != nil {
+		log.Printf("No existing search index to copy: %v", err)
 	}
 	_, _ = SqlBackend.DB.Exec("DETACH DATABASE diskdb")
+	if _, err = SqlBackend.DB.Exec("CREATE TABLE IF NOT EXISTS changed_heights " + changedHeightsSchema); err != nil {
+		return nil, err
+	}
 
 	SqlBackend.Db_path = full_path
+	SqlBackend.memory = true
+	SqlBackend.writer = newWriter(SqlBackend.DB)
 
 	return SqlBackend, err
 }
 
-func CreateTables(Db *sql.DB) {
-
-	var startup = [6]string{}
-	startup[0] = "CREATE TABLE IF NOT EXISTS state (" +
-		"name  TEXT, " +
-		"value  INTEGER)"
-
-	startup[1] = "CREATE TABLE IF NOT EXISTS settings (" +
-		"name  TEXT PRIMARY KEY, " +
-		"value  TEXT)"
-
-	startup[2] = "CREATE TABLE IF NOT EXISTS scs (" +
-		"scs_id INTEGER PRIMARY KEY, " +
-		"scid TEXT UNIQUE NOT NULL, " +
-		"owner TEXT NOT NULL, " +
-		"height INTEGER, " +
-		"scname TEXT, " +
-		"scdescr TEXT, " +
-		"scimgurl TEXT, " +
-		"class TEXT, " +
-		"tags TEXT) "
-
-	startup[3] = "CREATE TABLE IF NOT EXISTS variables (" +
-		"v_id INTEGER PRIMARY KEY, " +
-		"height INTEGER, " +
-		"txid TEXT, " +
-		"vars TEXT)"
-
-	startup[4] = "CREATE TABLE IF NOT EXISTS invokes (" +
-		"scid TEXT, " +
-		"signer TEXT, " +
-		"txid TEXT UNIQUE, " +
-		"height INTEGER, " +
-		"entrypoint TEXT)"
-
-	//interactions at heightid INTEGER PRIMARY KEY
-	startup[5] = "CREATE TABLE IF NOT EXISTS interactions (" +
-		"height INTEGER, " +
-		"txid TEXT UNIQUE, " +
-		"scid TEXT)"
-
-	for _, create := range startup {
-		executeQuery(Db, create)
-	}
-
-	var exists int
-	Db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name='state');").Scan(&exists)
-	if exists == 0 {
-		fmt.Println("setting defaults")
-		statement, err := Db.Prepare("CREATE INDEX height_index ON interactions(scid,txid);")
-		handleError(err)
-		statement.Exec()
-
-		statement, err = Db.Prepare("CREATE INDEX invokes_height_index ON invokes(txid);")
-		handleError(err)
-		statement.Exec()
-
-		/*set defaults	*/
-		statement, err = Db.Prepare(`INSERT INTO scs (scid,owner) VALUES('0000000000000000000000000000000000000000000000000000000000000001','Cap''n Crunch');`)
-		handleError(err)
-		statement.Exec()
+const stateSchema = "(" +
+	"name  TEXT, " +
+	"value  INTEGER)"
+
+const settingsSchema = "(" +
+	"name  TEXT PRIMARY KEY, " +
+	"value  TEXT)"
+
+const scsSchema = "(" +
+	"scs_id INTEGER PRIMARY KEY, " +
+	"scid TEXT UNIQUE NOT NULL, " +
+	"owner TEXT NOT NULL, " +
+	"height INTEGER, " +
+	"scname TEXT, " +
+	"scdescr TEXT, " +
+	"scimgurl TEXT, " +
+	"class TEXT, " +
+	"tags TEXT)"
+
+const invokesSchema = "(" +
+	"scid TEXT, " +
+	"signer TEXT, " +
+	"txid TEXT UNIQUE, " +
+	"height INTEGER, " +
+	"entrypoint TEXT)"
+
+//interactions at heightid INTEGER PRIMARY KEY
+const interactionsSchema = "(" +
+	"height INTEGER, " +
+	"txid TEXT UNIQUE, " +
+	"scid TEXT)"
+
+// Tables copied from the disk db into memory, the memory db has its own schema_version
+var tables = []string{"state", "settings", "scs", "scvars", "checkpoints", "fingerprints", "codeversions", "swaps", "quarantine", "names", "invokes", "interactions", "search_docs", "sc_classes", "sc_tags", "invalid_deploys", "miner_blocks", "miners", "name_transfers"}
 
-	}
-
-}
 func (ss *SqlStore) SaveSetting(name, value string) {
-	SaveSetting(ss.DB, name, value)
+	ss.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec("REPLACE INTO settings (name,value) VALUES(?,?);", name, value)
+		return err
+	})
 }
 
 func (ss *SqlStore) LoadSetting(name string) (value string, err error) {
@@ -342,88 +345,69 @@ func (ss *SqlStore) LoadSetting(name string) (value string, err error) {
 func SaveSetting(Db *sql.DB, name, value string) {
 	statement, err := Db.Prepare("REPLACE INTO settings (name,value) VALUES(?,?);")
 	handleError(err)
-	ready(false)
 	statement.Exec(name, value)
-	ready(true)
 }
 
 func LoadSetting(Db *sql.DB, name string) (value string, err error) {
-	ready(false)
 	Db.QueryRow("SELECT value FROM settings WHERE name = ?;", name).Scan(&value)
-	ready(true)
 	return
 }
 
 // called once when we know where we can start from
 func (ss *SqlStore) SaveInitialHeight(startat int64) {
 	//set defaults
-	statement, err := ss.DB.Prepare("INSERT INTO state (name,value) VALUES('lastindexedheight',?);")
-	handleError(err)
-	ready(false)
-	statement.Exec(int(startat))
-	ready(true)
+	ss.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO state (name,value) VALUES('lastindexedheight',?);", int(startat))
+		return err
+	})
 }
 
 // called once when we know where we can start from
 func (ss *SqlStore) SaveInitialSessionStart(startat int64) {
 	//set defaults
-	statement, err := ss.DB.Prepare("INSERT INTO state (name,value) VALUES('sessionstart',?);")
-	handleError(err)
-	ready(false)
-	statement.Exec(int(startat))
-	ready(true)
+	ss.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO state (name,value) VALUES('sessionstart',?);", int(startat))
+		return err
+	})
 }
 
 func (ss *SqlStore) LoadState(name string) (value int, err error) {
-	ready(false)
 	ss.DB.QueryRow("SELECT value FROM state WHERE name = ?;", name).Scan(&value)
-	ready(true)
 	return
 }
 
 // Stores session starting height
 func (ss *SqlStore) StoreSessionStart(start int64) {
-	ready(false)
-	result, err := ss.DB.Exec("UPDATE state SET value = '" + strconv.Itoa(int(start)) + "' WHERE name = 'sessionstart';")
-
-	if err == nil {
-		affected_rows, _ := result.RowsAffected()
-		if affected_rows != 0 {
-			ready(true)
-			return
-		}
-	} else {
+	err := ss.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE state SET value = ? WHERE name = 'sessionstart';", start)
+		return err
+	})
+	if err != nil {
 		fmt.Println("Error storing session start")
 	}
-	ready(true)
-	return
 }
 
 // Stores last indexed height - this is for stateful stores on close and reference on open
 func (ss *SqlStore) StoreLastIndexHeight(last_indexedheight int64) (changes bool, err error) {
-	ready(false)
-	result, err := ss.DB.Exec("UPDATE state SET value = '" + strconv.Itoa(int(last_indexedheight)) + "' WHERE name = 'lastindexedheight';")
-
-	if err == nil {
-		affected_rows, _ := result.RowsAffected()
-		if affected_rows != 0 {
-			changes = true
-			ready(true)
-			return
+	err = ss.write(func(tx *sql.Tx) error {
+		result, err := tx.Exec("UPDATE state SET value = ? WHERE name = 'lastindexedheight';", last_indexedheight)
+		if err != nil {
+			return err
 		}
-	} else {
+		affected_rows, _ := result.RowsAffected()
+		changes = affected_rows != 0
+		return nil
+	})
+	if err != nil {
 		fmt.Println("Error storing last index height")
 	}
-	ready(true)
 	return
 }
 
 // Gets last indexed height - this is for stateful stores on close and reference on open
 func (ss *SqlStore) GetLastIndexHeight() (topoheight int64, err error) {
 	var lastindexedheight int
-	ready(false)
 	err = ss.DB.QueryRow("SELECT value FROM state WHERE name = 'lastindexedheight' ").Scan(&lastindexedheight)
-	ready(true)
 	if err == nil {
 		if lastindexedheight > 0 {
 			topoheight = int64(lastindexedheight)
@@ -450,92 +434,37 @@ func handleError(err error) {
 }
 
 func (ss *SqlStore) TrimHeight(start int64, end int64) int64 {
-	addon := ";"
-	if end != -1 {
-		addon = " AND height < " + strconv.Itoa(int(end)) + ";"
-	}
-	ready(false)
-	statement, err := ss.DB.Prepare("DELETE FROM scs WHERE height >= " + strconv.Itoa(int(start)) + addon)
-	handleError(err)
-	statement.Exec()
-	statement, err = ss.DB.Prepare("DELETE FROM variables WHERE height >= " + strconv.Itoa(int(start)) + addon)
-	handleError(err)
-	statement.Exec()
-	statement, err = ss.DB.Prepare("DELETE FROM invokes WHERE height >= " + strconv.Itoa(int(start)) + addon)
-	handleError(err)
-	statement.Exec()
-	statement, err = ss.DB.Prepare("DELETE FROM interactions WHERE height >= " + strconv.Itoa(int(start)) + addon)
+	where, args := heightRange(start, end)
+	err := ss.write(func(tx *sql.Tx) error {
+		_, err := deleteHeights(tx, start, where, args...)
+		return err
+	})
 	handleError(err)
-	statement.Exec()
-	ready(true)
-	return int64(start)
+	return start
 }
 
-var SpamLevel = "0"
-var Spammers []string
-
-func (ss *SqlStore) RidSpam() {
-	if SpamLevel == "n" {
-		SpamLevel = "0"
-	}
-	rows, err := ss.DB.Query(`
-	SELECT DISTINCT signer
-	FROM invokes
-	WHERE signer IN (
-		SELECT signer
-		FROM invokes
-		GROUP BY signer	
-		HAVING COUNT(signer) > `+SpamLevel+` AND invokes.scid = '0000000000000000000000000000000000000000000000000000000000000001'
-		ORDER BY COUNT(signer) DESC
-	)`, nil)
+// Deletes the rows matching where from the height tables, rebases the change sets kept after them and rebuilds the search docs they fed as of height
+func deleteHeights(q queryer, height int64, where string, args ...any) (removed int64, err error) {
+	docs, err := docsWhere(q, where, args...)
 	if err != nil {
-		fmt.Println(err)
-	}
-	// Just filter for the latest spammers
-	Spammers := Spammers[0:0]
-	var (
-		spammeraddress string
-	)
-	for rows.Next() {
-		rows.Scan(&spammeraddress)
-		Spammers = append(Spammers, spammeraddress)
-	}
-	in := ""
-	for _, spammer := range Spammers {
-		in += "'" + spammer + "',"
+		return
 	}
-	spamaddrs := strings.TrimRight(in, ",")
-
-	var spammerstxs []string
-
-	rows, err = ss.DB.Query("SELECT txid FROM invokes WHERE signer IN ("+spamaddrs+") AND scid = '0000000000000000000000000000000000000000000000000000000000000001';", nil)
+	rebases, err := rebasesWhere(q, where, args...)
 	if err != nil {
-		fmt.Println(err)
-	}
-	var (
-		spammertx string
-	)
-	for rows.Next() {
-		rows.Scan(&spammertx)
-		spammerstxs = append(spammerstxs, spammertx)
-	}
-	in = ""
-	for _, spammertx := range spammerstxs {
-		in += "'" + spammertx + "',"
+		return
 	}
-	spamtxs := strings.TrimRight(in, ",")
-	show.NewMessage(show.Message{Text: "Deleting invokes:", Vars: []any{spamaddrs}})
-
-	_, err = ss.DB.Exec("DELETE FROM invokes WHERE signer IN (" + spamaddrs + ") AND scid = '0000000000000000000000000000000000000000000000000000000000000001';")
-	if err != nil {
-		log.Fatal(err)
+	for _, table := range heightTables {
+		result, err := q.Exec("DELETE FROM "+table+where+";", args...)
+		if err != nil {
+			return removed, err
+		}
+		affected, _ := result.RowsAffected()
+		removed += affected
 	}
-
-	//fmt.Println("deleting vars:", spamtxs)
-	_, err = ss.DB.Exec("DELETE FROM variables WHERE txid IN (" + spamtxs + ")")
-	if err != nil {
-		log.Fatal(err)
+	if err = rebaseChanges(q, rebases); err != nil {
+		return
 	}
+	return removed, reindexDocs(q, docs, height)
 }
 
 // --- extras...
@@ -583,7 +512,7 @@ func (ss *SqlStore) ViewTables() {
 		show.NewMessage(show.Message{Text: owner + "--" + scid + "--" + scname + "--" + class + "--" + tags})
 	}
 	show.NewMessage(show.Message{Text: "Showing Vars: "})
-	rows, err = hard.Query("SELECT count(*) FROM variables", nil)
+	rows, err = hard.Query("SELECT count(*) FROM scvars", nil)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -634,144 +563,137 @@ func (ss *SqlStore) ViewTables() {
 }
 
 func (ss *SqlStore) GetSCIDsByClass(class_list []string) (results []string) {
-	// This can be sured up by checking the list of classes or parameterizing the query (somehow lol).
-	qinsert := ""
-	var qvars = []any{}
-	for _, class := range class_list {
-		qinsert += "OR (class = ?) OR (class LIKE (? || ',%')) OR (class LIKE ('%,' || ? || ',%')) OR (class LIKE ('%,' || ?)) "
-		qvars = append(
-			qvars,
-			class,
-			class,
-			class,
-			class,
-		)
-	}
-	qinsert = strings.TrimPrefix(qinsert, "OR ")
-	ready(false)
-	rows, err := ss.DB.Query("SELECT scid FROM scs WHERE "+qinsert, qvars...)
-	if err != nil {
-		fmt.Println(err)
+	if len(class_list) == 0 {
+		return
 	}
-	ready(true)
-	var scid string
-	for rows.Next() {
-		rows.Scan(&scid)
-		results = append(results, scid)
+	ss.inValues(class_list, func(q queryer, list string, args []any) {
+		results = queryStrings(q, "SELECT scid FROM scs WHERE "+metaMatch("sc_classes", "class", list)+" ORDER BY height ASC, scid ASC;", args...)
+	})
+	return
+}
+
+// SCIDs having any of the classes installed above a height, oldest first
+func (ss *SqlStore) GetSCIDsByClassAbove(class_list []string, height int64) (results []string) {
+	if len(class_list) == 0 {
+		return
 	}
-	return results
+	ss.inValues(class_list, func(q queryer, list string, args []any) {
+		results = queryStrings(q, "SELECT scid FROM scs WHERE height > ? AND "+metaMatch("sc_classes", "class", list)+" ORDER BY height ASC, scid ASC;", append([]any{height}, args...)...)
+	})
+	return
 }
 
 func (ss *SqlStore) GetSCIDsByTags(tags_list []string) (results []string) {
-	qinsert := ""
-	var qvars = []any{}
-	for _, tag := range tags_list {
-		qinsert += "OR (tags = ?) OR (tags LIKE (? || ',%')) OR (tags LIKE ('%,' || ? || ',%')) OR (tags LIKE ('%,' || ?)) "
-		qvars = append(
-			qvars,
-			tag,
-			tag,
-			tag,
-			tag,
-		)
-	}
-	qinsert = strings.TrimPrefix(qinsert, "OR ")
-	ready(false)
-	rows, err := ss.DB.Query("SELECT scid FROM scs WHERE "+qinsert, qvars...)
-	if err != nil {
-		fmt.Println(err)
-	}
-	ready(true)
-	var (
-		scid string
-	)
-	for rows.Next() {
-		rows.Scan(&scid)
-		r := scid
-		results = append(results, r)
+	if len(tags_list) == 0 {
+		return
 	}
-	return results
+	ss.inValues(tags_list, func(q queryer, list string, args []any) {
+		results = queryStrings(q, "SELECT scid FROM scs WHERE "+metaMatch("sc_tags", "tag", list)+" ORDER BY height ASC, scid ASC;", args...)
+	})
+	return
 }
 
 func (ss *SqlStore) GetSCsByTags(tags_list []string) (results []map[string]any) {
-	qinsert := ""
-	var qvars = []any{}
-	for _, tag := range tags_list {
-		qinsert += "OR (tags = ?) OR (tags LIKE (? || ',%')) OR (tags LIKE ('%,' || ? || ',%')) OR (tags LIKE ('%,' || ?)) "
-		qvars = append(
-			qvars,
-			tag,
-			tag,
-			tag,
-			tag,
-		)
-	}
-	qinsert = strings.TrimPrefix(qinsert, "OR ")
-	ready(false)
-	rows, err := ss.DB.Query("SELECT scid,owner,height,scname,scdescr,scimgurl,class,tags FROM scs WHERE "+qinsert, qvars...)
-	if err != nil {
-		fmt.Println(err)
+	if len(tags_list) == 0 {
+		return
 	}
-	ready(true)
-	var (
-		scid     string
-		owner    string
-		height   int
-		scname   string
-		scdescr  string
-		scimgurl string
-		class    string
-		tags     string
-	)
+	ss.inValues(tags_list, func(q queryer, list string, args []any) {
+		rows, err := q.Query("SELECT scid,owner,height,scname,scdescr,scimgurl,class,tags FROM scs WHERE "+metaMatch("sc_tags", "tag", list)+" ORDER BY height ASC, scid ASC;", args...)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer rows.Close()
+		var (
+			scid     string
+			owner    string
+			height   int
+			scname   string
+			scdescr  string
+			scimgurl string
+			class    string
+			tags     string
+		)
 
-	for rows.Next() {
-		rows.Scan(&scid, &owner, &height, &scname, &scdescr, &scimgurl, &class, &tags)
-		r := map[string]any{
-			"scid":     scid,
-			"owner":    owner,
-			"height":   height,
-			"scname":   scname,
-			"scdescr":  scdescr,
-			"scimgurl": scimgurl,
-			"class":    class,
-			"tags":     tags,
+		for rows.Next() {
+			rows.Scan(&scid, &owner, &height, &scname, &scdescr, &scimgurl, &class, &tags)
+			r := map[string]any{
+				"scid":     scid,
+				"owner":    owner,
+				"height":   height,
+				"scname":   scname,
+				"scdescr":  scdescr,
+				"scimgurl": scimgurl,
+				"class":    class,
+				"tags":     tags,
+			}
+			results = append(results, r)
 		}
-		results = append(results, r)
-	}
+	})
 	return results
 }
 
 // Updates SC metadata
 func (ss *SqlStore) UpdateSCMeta(scid, class, tags string) {
-	ready(false) //not really need right now
-	result, err := ss.DB.Exec(
-		`UPDATE scs
-		SET class = ?, tags = ?
-		WHERE scid = ?;
-		`, class, tags, scid)
-	if err == nil {
-		affected_rows, _ := result.RowsAffected()
-		if affected_rows != 0 {
-			ready(true)
-			return
+	err := ss.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			`UPDATE scs
+			SET class = ?, tags = ?
+			WHERE scid = ?;
+			`, class, tags, scid)
+		if err != nil {
+			return err
 		}
-	} else {
+		var height int64
+		tx.QueryRow("SELECT height FROM scs WHERE scid = ?;", scid).Scan(&height)
+		if err = ss.changed(tx, height); err != nil {
+			return err
+		}
+		return storeSCMeta(tx, scid, class, tags)
+	})
+	if err != nil {
 		fmt.Println("Error updating metadata")
 	}
-	ready(true)
+}
+
+// Gets the next chunk of SC metadata ordered by scid, for resumable passes over all SCs
+func (ss *SqlStore) GetSCMetaAfter(after string, limit int) (results []structs.SCMeta) {
+	return scMetaAfter(ss.DB, after, limit)
+}
+
+func (ss *SqlStore) CountSCs() (count int) {
+	ss.DB.QueryRow("SELECT COUNT(*) FROM scs;").Scan(&count)
+	return
+}
+
+func scMetaAfter(q queryer, after string, limit int) (results []structs.SCMeta) {
+	rows, err := q.Query(
+		`SELECT scid, owner, COALESCE(class,''), COALESCE(tags,'')
+		FROM scs
+		WHERE scid > ?
+		ORDER BY scid ASC
+		LIMIT ?;`, after, limit)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var meta structs.SCMeta
+		rows.Scan(&meta.SCID, &meta.Owner, &meta.Class, &meta.Tags)
+		results = append(results, meta)
+	}
 	return
 }
 
 // Gets SCs
 func (ss *SqlStore) GetSCIDS() (results []string) {
 
-	ready(false)
 	rows, _ := ss.DB.Query(
 		`SELECT height,scid
 		FROM scs
 		GROUP BY height
 		ORDER BY height ASC;`)
-	ready(true)
 	var (
 		height int
 		scid   string
@@ -785,76 +707,23 @@ func (ss *SqlStore) GetSCIDS() (results []string) {
 
 // Get sc code from the install tx
 func (ss *SqlStore) GetInitialSCIDCode(scid string) (sc_code string, err error) {
-	var height int
-	var vars string
-	ready(false)
-	err = ss.DB.QueryRow("SELECT height,vars FROM variables WHERE txid = ? ", scid).Scan(&height, &vars)
-	ready(true)
-	var heights []int64
-	results := make(map[int64][]*structs.SCIDVariable)
-	var variables []*structs.SCIDVariable
-	_ = json.Unmarshal([]byte(vars), &variables)
-	topoheight, _ := strconv.ParseInt(string(height), 10, 64)
-	results[topoheight] = variables
-	heights = append(heights, topoheight)
-
-	if results != nil {
-		variables = getTypedVariables(heights, results)
-	}
-	for _, v := range variables {
-		if v.Key == "C" {
-			sc_code = v.Value.(string)
-		}
-	}
+	err = ss.DB.QueryRow(
+		`SELECT value
+		FROM scvars
+		WHERE scid = ? AND key = 'C' AND ktype = ? AND deleted = 0
+		ORDER BY height ASC, sv_id ASC LIMIT 1;`,
+		scid, typeString).Scan(&sc_code)
 	return
 }
 
 // Get sc code and variables from latest record
 func (ss *SqlStore) GetSC(scid string) (sc_code string, hVars []*structs.SCIDVariable) {
-
-	results := make(map[int64][]*structs.SCIDVariable)
-	var heights []int64
-
-	ready(false)
-
-	rows, _ := ss.DB.Query(
-		`SELECT height, vars
-			FROM variables
-			WHERE txid IN (
-				SELECT txid
-				FROM invokes
-				WHERE scid = ?
-			) OR txid IN (
-				SELECT scid
-				FROM scs
-				WHERE scid = ?
-			)  ORDER BY height DESC LIMIT 1;`,
-		scid,
-		scid,
-	)
-	ready(true)
-	var (
-		vars   string
-		height int
-	)
-
-	for rows.Next() {
-		rows.Scan(&height, &vars)
-		topoheight, _ := strconv.ParseInt(string(height), 10, 64)
-		heights = append(heights, topoheight)
-		var variables []*structs.SCIDVariable
-		_ = json.Unmarshal([]byte(vars), &variables)
-		results[topoheight] = variables
-	}
-
-	if results != nil {
-		hVars = getTypedVariables(heights, results)
-	}
-	for _, v := range hVars {
-		if v.Key == "C" {
-			sc_code = v.Value.(string)
-		}
+	state, err := loadState(ss.DB, scid, maxHeight)
+	if err != nil {
+		fmt.Println(err)
 	}
+	hVars = fromState(state)
+	sc_code = state[varKey{"C", typeString}].value
 	return
 }
 
@@ -868,46 +737,44 @@ func (ss *SqlStore) StoreOwner(scid string, owner string, height int, scname str
 	if ss.Cancel {
 		return
 	}
-	ready(false)
-	statement, err := ss.DB.Prepare("INSERT INTO scs (scid,owner,height,scname,scdescr,scimgurl,class,tags) VALUES (?,?,?,?,?,?,?,?)")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	result, err := statement.Exec(
-		scid,
-		owner,
-		height,
-		scname,
-		scdescr,
-		scimgurl,
-		class,
-		tags,
-	)
-
-	if err == nil {
-		last_insert_id, _ := result.LastInsertId()
-		//	fmt.Println("ownerinsertid: ", last_insert_id)
-		if last_insert_id >= 0 {
-			changes = true
-			ready(true)
-			return
+	err = ss.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			"INSERT INTO scs (scid,owner,height,scname,scdescr,scimgurl,class,tags) VALUES (?,?,?,?,?,?,?,?)",
+			scid,
+			owner,
+			height,
+			scname,
+			scdescr,
+			scimgurl,
+			class,
+			tags,
+		)
+		if err == nil {
+			err = storeSCMeta(tx, scid, class, tags)
 		}
-
+		if err != nil {
+			return err
+		}
+		return reindexDocs(tx, contractDocs(scid)[:2], int64(height))
+	})
+	if err == nil {
+		changes = true
 	} else {
 		ss.Cancel = true
 	}
-	ready(true)
 	return
+}
 
+// Returns the deployer and class csv of a given scid
+func (ss *SqlStore) GetSCOwnerAndClass(scid string) (owner string, class string) {
+	ss.DB.QueryRow("SELECT owner, IFNULL(class,'') FROM scs WHERE scid = ?;", scid).Scan(&owner, &class)
+	return
 }
 
 // Returns all of the deployed SCIDs with their corresponding owners (who deployed it)
 func (ss *SqlStore) GetAllOwnersAndSCIDs() map[string]string {
 	results := make(map[string]string)
-	ready(false)
 	rows, _ := ss.DB.Query("SELECT scid, owner FROM scs", nil)
-	ready(true)
 	var (
 		scid  string
 		owner string
@@ -922,118 +789,62 @@ func (ss *SqlStore) GetAllOwnersAndSCIDs() map[string]string {
 }
 
 // Stores SC variables at a given topoheight (called on any new scdeploy or scinvoke actions)
-func (ss *SqlStore) StoreSCIDVariableDetails(scid string, variables []*structs.SCIDVariable, topoheight int64) (changes bool, err error) {
+func (ss *SqlStore) StoreSCIDVariableDetails(scid string, txid string, variables []*structs.SCIDVariable, topoheight int64) (changes bool, err error) {
 	if ss.Cancel {
 		return
 	}
-	confBytes, err := json.Marshal(variables)
-	if err != nil {
-		return changes, fmt.Errorf("[StoreSCIDVariableDetails] could not marshal getinfo info: %v", err)
-	}
-
-	ready(false) //maybe look up the scid id
-	statement, err := ss.DB.Prepare("INSERT INTO variables (height, txid, vars) VALUES (?,?,?)")
-
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	result, err := statement.Exec(
-		int(topoheight),
-		scid,
-		confBytes,
-	)
-
-	if err == nil {
-		last_insert_id, _ := result.LastInsertId()
-		if last_insert_id >= 0 {
-			changes = true
-			ready(true)
-			return
+	// The diff is read and written in the same transaction, writes are serialized by the writer
+	err = ss.write(func(tx *sql.Tx) error {
+		keys, err := storeChanges(tx, scid, txid, topoheight, toState(variables))
+		if err != nil {
+			return err
 		}
+		return reindexDocs(tx, varDocs(scid, keys), topoheight)
+	})
+	if err == nil {
+		changes = true
 	} else {
 		ss.Cancel = true
 	}
-	ready(true)
 	return
 }
 
 // Gets SC variables at a given topoheight
 func (ss *SqlStore) GetSCIDVariableDetailsAtTopoheight(scid string, topoheight int64) (hVars []*structs.SCIDVariable) {
-	results := make(map[int64][]*structs.SCIDVariable)
-	var heights []int64
-
-	ready(false)
-
-	rows, _ := ss.DB.Query(
-		`SELECT height, vars
-			FROM variables
-			WHERE txid IN (
-				SELECT txid
-				FROM invokes
-				WHERE scid = ?
-			) OR txid IN (
-				SELECT scid
-				FROM scs
-				WHERE scid = ?
-			) AND height <= ? ORDER BY height ASC;`,
-		scid,
-		scid,
-		int(topoheight),
-	)
-	ready(true)
-	var (
-		vars   string
-		height int
-	)
-
-	for rows.Next() {
-		rows.Scan(&height, &vars)
-		topoheight, _ := strconv.ParseInt(string(height), 10, 64)
-		heights = append(heights, topoheight)
-		var variables []*structs.SCIDVariable
-		_ = json.Unmarshal([]byte(vars), &variables)
-		results[topoheight] = variables
-	}
-
-	if results != nil {
-		hVars = getTypedVariables(heights, results)
+	state, err := loadState(ss.DB, scid, topoheight)
+	if err != nil {
+		fmt.Println(err)
 	}
-
-	return
+	return fromState(state)
 }
 
 // Function not needed for indexer...
-// Gets SC variables at all topoheights
+// Gets the latest value of every key the SC has ever stored
 func (ss *SqlStore) GetAllSCIDVariableDetails(scid string) (hVars []*structs.SCIDVariable) {
-	results := make(map[int64][]*structs.SCIDVariable)
-	var heights []int64
-	//fmt.Println("GetAllSCIDVariableDetails", bName)
-	ready(false)
-	rows, _ := ss.DB.Query("SELECT height,vars FROM variables WHERE txid =? ORDER BY height ASC",
+	rows, err := ss.DB.Query(
+		`SELECT key, ktype, value, vtype FROM (
+			SELECT key, ktype, value, vtype,
+				ROW_NUMBER() OVER (PARTITION BY key, ktype ORDER BY height DESC, sv_id DESC) AS rn
+			FROM scvars
+			WHERE scid = ? AND deleted = 0
+		) WHERE rn = 1;`,
 		scid,
 	)
-	ready(true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
 	var (
-		vars   string
-		height int
+		key   string
+		ktype int
+		value string
+		vtype int
 	)
-
 	for rows.Next() {
-		rows.Scan(&height, &vars)
-		topoheight, _ := strconv.ParseInt(string(height), 10, 64)
-		heights = append(heights, topoheight)
-		var variables []*structs.SCIDVariable
-		_ = json.Unmarshal([]byte(vars), &variables)
-		results[topoheight] = variables
-	}
-
-	//fmt.Println("results: ", results)
-
-	if results != nil {
-		hVars = getTypedVariables(heights, results)
+		rows.Scan(&key, &ktype, &value, &vtype)
+		hVars = append(hVars, &structs.SCIDVariable{Key: decodeVar(key, ktype), Value: decodeVar(value, vtype)})
 	}
-
 	return
 }
 
@@ -1042,27 +853,18 @@ func (ss *SqlStore) StoreSCIDInvoke(scidstoadd structs.SCIDToIndexStage, height
 
 	//	fmt.Println("\nStoreSCIDInvoke... TXHash " + scidstoadd.TXHash + " ParamsSCID " + scidstoadd.Params.SCID + " Height:" + strconv.Itoa(int(height)))
 
-	ready(false)
-	statement, err := ss.DB.Prepare("INSERT INTO invokes (scid,signer,txid,height,entrypoint) VALUES (?,?,?,?,?);")
-	if err == nil {
-
-		result, err := statement.Exec(
+	ss.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			"INSERT INTO invokes (scid,signer,txid,height,entrypoint) VALUES (?,?,?,?,?);",
 			scidstoadd.Params.SCID,
 			scidstoadd.Fsi.Signer,
 			scidstoadd.TXHash,
 			height,
 			scidstoadd.Entrypoint,
 		)
-
-		if err == nil {
-			last_insert_id, _ := result.LastInsertId()
-			if last_insert_id >= 0 {
-				//fmt.Println("\n INSERTED NEW RECORD " + strconv.Itoa(int(last_insert_id)) + " H:" + strconv.Itoa(int(height)))
-				changes = true
-			}
-		}
-	}
-	ready(true)
+		changes = err == nil
+		return err
+	})
 	return
 
 }
@@ -1072,30 +874,21 @@ func (ss *SqlStore) StoreSCIDInteractionHeight(scidstoadd structs.SCIDToIndexSta
 
 	//fmt.Println("\nStoreSCIDInteractionHeight... TXHash " + scidstoadd.TXHash + " ParamsSCID " + scidstoadd.Params.SCID + " Height:" + strconv.Itoa(int(height)))
 
-	ready(false)
 	var scs_id int
 	if scidstoadd.Type == "install" {
 		//it is a SC install and already saved
-		ready(true)
 		return
 	}
-	statement, err := ss.DB.Prepare("INSERT INTO interactions (height,txid,scid) VALUES (?,?,?);")
-	if err != nil {
-		log.Fatal(err)
-	}
-	result, err := statement.Exec(
-		height,
-		scidstoadd.TXHash,
-		scs_id,
-	)
-	if err == nil {
-		last_insert_id, _ := result.LastInsertId()
-		if last_insert_id >= 0 {
-			//	fmt.Println("\n INSERTED NEW RECORD " + strconv.Itoa(int(last_insert_id)) + " H:" + strconv.Itoa(int(height)))
-			changes = true
-		}
-	}
-	ready(true)
+	ss.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			"INSERT INTO interactions (height,txid,scid) VALUES (?,?,?);",
+			height,
+			scidstoadd.TXHash,
+			scs_id,
+		)
+		changes = err == nil
+		return err
+	})
 	return
 
 }
@@ -1104,7 +897,6 @@ func (ss *SqlStore) StoreSCIDInteractionHeight(scidstoadd structs.SCIDToIndexSta
 func (ss *SqlStore) GetSCIDInteractionHeight(scid string) (scidinteractions []int64) {
 	//	fmt.Println("GetSCIDInteractionHeight... ")"SELECT interaction_heights.height FROM interactions INNER JOIN interactions.i_id ON interaction_heights WHERE scid=?"
 
-	ready(false)
 	rows, err := ss.DB.Query(
 		"SELECT height FROM interactions WHERE txid=?",
 		scid)
@@ -1121,48 +913,22 @@ func (ss *SqlStore) GetSCIDInteractionHeight(scid string) (scidinteractions []in
 		scidinteractions = append(scidinteractions, int64(height))
 	}
 
-	ready(true)
-
 	return
 
 }
 
 // Gets SC interaction height and detail by a given SCID (maybe add to api)
 func (ss *SqlStore) GetSCIDVariableHeight(scid string, rmax bool) (scidinteractions int64) {
-	//	fmt.Println("GetSCIDInteractionHeight... ")"SELECT interaction_heights.height FROM interactions INNER JOIN interactions.i_id ON interaction_heights WHERE scid=?"
-	sort := "DESC"
-	if !rmax {
-		sort = "ASC"
-	}
-	ready(false)
-	rows, _ := ss.DB.Query(
-		`SELECT height
-		FROM variables
-		WHERE txid IN (
-			SELECT txid
-			FROM invokes
-			WHERE scid = ?
-		) OR txid IN (
-			SELECT scid
-			FROM scs
-			WHERE scid = ?
-		) ORDER BY height `+sort+" LIMIT 1",
-		scid,
-		scid,
-	)
-	ready(true)
-	var (
-		height int
-	)
+	return variableHeight(ss.DB, scid, rmax)
+}
 
-	for rows.Next() {
-		rows.Scan(&height)
-		//println("height ", height)
-		return int64(height)
+func variableHeight(q queryer, scid string, rmax bool) (height int64) {
+	sort := "MAX"
+	if !rmax {
+		sort = "MIN"
 	}
-
+	q.QueryRow("SELECT COALESCE("+sort+"(height),0) FROM scvars WHERE scid = ?;", scid).Scan(&height)
 	return
-
 }
 
 /*
@@ -1193,397 +959,79 @@ func (ss *SqlStore) GetInteractionIndex(topoheight int64, heights []int64) (heig
 
 */
 
-// Gets SC values by key at given topoheight who's key equates to a given interface{} (string/uint64)
-func (ss *SqlStore) GetSCIDValuesByKey(scid string, key interface{}, height int64, rmax bool) (valuesstring []string, valuesuint64 []uint64) {
-	// TODO: If there's no interaction height, do we go get scvars against daemon and store? Or do we just ignore and return nil
-	variables := ss.GetSCIDVariableDetailsAtTopoheight(scid, ss.GetSCIDVariableHeight(scid, rmax))
-	// Switch against the value passed. If it's a uint64 or string
-	return getTyped(key, variables, "keys")
-}
-
-// Gets SC variable keys at given topoheight who's value equates to a given interface{} (string/uint64)
-func (ss *SqlStore) GetSCIDKeysByValue(scid string, val interface{}, height int64, rmax bool) (keysstring []string, keysuint64 []uint64) {
-	// TODO: If there's no interaction height, do we go get scvars against daemon and store? Or do we just ignore and return nil
-	variables := ss.GetSCIDVariableDetailsAtTopoheight(scid, ss.GetSCIDVariableHeight(scid, rmax))
-	// Switch against the value passed. If it's a uint64 or string
-	return getTyped(val, variables, "vars")
-}
-
-// SC typing/value extraction fucntions
-func getTyped(entity interface{}, variables []*structs.SCIDVariable, gettype string) (strings []string, uint64s []uint64) {
-	switch inpvar := entity.(type) {
-	case uint64:
-		for _, v := range variables {
-			var find any
-			var use any
-			if gettype == "keys" {
-				find = v.Key
-				use = v.Value
-			} else {
-				find = v.Value
-				use = v.Key
-			}
-			switch ckey := find.(type) {
-			case float64:
-				if inpvar == uint64(ckey) {
-					switch cval := use.(type) {
-					case float64:
-						uint64s = append(uint64s, uint64(cval))
-					case uint64:
-						uint64s = append(uint64s, cval)
-					default:
-						// default just store as string. Keys should only ever be strings or uint64, however, but assume default to string
-						strings = append(strings, use.(string))
-					}
-				}
-			case uint64:
-				if inpvar == ckey {
-					switch cval := use.(type) {
-					case float64:
-						uint64s = append(uint64s, uint64(cval))
-					case uint64:
-						uint64s = append(uint64s, cval)
-					default:
-						// default just store as string. Keys should only ever be strings or uint64, however, but assume default to string
-						strings = append(strings, use.(string))
-					}
-				}
-			default:
-				// Nothing - expect only string/uint64 for value types
-			}
-		}
-	case string:
-		for _, v := range variables {
-			var find any
-			var use any
-			if gettype == "keys" {
-				find = v.Key
-				use = v.Value
-			} else {
-				find = v.Value
-				use = v.Key
-			}
-			switch ckey := find.(type) {
-			case string:
-				if inpvar == ckey {
-					switch cval := use.(type) {
-					case float64:
-						uint64s = append(uint64s, uint64(cval))
-					case uint64:
-						uint64s = append(uint64s, cval)
-					default:
-						// default just store as string. Values should only ever be strings or uint64, however, but assume default to string
-						strings = append(strings, use.(string))
-					}
-				}
-			default:
-				// Nothing - expect only string/uint64 for value types
-			}
-		}
-	default:
-		// Nothing - expect only string/uint64 for value types
+// Resolves the height used by the key / value lookups
+func (ss *SqlStore) lookupHeight(scid string, height int64, rmax bool) int64 {
+	if rmax {
+		return maxHeight
 	}
-	return strings, uint64s
-}
-
-func getTypedVariables(heights []int64, results map[int64][]*structs.SCIDVariable) (hVars []*structs.SCIDVariable) {
-	var vs2k = make(map[interface{}]interface{})
-	for _, v := range heights {
-		for _, vs := range results[v] {
-			switch ckey := vs.Key.(type) {
-			case float64:
-				switch cval := vs.Value.(type) {
-				case float64:
-					vs2k[uint64(ckey)] = uint64(cval)
-				case uint64:
-					vs2k[uint64(ckey)] = cval
-				case string:
-					vs2k[uint64(ckey)] = cval
-				default:
-					if cval != nil {
-						fmt.Printf("[GetAllSCIDVariableDetails] Value '%v' does not match string, uint64 or float64.", cval)
-					} else {
-						vs2k[uint64(ckey)] = cval
-					}
-				}
-			case uint64:
-				switch cval := vs.Value.(type) {
-				case float64:
-					vs2k[ckey] = uint64(cval)
-				case uint64:
-					vs2k[ckey] = cval
-				case string:
-					vs2k[ckey] = cval
-				default:
-					if cval != nil {
-						fmt.Printf("[GetAllSCIDVariableDetails] Value '%v' does not match string, uint64 or float64.", cval)
-					} else {
-						vs2k[ckey] = cval
-					}
-				}
-			case string:
-				switch cval := vs.Value.(type) {
-				case float64:
-					vs2k[ckey] = uint64(cval)
-				case uint64:
-					vs2k[ckey] = cval
-				case string:
-					vs2k[ckey] = cval
-				default:
-					if cval != nil {
-						fmt.Printf("[GetAllSCIDVariableDetails] Value '%v' does not match string, uint64 or float64.", cval)
-					} else {
-						vs2k[ckey] = cval
-					}
-				}
-			default:
-				if ckey != nil {
-					fmt.Printf("[GetAllSCIDVariableDetails] Key '%v' does not match string, uint64 or float64.", ckey)
-				}
-			}
-		}
-	}
-	for k, v := range vs2k {
-		// If value is nil, no reason to add.
-		if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) || k == nil || (reflect.ValueOf(k).Kind() == reflect.Ptr && reflect.ValueOf(k).IsNil()) {
-			//logger.Debugf("[GetSCIDVariableDetailsAtTopoheight] Value '%v' or Key '%v' is nil. Continuing.", fmt.Sprintf("%v", v), fmt.Sprintf("%v", k))
-			continue
-		}
-		co := &structs.SCIDVariable{}
-
-		switch ckey := k.(type) {
-		case float64:
-			switch cval := v.(type) {
-			case float64:
-				co.Key = uint64(ckey)
-				co.Value = uint64(cval)
-			case uint64:
-				co.Key = uint64(ckey)
-				co.Value = cval
-			case string:
-				co.Key = uint64(ckey)
-				co.Value = cval
-			default:
-				fmt.Printf("[GetSCIDVariableDetailsAtTopoheight] Value '%v' or Key '%v' does not match string, uint64 or float64.", fmt.Sprintf("%v", cval), fmt.Sprintf("%v", uint64(ckey)))
-				continue
-			}
-		case uint64:
-			switch cval := v.(type) {
-			case float64:
-				co.Key = ckey
-				co.Value = uint64(cval)
-			case uint64:
-				co.Key = ckey
-				co.Value = cval
-			case string:
-				co.Key = ckey
-				co.Value = cval
-			default:
-				fmt.Printf("[GetSCIDVariableDetailsAtTopoheight] Value '%v' or Key '%v' does not match string, uint64 or float64.", fmt.Sprintf("%v", cval), fmt.Sprintf("%v", ckey))
-				continue
-			}
-		case string:
-			switch cval := v.(type) {
-			case float64:
-				co.Key = ckey
-				co.Value = uint64(cval)
-			case uint64:
-				co.Key = ckey
-				co.Value = cval
-			case string:
-				co.Key = ckey
-				co.Value = cval
-			default:
-				fmt.Printf("[GetSCIDVariableDetailsAtTopoheight] Value '%v' or Key '%v' does not match string, uint64 or float64.", fmt.Sprintf("%v", cval), fmt.Sprintf("%v", ckey))
-				continue
-			}
-		}
-
-		hVars = append(hVars, co)
+	if height > 0 {
+		return height
 	}
-	return hVars
-}
-
-/*
-// Stores any SCIDs that were attempted to be deployed but not correct - log scid/fees burnt attempting it.
-func (ss *SqlStore) StoreInvalidSCIDDeploys(scid string, fee uint64) (changes bool, err error) {
-	var currSCIDInteractionHeight []byte
-
-	currInvalidSCIDs := make(map[string]uint64)
-	var newInvalidSCIDs []byte
-
-	bName := "invalidscids"
-	key := "invalid"
-
-	err = ss.DB.View(func(tx *bolt.Tx) (err error) {
-		b := tx.Bucket([]byte(bName))
-		if b != nil {
-			currSCIDInteractionHeight = b.Get([]byte(key))
-		}
-		return
-	})
-
-	err = ss.DB.Update(func(tx *bolt.Tx) (err error) {
-		b, err := tx.CreateBucketIfNotExists([]byte(bName))
-		if err != nil {
-			return fmt.Errorf("bucket: %s", err)
-		}
-
-		if currSCIDInteractionHeight == nil {
-			currInvalidSCIDs[scid] = fee
-		} else {
-			// Retrieve value and convert to SCIDInteractionHeight, so that you can manipulate and update db
-			_ = json.Unmarshal(currSCIDInteractionHeight, &currInvalidSCIDs)
-
-			currInvalidSCIDs[scid] = fee
-		}
-		newInvalidSCIDs, err = json.Marshal(currInvalidSCIDs)
-		if err != nil {
-			return fmt.Errorf("[bbs-StoreInvalidSCIDDeploys] could not marshal interactionHeight info: %v", err)
-		}
-
-		err = b.Put([]byte(key), newInvalidSCIDs)
-		changes = true
-		return
-	})
-
-	return
+	return ss.GetSCIDVariableHeight(scid, false)
 }
 
-// Gets any SCIDs that were attempted to be deployed but not correct and their fees
-func (ss *SqlStore) GetInvalidSCIDDeploys() map[string]uint64 {
-	invalidSCIDs := make(map[string]uint64)
-
-	bName := "invalidscids"
-
-	ss.DB.View(func(tx *bolt.Tx) (err error) {
-		b := tx.Bucket([]byte(bName))
-		if b != nil {
-			key := "invalid"
-			v := b.Get([]byte(key))
-
-			if v != nil {
-				_ = json.Unmarshal(v, &invalidSCIDs)
-			}
-		}
+// Gets SC values by key at given topoheight who's key equates to a given interface{} (string/uint64)
+func (ss *SqlStore) GetSCIDValuesByKey(scid string, key interface{}, height int64, rmax bool) (valuesstring []string, valuesuint64 []uint64) {
+	k, ktype, ok := encodeVar(key)
+	if !ok {
 		return
-	})
-
-	return invalidSCIDs
-}
-
-// Stores counts of miniblock finders by address
-func (ss *SqlStore) StoreMiniblockCountByAddress(addr string) (changes bool, err error) {
-	currCount := ss.GetMiniblockCountByAddress(addr)
-
-	// Add 1 to currCount
-	currCount++
-
-	confBytes, err := json.Marshal(currCount)
-	if err != nil {
-		return changes, fmt.Errorf("[StoreMiniblockCountByAddress] could not marshal getinfo info: %v", err)
 	}
-
-	bName := "blockcount"
-
-	key := addr
-
-	err = ss.DB.Update(func(tx *bolt.Tx) (err error) {
-		b, err := tx.CreateBucketIfNotExists([]byte(bName))
-		if err != nil {
-			return fmt.Errorf("bucket: %s", err)
-		}
-
-		err = b.Put([]byte(key), confBytes)
-		changes = true
-		return
-	})
-
-	return
-}
-
-// Gets counts of miniblock finders by address
-func (ss *SqlStore) GetMiniblockCountByAddress(addr string) (miniblocks int64) {
-	bName := "blockcount"
-
-	ss.DB.View(func(tx *bolt.Tx) (err error) {
-		b := tx.Bucket([]byte(bName))
-		if b != nil {
-			key := addr
-			v := b.Get([]byte(key))
-
-			if v != nil {
-				_ = json.Unmarshal(v, &miniblocks)
-			}
-		}
+	at := ss.lookupHeight(scid, height, rmax)
+	var (
+		value   string
+		vtype   int
+		deleted int
+	)
+	err := ss.DB.QueryRow(
+		`SELECT value, vtype, deleted
+		FROM scvars
+		WHERE scid = ? AND key = ? AND ktype = ? AND height <= ?
+		ORDER BY height DESC, sv_id DESC LIMIT 1;`,
+		scid, k, ktype, at).Scan(&value, &vtype, &deleted)
+	if err != nil || deleted != 0 {
 		return
-	})
-
+	}
+	switch v := decodeVar(value, vtype).(type) {
+	case uint64:
+		valuesuint64 = append(valuesuint64, v)
+	case string:
+		valuesstring = append(valuesstring, v)
+	}
 	return
 }
 
-// Stores the integrator addrs who submit blocks
-func (ss *SqlStore) StoreIntegrators(integrator string) (changes bool, err error) {
-	bName := "integrators"
-	key := "integrators"
-
-	var currIntegrators []byte
-	newIntegratorsStag := make(map[string]int64)
-	var newIntegrators []byte
-
-	err = ss.DB.View(func(tx *bolt.Tx) (err error) {
-		b := tx.Bucket([]byte(bName))
-		if b != nil {
-			currIntegrators = b.Get([]byte(key))
-		}
+// Gets SC variable keys at given topoheight who's value equates to a given interface{} (string/uint64)
+func (ss *SqlStore) GetSCIDKeysByValue(scid string, val interface{}, height int64, rmax bool) (keysstring []string, keysuint64 []uint64) {
+	v, vtype, ok := encodeVar(val)
+	if !ok {
 		return
-	})
-
-	err = ss.DB.Update(func(tx *bolt.Tx) (err error) {
-		b, err := tx.CreateBucketIfNotExists([]byte(bName))
-		if err != nil {
-			return fmt.Errorf("bucket: %s", err)
-		}
-
-		if currIntegrators == nil {
-			newIntegratorsStag[integrator]++
-		} else {
-			// Retrieve value and convert to SCIDInteractionHeight, so that you can manipulate and update db
-			_ = json.Unmarshal(currIntegrators, &newIntegratorsStag)
-
-			newIntegratorsStag[integrator]++
-		}
-
-		newIntegrators, err = json.Marshal(newIntegratorsStag)
-		if err != nil {
-			return fmt.Errorf("[bbs-StoreInvalidSCIDDeploys] could not marshal integrators info: %v", err)
-		}
-
-		err = b.Put([]byte(key), newIntegrators)
-		changes = true
+	}
+	at := ss.lookupHeight(scid, height, rmax)
+	rows, err := ss.DB.Query(
+		`SELECT key, ktype FROM (
+			SELECT key, ktype, value, vtype, deleted,
+				ROW_NUMBER() OVER (PARTITION BY key, ktype ORDER BY height DESC, sv_id DESC) AS rn
+			FROM scvars
+			WHERE scid = ? AND height <= ?
+		) WHERE rn = 1 AND deleted = 0 AND value = ? AND vtype = ?;`,
+		scid, at, v, vtype)
+	if err != nil {
+		fmt.Println(err)
 		return
-	})
-
-	return
-}
-
-// Gets integrators and their counts
-func (ss *SqlStore) GetIntegrators() (integrators map[string]int64) {
-	bName := "integrators"
-	key := "integrators"
-
-	ss.DB.View(func(tx *bolt.Tx) (err error) {
-		b := tx.Bucket([]byte(bName))
-		if b != nil {
-			v := b.Get([]byte(key))
-
-			if v != nil {
-				_ = json.Unmarshal(v, &integrators)
-			}
+	}
+	defer rows.Close()
+	var (
+		key   string
+		ktype int
+	)
+	for rows.Next() {
+		rows.Scan(&key, &ktype)
+		switch k := decodeVar(key, ktype).(type) {
+		case uint64:
+			keysuint64 = append(keysuint64, k)
+		case string:
+			keysstring = append(keysstring, k)
 		}
-		return
-	})
-
+	}
 	return
 }
-*/
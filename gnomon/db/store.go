@@ -0,0 +1,94 @@
+package sql
+
+import (
+	"gnomon/structs"
+)
+
+// Storage backends used by the indexer and the api
+type Store interface {
+	// Settings and indexing progress
+	SaveSetting(name, value string)
+	LoadSetting(name string) (value string, err error)
+	SaveInitialHeight(startat int64)
+	SaveInitialSessionStart(startat int64)
+	LoadState(name string) (value int, err error)
+	StoreSessionStart(start int64)
+	StoreLastIndexHeight(last_indexedheight int64) (changes bool, err error)
+	GetLastIndexHeight() (topoheight int64, err error)
+	TrimHeight(start int64, end int64) int64
+
+	// Indexing
+	StoreOwner(scid string, owner string, height int, scname string, scdescr string, scimgurl string, class string, tags string) (changes bool, err error)
+	StoreSCIDInvoke(scidstoadd structs.SCIDToIndexStage, height int64) (changes bool, err error)
+	StoreSCIDInteractionHeight(scidstoadd structs.SCIDToIndexStage, height int64) (changes bool, err error)
+	StoreSCIDVariableDetails(scid string, txid string, variables []*structs.SCIDVariable, topoheight int64) (changes bool, err error)
+	StoreCodeVersion(scid string, txid string, height int64, code string) (changes bool, err error)
+	StoreFingerprint(scid string, height int64, codehash string, simhash string, family string) error
+	StoreSwap(order structs.SwapOrder) error
+	UpdateSCMeta(scid, class, tags string)
+	StoreName(name string, address string, height int64, txid string) (changes bool, err error)
+	TransferName(name string, from string, to string, height int64) (changes bool, err error)
+	GetSCOwnerAndClass(scid string) (owner string, class string)
+	GetFamilyByCodeHash(codehash string) (family string)
+	GetFamilySimHashes() (families map[string]string)
+	StoreInvalidSCIDDeploy(deploy structs.InvalidDeploy) error
+	StoreMiners(height int64, miners []string, integrator string) (changes bool, err error)
+
+	// Spam control
+	CountSignerInvokes(scid string, signer string, from int64, to int64) (count int)
+	GetInvokesOverLimit(scid string, max int) (txs []structs.QuarantinedTx)
+	RemoveInvokes(txs []structs.QuarantinedTx, quarantine bool) error
+	StoreQuarantined(tx structs.QuarantinedTx) error
+	GetQuarantined(scid string) (txs []structs.QuarantinedTx)
+	GetQuarantinedTx(txid string) (tx structs.QuarantinedTx, err error)
+	DeleteQuarantined(txid string) error
+
+	// Queries
+	GetAllOwnersAndSCIDs() map[string]string
+	GetSCMeta(scid string) (meta structs.SCMeta, err error)
+	GetSCMetaAfter(after string, limit int) (results []structs.SCMeta)
+	CountSCs() (count int)
+	GetUnfingerprintedSCIDs(limit int) (results []structs.SCMeta)
+	GetUndecodedSwaps() []string
+	GetSCIDsByClass(class_list []string) (results []string)
+	GetSCIDsByClassAbove(class_list []string, height int64) (results []string)
+	GetSCIDsByTags(tags_list []string) (results []string)
+	GetSCsByTags(tags_list []string) (results []map[string]any)
+	GetClassCounts() (counts []structs.MetaCount)
+	GetTagCounts() (counts []structs.MetaCount)
+	GetInitialSCIDCode(scid string) (sc_code string, err error)
+	GetSC(scid string) (sc_code string, hVars []*structs.SCIDVariable)
+	GetSCCodeHistory(scid string) (versions []structs.CodeVersion)
+	GetSCCodeAtTopoheight(scid string, topoheight int64) (sc_code string, err error)
+	GetSCIDVariableDetailsAtTopoheight(scid string, topoheight int64) (hVars []*structs.SCIDVariable)
+	GetAllSCIDVariableDetails(scid string) (hVars []*structs.SCIDVariable)
+	GetSCIDInteractionHeight(scid string) (scidinteractions []int64)
+	GetSCIDVariableHeight(scid string, rmax bool) (scidinteractions int64)
+	GetSCIDsEverOwnedBy(owner string) []string
+	GetSCIDsEverInCollection(collection string) []string
+	GetVariableHistory(scid string, key string) (changes []structs.VariableChange)
+	GetInvoke(txid string) (signer string, entrypoint string)
+	GetSCIDValuesByKey(scid string, key interface{}, height int64, rmax bool) (valuesstring []string, valuesuint64 []uint64)
+	GetSCIDKeysByValue(scid string, val interface{}, height int64, rmax bool) (keysstring []string, keysuint64 []uint64)
+	GetSCIDVariableTimeline(scid string, key any, from int64, to int64, step int64) (points []structs.VariablePoint)
+	GetSCIDVariableChanges(scid string, key any, from int64, to int64) (changes []structs.VariablePoint)
+	GetFingerprint(scid string) (fp structs.Fingerprint, err error)
+	GetSCIDsWithSameCode(scid string, similar bool) (results []string)
+	GetSCIDsByFamily(family string) (results []string)
+	GetCodeFamilies() (results []structs.CodeFamily)
+	GetSwap(scid string) (order structs.SwapOrder, err error)
+	GetSwaps(status string, asset string) (orders []structs.SwapOrder)
+	GetNameAddress(name string) (address string, err error)
+	GetAddressNames(address string) (names []structs.Name)
+	GetActivityByAddress(address string, limit int, offset int) (activity []structs.Activity, total int)
+	SearchText(query string, limit int, offset int) (results []structs.SearchResult, total int)
+	GetInvalidSCIDDeploys() (deploys []structs.InvalidDeploy)
+	GetMiniblockCountByAddress(address string) (miniblocks int64)
+	GetIntegrators() (integrators map[string]int64)
+}
+
+var (
+	_ Store = (*SqlStore)(nil)
+	_ Store = (*PgStore)(nil)
+	_ Store = (*MemStore)(nil)
+)
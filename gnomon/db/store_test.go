@@ -0,0 +1,120 @@
+package sql
+
+import (
+	"slices"
+	"testing"
+
+	"gnomon/structs"
+
+	"github.com/deroproject/derohe/rpc"
+)
+
+// The memory store answers the class queries like the sqlite one
+func TestSCIDsByClassAbove(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.StoreOwner("sc3", "owner", 300, "", "", "", "token", "")
+			store.StoreOwner("sc1", "owner", 100, "", "", "", "token", "")
+			store.StoreOwner("sc2", "owner", 200, "", "", "", "nft", "")
+			store.StoreOwner("sc4", "owner", 300, "", "", "", "token", "")
+
+			if scids := store.GetSCIDsByClassAbove([]string{"token"}, 0); !slices.Equal(scids, []string{"sc1", "sc3", "sc4"}) {
+				t.Fatalf("tokens above 0 = %v", scids)
+			}
+			if scids := store.GetSCIDsByClassAbove([]string{"token", "nft"}, 100); !slices.Equal(scids, []string{"sc2", "sc3", "sc4"}) {
+				t.Fatalf("tokens and nfts above 100 = %v", scids)
+			}
+			if scids := store.GetSCIDsByClassAbove([]string{"token"}, 300); len(scids) != 0 {
+				t.Fatalf("tokens above 300 = %v", scids)
+			}
+			if owner, class := store.GetSCOwnerAndClass("sc2"); owner != "owner" || class != "nft" {
+				t.Fatalf("sc2 owner %q class %q", owner, class)
+			}
+		})
+	}
+}
+
+// Transfers indexed before the registration, or before each other, still give the owner
+func TestNameTransfersOutOfOrder(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.TransferName("gnomon", "bob", "carol", 300)
+			store.TransferName("gnomon", "alice", "bob", 200)
+			store.TransferName("gnomon", "mallory", "eve", 250)
+			if _, err := store.GetNameAddress("gnomon"); err == nil {
+				t.Fatal("unregistered name has an address")
+			}
+			if changes, err := store.StoreName("gnomon", "alice", 100, "tx"); err != nil || !changes {
+				t.Fatalf("registration changes %v err %v", changes, err)
+			}
+			if address, _ := store.GetNameAddress("gnomon"); address != "carol" {
+				t.Fatalf("owner %q, want carol", address)
+			}
+			if names := store.GetAddressNames("carol"); len(names) != 1 || names[0].Height != 100 {
+				t.Fatalf("carol's names %v", names)
+			}
+			if changes, _ := store.TransferName("gnomon", "alice", "bob", 200); changes {
+				t.Fatal("a transfer indexed twice changed the owner")
+			}
+		})
+	}
+}
+
+// An order written late for an older height doesn't replace the newer one
+func TestSwapKeepsNewestOrder(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.StoreSwap(structs.SwapOrder{SCID: "sc1", Height: 200, Maker: "alice", Status: "filled"})
+			store.StoreSwap(structs.SwapOrder{SCID: "sc1", Height: 100, Maker: "alice", Status: "open"})
+			if order, err := store.GetSwap("sc1"); err != nil || order.Height != 200 || order.Status != "filled" {
+				t.Fatalf("order %+v err %v", order, err)
+			}
+			store.StoreSwap(structs.SwapOrder{SCID: "sc1", Height: 300, Maker: "alice", Status: "cancelled"})
+			if order, _ := store.GetSwap("sc1"); order.Height != 300 || order.Status != "cancelled" {
+				t.Fatalf("order %+v", order)
+			}
+		})
+	}
+}
+
+// The queries of the background passes and the asset views answer alike on every store
+func TestMaintenanceQueries(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.StoreOwner("sc2", "alice", 200, "", "", "", "swaps", "")
+			store.StoreOwner("sc1", "alice", 100, "", "", "", "nfa", "")
+			store.StoreSCIDVariableDetails("sc1", "tx1", vars("owner", "alice"), 100)
+			store.StoreSCIDVariableDetails("sc1", "tx2", vars("owner", "bob"), 150)
+			store.StoreSCIDInvoke(structs.SCIDToIndexStage{TXHash: "tx2", Params: rpc.GetSC_Params{SCID: "sc1"}, Fsi: &structs.FastSyncImport{Signer: "alice"}, Entrypoint: "Transfer"}, 150)
+
+			if count := store.CountSCs(); count != 2 {
+				t.Fatalf("count %d", count)
+			}
+			if metas := store.GetSCMetaAfter("sc1", 10); len(metas) != 1 || metas[0].SCID != "sc2" {
+				t.Fatalf("meta after sc1 %v", metas)
+			}
+			store.UpdateSCMeta("sc1", "g45", "T1")
+			if meta, err := store.GetSCMeta("sc1"); err != nil || meta.Class != "g45" || meta.Tags != "T1" || meta.Height != 100 {
+				t.Fatalf("meta %+v err %v", meta, err)
+			}
+			if unfingerprinted := store.GetUnfingerprintedSCIDs(1); len(unfingerprinted) != 1 || unfingerprinted[0].SCID != "sc1" {
+				t.Fatalf("unfingerprinted %v", unfingerprinted)
+			}
+			if swaps := store.GetUndecodedSwaps(); !slices.Equal(swaps, []string{"sc2"}) {
+				t.Fatalf("undecoded swaps %v", swaps)
+			}
+			if first, last := store.GetSCIDVariableHeight("sc1", false), store.GetSCIDVariableHeight("sc1", true); first != 100 || last != 150 {
+				t.Fatalf("variable heights %d %d", first, last)
+			}
+			if scids := store.GetSCIDsEverOwnedBy("alice"); !slices.Equal(scids, []string{"sc1"}) {
+				t.Fatalf("ever owned by alice %v", scids)
+			}
+			if history := store.GetVariableHistory("sc1", "owner"); len(history) != 2 || history[1].Value != "bob" || history[1].TXID != "tx2" {
+				t.Fatalf("owner history %v", history)
+			}
+			if signer, entrypoint := store.GetInvoke("tx2"); signer != "alice" || entrypoint != "Transfer" {
+				t.Fatalf("invoke %q %q", signer, entrypoint)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"gnomon/structs"
+)
+
+// Swap orders decoded from StartSwap contracts
+
+// Expiry values above this are unix times
+const UnixExpiry = 1000000000
+
+const swapsSchema = "(" +
+	"scid TEXT PRIMARY KEY, " +
+	"height INTEGER, " +
+	"maker TEXT, " +
+	"offer_asset TEXT, " +
+	"offer_amount INTEGER, " +
+	"request_asset TEXT, " +
+	"request_amount INTEGER, " +
+	"expiry INTEGER, " +
+	"status TEXT)"
+
+var swapsIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS swaps_status_index ON swaps(status);",
+	"CREATE INDEX IF NOT EXISTS swaps_offer_index ON swaps(offer_asset);",
+	"CREATE INDEX IF NOT EXISTS swaps_request_index ON swaps(request_asset);",
+}
+
+// Keeps the order of the highest height, a late write of an older state is ignored
+func (ss *SqlStore) StoreSwap(order structs.SwapOrder) error {
+	return ss.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			`INSERT INTO swaps (scid,height,maker,offer_asset,offer_amount,request_asset,request_amount,expiry,status) VALUES (?,?,?,?,?,?,?,?,?)
+			ON CONFLICT (scid) DO UPDATE SET height = excluded.height, maker = excluded.maker,
+				offer_asset = excluded.offer_asset, offer_amount = excluded.offer_amount,
+				request_asset = excluded.request_asset, request_amount = excluded.request_amount,
+				expiry = excluded.expiry, status = excluded.status
+			WHERE excluded.height >= swaps.height;`,
+			order.SCID, order.Height, order.Maker, order.OfferAsset, int64(order.OfferAmount), order.RequestAsset, int64(order.RequestAmount), order.Expiry, order.Status)
+		if err != nil {
+			return err
+		}
+		return ss.changed(tx, order.Height)
+	})
+}
+
+func (ss *SqlStore) GetSwap(scid string) (order structs.SwapOrder, err error) {
+	orders := ss.querySwaps("SELECT * FROM swaps WHERE scid = ?;", scid)
+	if len(orders) == 0 {
+		return order, fmt.Errorf("no swap order for %s", scid)
+	}
+	return orders[0], nil
+}
+
+// Orders newest first, status and asset (offered or requested) are optional
+func (ss *SqlStore) GetSwaps(status string, asset string) (orders []structs.SwapOrder) {
+	for _, order := range ss.querySwaps(
+		`SELECT * FROM swaps
+		WHERE (? = '' OR offer_asset = ? OR request_asset = ?)
+		ORDER BY height DESC;`, asset, asset, asset) {
+		if status == "" || order.Status == status {
+			orders = append(orders, order)
+		}
+	}
+	return
+}
+
+// SCIDs of the swaps class without an order row
+func (ss *SqlStore) GetUndecodedSwaps() []string {
+	return ss.queryStrings(undecodedSwapsQuery)
+}
+
+const undecodedSwapsQuery = `SELECT scid FROM sc_classes
+	WHERE class = 'swaps'
+	AND scid NOT IN (SELECT scid FROM swaps);`
+
+func (ss *SqlStore) querySwaps(query string, args ...any) (orders []structs.SwapOrder) {
+	height, _ := ss.GetLastIndexHeight()
+	now := time.Now().Unix()
+	rows, err := ss.DB.Query(query, args...)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			order          structs.SwapOrder
+			offer, request int64
+		)
+		rows.Scan(&order.SCID, &order.Height, &order.Maker, &order.OfferAsset, &offer, &order.RequestAsset, &request, &order.Expiry, &order.Status)
+		order.OfferAmount, order.RequestAmount = uint64(offer), uint64(request)
+		orders = append(orders, expireSwap(order, height, now))
+	}
+	return
+}
+
+// Marks an open order past its expiry as expired
+func expireSwap(order structs.SwapOrder, height int64, now int64) structs.SwapOrder {
+	if order.Status == "open" && order.Expiry != 0 {
+		if (order.Expiry > UnixExpiry && order.Expiry < now) || (order.Expiry <= UnixExpiry && order.Expiry < height) {
+			order.Status = "expired"
+		}
+	}
+	return order
+}
@@ -0,0 +1,93 @@
+package sql
+
+import (
+	"fmt"
+
+	"gnomon/structs"
+)
+
+// Timelines of a single variable sampled every step heights
+
+// Most points a timeline returns, the step is raised to fit
+const MaxTimelinePoints = 10000
+
+// Change rows of a key matching the height condition, in the order they were written
+func keyPoints(q queryer, scid string, k string, ktype int, condition string, args ...any) (points []structs.VariablePoint) {
+	rows, err := q.Query(
+		`SELECT height, txid, value, vtype, deleted FROM scvars
+		WHERE scid = ? AND key = ? AND ktype = ? AND `+condition+`;`,
+		append([]any{scid, k, ktype}, args...)...)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			point   structs.VariablePoint
+			value   string
+			vtype   int
+			deleted int
+		)
+		rows.Scan(&point.Height, &point.TXID, &value, &vtype, &deleted)
+		if point.Deleted = deleted != 0; !point.Deleted {
+			point.Value = decodeVar(value, vtype)
+		}
+		points = append(points, point)
+	}
+	return
+}
+
+// Every change of a key from..to
+func keyChanges(q queryer, scid string, key any, from int64, to int64) (changes []structs.VariablePoint) {
+	k, ktype, ok := encodeVar(key)
+	if !ok || from < 0 || to < from {
+		return
+	}
+	return keyPoints(q, scid, k, ktype, "height >= ? AND height <= ? ORDER BY height ASC, sv_id ASC", from, to)
+}
+
+// The value of a key every step heights from..to
+func keyTimeline(q queryer, scid string, key any, from int64, to int64, step int64) (points []structs.VariablePoint) {
+	k, ktype, ok := encodeVar(key)
+	if !ok || from < 0 || to < from {
+		return
+	}
+	changes := keyPoints(q, scid, k, ktype, "height < ? ORDER BY height DESC, sv_id DESC LIMIT 1", from)
+	changes = append(changes, keyPoints(q, scid, k, ktype, "height >= ? AND height <= ? ORDER BY height ASC, sv_id ASC", from, to)...)
+	return sampleChanges(changes, from, to, step)
+}
+
+// Samples changes in height order every step heights from..to, each point holds the last change at or below it
+func sampleChanges(changes []structs.VariablePoint, from int64, to int64, step int64) (points []structs.VariablePoint) {
+	if from < 0 || to < from {
+		return
+	}
+	step = max(step, 1, (to-from)/MaxTimelinePoints+1)
+	var current structs.VariablePoint
+	i := 0
+	for height := from; height <= to; height += step {
+		for i < len(changes) && changes[i].Height <= height {
+			current = changes[i]
+			i++
+		}
+		point := current
+		point.Height = height
+		points = append(points, point)
+		// The next height would pass to, or wrap around near the int64 limit
+		if to-height < step {
+			break
+		}
+	}
+	return
+}
+
+// The value of key every step heights from from to to, a point's txid is the change in effect
+func (ss *SqlStore) GetSCIDVariableTimeline(scid string, key any, from int64, to int64, step int64) (points []structs.VariablePoint) {
+	return keyTimeline(ss.DB, scid, key, from, to, step)
+}
+
+// Every change of key from from to to, deletions included
+func (ss *SqlStore) GetSCIDVariableChanges(scid string, key any, from int64, to int64) (changes []structs.VariablePoint) {
+	return keyChanges(ss.DB, scid, key, from, to)
+}
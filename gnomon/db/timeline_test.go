@@ -0,0 +1,45 @@
+package sql
+
+import (
+	"math"
+	"testing"
+
+	"gnomon/structs"
+)
+
+// Sampling up to the int64 limit must end within the point cap instead of wrapping around
+func TestSampleChangesBounded(t *testing.T) {
+	changes := []structs.VariablePoint{{Height: 10, Value: uint64(1)}}
+	points := sampleChanges(changes, 0, math.MaxInt64, 1)
+	if len(points) == 0 || len(points) > MaxTimelinePoints {
+		t.Fatalf("%d points", len(points))
+	}
+	for i := 1; i < len(points); i++ {
+		if points[i].Height <= points[i-1].Height {
+			t.Fatalf("height %d after %d", points[i].Height, points[i-1].Height)
+		}
+	}
+	if points := sampleChanges(changes, -1, 100, 1); len(points) != 0 {
+		t.Fatalf("negative from gave %d points", len(points))
+	}
+}
+
+// uint64 keys are looked up by their type
+func TestTimelineUint64Key(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.StoreSCIDVariableDetails("sc", "tx1", vars(uint64(7), "a"), 100)
+			store.StoreSCIDVariableDetails("sc", "tx2", vars(uint64(7), "b"), 200)
+			if changes := store.GetSCIDVariableChanges("sc", uint64(7), 0, 300); len(changes) != 2 {
+				t.Fatalf("%d changes", len(changes))
+			}
+			if changes := store.GetSCIDVariableChanges("sc", "7", 0, 300); len(changes) != 0 {
+				t.Fatalf("string key matched %d changes", len(changes))
+			}
+			points := store.GetSCIDVariableTimeline("sc", uint64(7), 150, 250, 50)
+			if len(points) != 3 || points[0].Value != "a" || points[2].Value != "b" {
+				t.Fatalf("points %v", points)
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+package sql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gnomon/structs"
+)
+
+// Variables are stored as change sets with a checkpoint every CheckpointInterval sets
+
+// Number of change sets between full state checkpoints
+var CheckpointInterval = 100
+
+// Diffs are computed against the stored state, so only one writer at a time
+var varsMutex sync.Mutex
+
+// Key / value types
+const (
+	typeString = 0
+	typeUint64 = 1
+)
+
+const scvarsSchema = "(" +
+	"sv_id INTEGER PRIMARY KEY, " +
+	"scid TEXT, " +
+	"height INTEGER, " +
+	"txid TEXT, " +
+	"key TEXT, " +
+	"ktype INTEGER, " +
+	"value TEXT, " +
+	"vtype INTEGER, " +
+	"deleted INTEGER DEFAULT 0)"
+
+const checkpointsSchema = "(" +
+	"cp_id INTEGER PRIMARY KEY, " +
+	"scid TEXT, " +
+	"height INTEGER, " +
+	"txid TEXT, " +
+	"vars TEXT)"
+
+var varsIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS scvars_scid_index ON scvars(scid,key,height);",
+	"CREATE INDEX IF NOT EXISTS scvars_txid_index ON scvars(txid);",
+	"CREATE INDEX IF NOT EXISTS checkpoints_scid_index ON checkpoints(scid,height);",
+}
+
+// Satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+type varKey struct {
+	key   string
+	ktype int
+}
+
+type varValue struct {
+	value string
+	vtype int
+}
+
+// Checkpoint row format, values are kept as text so uint64s survive json
+type checkpointVar struct {
+	Key   string `json:"k"`
+	KType int    `json:"kt"`
+	Value string `json:"v"`
+	VType int    `json:"vt"`
+}
+
+// Returns the text form and type of a key or value
+func encodeVar(v any) (string, int, bool) {
+	switch cv := v.(type) {
+	case string:
+		return cv, typeString, true
+	case uint64:
+		return strconv.FormatUint(cv, 10), typeUint64, true
+	case float64:
+		return strconv.FormatUint(uint64(cv), 10), typeUint64, true
+	case int:
+		return strconv.Itoa(cv), typeUint64, true
+	case int64:
+		return strconv.FormatInt(cv, 10), typeUint64, true
+	}
+	return "", typeString, false
+}
+
+func decodeVar(text string, vtype int) any {
+	if vtype == typeUint64 {
+		u, err := strconv.ParseUint(text, 10, 64)
+		if err == nil {
+			return u
+		}
+	}
+	return text
+}
+
+func toState(variables []*structs.SCIDVariable) map[varKey]varValue {
+	state := make(map[varKey]varValue)
+	for _, v := range variables {
+		if v == nil {
+			continue
+		}
+		k, kt, kok := encodeVar(v.Key)
+		val, vt, vok := encodeVar(v.Value)
+		if !kok || !vok {
+			continue
+		}
+		state[varKey{k, kt}] = varValue{val, vt}
+	}
+	return state
+}
+
+func fromState(state map[varKey]varValue) (hVars []*structs.SCIDVariable) {
+	for k, v := range state {
+		hVars = append(hVars, &structs.SCIDVariable{
+			Key:   decodeVar(k.key, k.ktype),
+			Value: decodeVar(v.value, v.vtype),
+		})
+	}
+	return
+}
+
+// Rebuilds the state of a contract at the given height from the closest checkpoint and the following change sets
+func loadState(q queryer, scid string, height int64) (map[varKey]varValue, error) {
+	state := make(map[varKey]varValue)
+	cpheight := int64(-1)
+	var cpvars string
+	err := q.QueryRow("SELECT height, vars FROM checkpoints WHERE scid = ? AND height <= ? ORDER BY height DESC LIMIT 1;", scid, height).Scan(&cpheight, &cpvars)
+	if err == nil {
+		var saved []checkpointVar
+		if err := json.Unmarshal([]byte(cpvars), &saved); err != nil {
+			return state, fmt.Errorf("[loadState] bad checkpoint for %s: %v", scid, err)
+		}
+		for _, v := range saved {
+			state[varKey{v.Key, v.KType}] = varValue{v.Value, v.VType}
+		}
+	} else if err != sql.ErrNoRows {
+		return state, err
+	}
+
+	rows, err := q.Query(
+		`SELECT key, ktype, value, vtype, deleted
+		FROM scvars
+		WHERE scid = ? AND height > ? AND height <= ?
+		ORDER BY height ASC, sv_id ASC;`,
+		scid, cpheight, height)
+	if err != nil {
+		return state, err
+	}
+	defer rows.Close()
+	var (
+		key     string
+		ktype   int
+		value   string
+		vtype   int
+		deleted int
+	)
+	for rows.Next() {
+		rows.Scan(&key, &ktype, &value, &vtype, &deleted)
+		if deleted != 0 {
+			delete(state, varKey{key, ktype})
+		} else {
+			state[varKey{key, ktype}] = varValue{value, vtype}
+		}
+	}
+	return state, rows.Err()
+}
+
+// Inserts the keys that differ between two states, a key in txids keeps the txid it was stored with
+func insertDiff(q queryer, scid string, txid string, txids map[varKey]string, height int64, from, to map[varKey]varValue) (count int, err error) {
+	insert := "INSERT INTO scvars (scid,height,txid,key,ktype,value,vtype,deleted) VALUES (?,?,?,?,?,?,?,?);"
+	txidOf := func(k varKey) string {
+		if t, ok := txids[k]; ok {
+			return t
+		}
+		return txid
+	}
+	for k, v := range to {
+		if old, ok := from[k]; ok && old == v {
+			continue
+		}
+		if _, err = q.Exec(insert, scid, height, txidOf(k), k.key, k.ktype, v.value, v.vtype, 0); err != nil {
+			return
+		}
+		count++
+	}
+	for k := range from {
+		if _, ok := to[k]; ok {
+			continue
+		}
+		if _, err = q.Exec(insert, scid, height, txidOf(k), k.key, k.ktype, "", typeString, 1); err != nil {
+			return
+		}
+		count++
+	}
+	return
+}
+
+// The txid of every key in a change set, and of its first row for keys it didn't hold
+func changeTxids(q queryer, scid string, height int64) (txids map[varKey]string, first string, err error) {
+	rows, err := q.Query("SELECT key, ktype, txid FROM scvars WHERE scid = ? AND height = ? ORDER BY sv_id ASC;", scid, height)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	txids = map[varKey]string{}
+	for rows.Next() {
+		var (
+			k    varKey
+			txid string
+		)
+		rows.Scan(&k.key, &k.ktype, &txid)
+		if first == "" {
+			first = txid
+		}
+		txids[k] = txid
+	}
+	return txids, first, rows.Err()
+}
+
+func saveCheckpoint(q queryer, scid string, txid string, height int64, state map[varKey]varValue) error {
+	saved := make([]checkpointVar, 0, len(state))
+	for k, v := range state {
+		saved = append(saved, checkpointVar{Key: k.key, KType: k.ktype, Value: v.value, VType: v.vtype})
+	}
+	confBytes, err := json.Marshal(saved)
+	if err != nil {
+		return fmt.Errorf("[saveCheckpoint] could not marshal vars: %v", err)
+	}
+	_, err = q.Exec("INSERT INTO checkpoints (scid,height,txid,vars) VALUES (?,?,?,?);", scid, height, txid, string(confBytes))
+	return err
+}
+
+// Stores the change set between the previous state and the supplied full state, returns the keys whose string value changed
+func storeChanges(q queryer, scid string, txid string, height int64, current map[varKey]varValue) (changed []varKey, err error) {
+	// Blocks are processed concurrently so a later change set may already be stored,
+	// if so it has to be re-diffed against this state once it is in place.
+	next := int64(-1)
+	q.QueryRow("SELECT COALESCE(MIN(height),-1) FROM scvars WHERE scid = ? AND height > ?;", scid, height).Scan(&next)
+	var nextstate map[varKey]varValue
+	var nexttxids map[varKey]string
+	var nexttxid string
+	if next != -1 {
+		if nextstate, err = loadState(q, scid, next); err != nil {
+			return
+		}
+		if nexttxids, nexttxid, err = changeTxids(q, scid, next); err != nil {
+			return
+		}
+	}
+
+	prev, err := loadState(q, scid, height)
+	if err != nil {
+		return
+	}
+	if _, err = insertDiff(q, scid, txid, nil, height, prev, current); err != nil {
+		return
+	}
+	changed = textChanges(prev, current)
+
+	if next != -1 {
+		if _, err = q.Exec("DELETE FROM scvars WHERE scid = ? AND height = ?;", scid, next); err != nil {
+			return
+		}
+		if _, err = insertDiff(q, scid, nexttxid, nexttxids, next, current, nextstate); err != nil {
+			return
+		}
+		// Any later checkpoint was built without this change set
+		_, err = q.Exec("DELETE FROM checkpoints WHERE scid = ? AND height >= ?;", scid, height)
+		return
+	}
+
+	var since int
+	q.QueryRow(
+		`SELECT COUNT(DISTINCT height)
+		FROM scvars
+		WHERE scid = ? AND height > (SELECT COALESCE(MAX(height),-1) FROM checkpoints WHERE scid = ?);`,
+		scid, scid).Scan(&since)
+	if since >= CheckpointInterval {
+		err = saveCheckpoint(q, scid, txid, height, current)
+	}
+	return
+}
+
+// A change set kept after deleted ones, with the state it has to lead to
+type rebase struct {
+	scid   string
+	height int64
+	txid   string
+	txids  map[varKey]string
+	state  map[varKey]varValue
+}
+
+// Change sets only hold the difference from the state before them, so the first one
+// kept after the rows matching where has to become a full diff once they are deleted.
+// Reads the states those change sets lead to, call before the delete
+func rebasesWhere(q queryer, where string, args ...any) (rebases []rebase, err error) {
+	condition := strings.TrimPrefix(where, " WHERE ")
+	for _, scid := range queryStrings(q, "SELECT DISTINCT scid FROM scvars"+where+";", args...) {
+		rows, err := q.Query(
+			"SELECT height, MAX(CASE WHEN "+condition+" THEN 1 ELSE 0 END) FROM scvars WHERE scid = ? GROUP BY height ORDER BY height ASC;",
+			append(slices.Clone(args), scid)...)
+		if err != nil {
+			return nil, err
+		}
+		var (
+			heights []int64
+			gap     bool
+		)
+		for rows.Next() {
+			var (
+				height  int64
+				deleted int
+			)
+			rows.Scan(&height, &deleted)
+			if deleted != 0 {
+				gap = true
+			} else if gap {
+				heights = append(heights, height)
+				gap = false
+			}
+		}
+		rows.Close()
+		for _, height := range heights {
+			r := rebase{scid: scid, height: height}
+			if r.state, err = loadState(q, scid, height); err != nil {
+				return nil, err
+			}
+			if r.txids, r.txid, err = changeTxids(q, scid, height); err != nil {
+				return nil, err
+			}
+			rebases = append(rebases, r)
+		}
+	}
+	return
+}
+
+// Rewrites the kept change sets as diffs from the state left below them, in height order per contract
+func rebaseChanges(q queryer, rebases []rebase) error {
+	for _, r := range rebases {
+		prev, err := loadState(q, r.scid, r.height-1)
+		if err != nil {
+			return err
+		}
+		if _, err = q.Exec("DELETE FROM scvars WHERE scid = ? AND height = ?;", r.scid, r.height); err != nil {
+			return err
+		}
+		if _, err = insertDiff(q, r.scid, r.txid, r.txids, r.height, prev, r.state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// One-time conversion of the full snapshot variables table into change sets
+func migrateVariables(tx *sql.Tx) error {
+	var exists int
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name='variables');").Scan(&exists)
+	if exists == 0 {
+		return nil
+	}
+	fmt.Println("Migrating stored variables to change sets...")
+
+	var scids []string
+	rows, err := tx.Query(
+		`SELECT DISTINCT IFNULL(invokes.scid, variables.txid)
+		FROM variables
+		LEFT JOIN invokes ON invokes.txid = variables.txid;`)
+	if err != nil {
+		return err
+	}
+	var scid string
+	for rows.Next() {
+		rows.Scan(&scid)
+		scids = append(scids, scid)
+	}
+	rows.Close()
+
+	for i, scid := range scids {
+		if err := migrateSCIDVariables(tx, scid); err != nil {
+			return fmt.Errorf("variables for %s: %w", scid, err)
+		}
+		print("\rProgress: ", fmt.Sprintf("%.2f", float64(i+1)/float64(len(scids))*100.0), "%")
+	}
+	if _, err := tx.Exec("DROP TABLE variables;"); err != nil {
+		return err
+	}
+	fmt.Println("\nMigrated variables for", len(scids), "contracts")
+	return nil
+}
+
+func migrateSCIDVariables(tx *sql.Tx, scid string) error {
+	rows, err := tx.Query(
+		`SELECT height, txid, vars
+		FROM variables
+		WHERE txid = ? OR txid IN (
+			SELECT txid
+			FROM invokes
+			WHERE scid = ?
+		) ORDER BY height ASC, v_id ASC;`,
+		scid, scid)
+	if err != nil {
+		return err
+	}
+	type snapshot struct {
+		height int64
+		txid   string
+		vars   string
+	}
+	var snapshots []snapshot
+	for rows.Next() {
+		var s snapshot
+		rows.Scan(&s.height, &s.txid, &s.vars)
+		snapshots = append(snapshots, s)
+	}
+	rows.Close()
+
+	prev := map[varKey]varValue{}
+	since := 0
+	for _, s := range snapshots {
+		var variables []*structs.SCIDVariable
+		_ = json.Unmarshal([]byte(s.vars), &variables)
+		current := toState(variables)
+		count, err := insertDiff(tx, scid, s.txid, nil, s.height, prev, current)
+		if err != nil {
+			return err
+		}
+		if count != 0 {
+			since++
+		}
+		if since >= CheckpointInterval {
+			if err := saveCheckpoint(tx, scid, s.txid, s.height, current); err != nil {
+				return err
+			}
+			since = 0
+		}
+		prev = current
+	}
+	return nil
+}
+
+// Highest height used for rmax lookups
+const maxHeight = int64(math.MaxInt64)
@@ -0,0 +1,90 @@
+package sql
+
+import (
+	"slices"
+	"testing"
+
+	"gnomon/structs"
+)
+
+func testStores(t *testing.T) map[string]Store {
+	disk, err := NewDiskDB(t.TempDir(), "test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { disk.DB.Close() })
+	return map[string]Store{"sqlite": disk, "memory": NewMemStore()}
+}
+
+func vars(kv ...any) (variables []*structs.SCIDVariable) {
+	for i := 0; i+1 < len(kv); i += 2 {
+		variables = append(variables, &structs.SCIDVariable{Key: kv[i], Value: kv[i+1]})
+	}
+	return
+}
+
+// A bounded trim must leave the states above the range intact, and indexing the range again must not change them
+func TestTrimHeightKeepsLaterStates(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.StoreSCIDVariableDetails("sc", "tx1", vars("K", "set", "A", uint64(1)), 100)
+			store.StoreSCIDVariableDetails("sc", "tx2", vars("K", "set", "J", "x"), 200)
+			store.StoreSCIDVariableDetails("sc", "tx3", vars("K", "set", "J", "y"), 300)
+
+			check := func(step string) {
+				t.Helper()
+				if values, _ := store.GetSCIDValuesByKey("sc", "K", 200, false); !slices.Equal(values, []string{"set"}) {
+					t.Fatalf("%s: K at 200 = %v", step, values)
+				}
+				if values, _ := store.GetSCIDValuesByKey("sc", "J", 300, false); !slices.Equal(values, []string{"y"}) {
+					t.Fatalf("%s: J at 300 = %v", step, values)
+				}
+				if _, values := store.GetSCIDValuesByKey("sc", "A", 200, false); len(values) != 0 {
+					t.Fatalf("%s: A at 200 = %v, deleted at 200", step, values)
+				}
+			}
+
+			store.TrimHeight(60, 150)
+			check("trimmed")
+			store.StoreSCIDVariableDetails("sc", "tx1", vars("K", "set", "A", uint64(1)), 100)
+			check("indexed again")
+			if _, values := store.GetSCIDValuesByKey("sc", "A", 100, false); !slices.Equal(values, []uint64{1}) {
+				t.Fatalf("A at 100 = %v", values)
+			}
+		})
+	}
+}
+
+// The integrity check deletes around completed ranges, the rows kept after a hole must still hold their state
+func TestCheckIntegrityKeepsCompletedStates(t *testing.T) {
+	disk := testStores(t)["sqlite"].(*SqlStore)
+	disk.SaveInitialHeight(0)
+	disk.StoreSCIDVariableDetails("sc", "tx1", vars("K", "set"), 100)
+	disk.StoreSCIDVariableDetails("sc", "tx2", vars("K", "set", "J", "x"), 200)
+	disk.StoreLastIndexHeight(50)
+	disk.SaveSetting("completed", "[[150,250]]")
+	if _, _, err := disk.CheckIntegrity(); err != nil {
+		t.Fatal(err)
+	}
+	if values, _ := disk.GetSCIDValuesByKey("sc", "K", 200, false); !slices.Equal(values, []string{"set"}) {
+		t.Fatalf("K at 200 = %v", values)
+	}
+}
+
+// Re-diffing a later change set against an out of order one must keep the txid each key was stored with
+func TestRediffKeepsKeyTxids(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.StoreSCIDVariableDetails("sc", "tx2", vars("K", "b"), 200)
+			store.StoreSCIDVariableDetails("sc", "tx3", vars("K", "b", "J", "x"), 200)
+			store.StoreSCIDVariableDetails("sc", "tx1", vars("K", "a"), 100)
+
+			for _, c := range []struct{ key, txid string }{{"K", "tx2"}, {"J", "tx3"}} {
+				history := store.GetVariableHistory("sc", c.key)
+				if len(history) == 0 || history[len(history)-1].Height != 200 || history[len(history)-1].TXID != c.txid {
+					t.Fatalf("%s history = %+v, want %s at 200", c.key, history, c.txid)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package sql
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// All writes to a db file go through a single writer goroutine
+
+// Most writes committed in one transaction
+var WriteBatchSize = 256
+
+// Connection options of the disk dbs
+const diskOptions = "?_journal_mode=WAL&_busy_timeout=10000"
+
+// The memory db is one shared cache, where reading a table the writer has changed
+// fails with "database table is locked" until it commits, busy_timeout doesn't apply
+// to those locks. Its connections read uncommitted rows instead of taking read locks.
+const memoryDriver = "sqlite3_memory"
+
+func init() {
+	sql.Register(memoryDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			_, err := conn.Exec("PRAGMA read_uncommitted = 1;", nil)
+			return err
+		},
+	})
+}
+
+type writeJob struct {
+	fn   func(tx *sql.Tx) error
+	done chan error
+}
+
+type writer struct {
+	db   *sql.DB
+	jobs chan writeJob
+}
+
+// One writer per disk file, shared by every store opened on it
+var writers = map[string]*writer{}
+var writersMutex sync.Mutex
+
+func diskDSN(path string) string {
+	return path + diskOptions
+}
+
+// Returns the writer of the file at path, opening it on first use
+func diskWriter(path string) (*writer, error) {
+	writersMutex.Lock()
+	defer writersMutex.Unlock()
+	if w, ok := writers[path]; ok {
+		return w, nil
+	}
+	db, err := sql.Open("sqlite3", diskDSN(path)+"&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	w := newWriter(db)
+	writers[path] = w
+	return w, nil
+}
+
+func newWriter(db *sql.DB) *writer {
+	w := &writer{db: db, jobs: make(chan writeJob, WriteBatchSize)}
+	go w.run()
+	return w
+}
+
+func (w *writer) run() {
+	for job := range w.jobs {
+		batch := []writeJob{job}
+	collect:
+		for len(batch) < WriteBatchSize {
+			select {
+			case job := <-w.jobs:
+				batch = append(batch, job)
+			default:
+				break collect
+			}
+		}
+		w.commit(batch)
+	}
+}
+
+func (w *writer) commit(batch []writeJob) {
+	errs := make([]error, len(batch))
+	tx, err := w.db.Begin()
+	if err == nil {
+		for i, job := range batch {
+			if _, err = tx.Exec("SAVEPOINT job;"); err != nil {
+				break
+			}
+			if errs[i] = job.fn(tx); errs[i] != nil {
+				tx.Exec("ROLLBACK TO job;")
+			}
+			if _, err = tx.Exec("RELEASE job;"); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+	for i, job := range batch {
+		if err != nil {
+			errs[i] = err
+		}
+		job.done <- errs[i]
+	}
+}
+
+// Runs fn in a write transaction and waits for it to commit
+func (ss *SqlStore) write(fn func(tx *sql.Tx) error) error {
+	if ss.writer == nil {
+		// Stores not opened by NewDiskDB or NewSqlDB write directly
+		tx, err := ss.DB.Begin()
+		if err != nil {
+			return err
+		}
+		if err = fn(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}
+	done := make(chan error, 1)
+	ss.writer.jobs <- writeJob{fn, done}
+	return <-done
+}
@@ -0,0 +1,97 @@
+package sql
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+
+	"gnomon/structs"
+
+	"github.com/deroproject/derohe/rpc"
+)
+
+// Reads of the memory db must not fail while the writer holds a transaction on the same table
+func TestMemoryReadsDuringWrite(t *testing.T) {
+	mem, err := NewSqlDB(t.TempDir(), "test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.DB.Close()
+	mem.StoreOwner("sc", "owner", 1, "", "", "", "class", "")
+
+	writing, release := make(chan bool), make(chan bool)
+	done := make(chan error)
+	go func() {
+		done <- mem.write(func(tx *sql.Tx) error {
+			if _, err := tx.Exec("UPDATE scs SET class = 'changed' WHERE scid = 'sc';"); err != nil {
+				return err
+			}
+			writing <- true
+			<-release
+			return nil
+		})
+	}()
+	<-writing
+	var count int
+	err = mem.DB.QueryRow("SELECT COUNT(*) FROM scs;").Scan(&count)
+	close(release)
+	if err != nil || count != 1 {
+		t.Fatalf("read during write: %d %v", count, err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, class := mem.GetSCOwnerAndClass("sc"); class != "changed" {
+		t.Fatalf("class after write: %q", class)
+	}
+}
+
+// Memory mode flushes rows changed below the flushed height, not only the new heights
+func TestFlushCarriesOlderChanges(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := NewSqlDB(dir, "test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.DB.Close()
+	mem.SaveInitialHeight(0)
+	mem.StoreOwner("sc", "owner", 100, "", "", "", "old", "")
+	mem.StoreSCIDInvoke(structs.SCIDToIndexStage{TXHash: "tx", Params: rpc.GetSC_Params{SCID: "sc"}, Fsi: &structs.FastSyncImport{Signer: "signer"}}, 150)
+	mem.StoreLastIndexHeight(200)
+	if err = mem.WriteToDisk(200); err != nil {
+		t.Fatal(err)
+	}
+
+	mem.UpdateSCMeta("sc", "new", "")
+	mem.StoreFingerprint("sc", 100, "codehash", "simhash", "sc")
+	mem.StoreSwap(structs.SwapOrder{SCID: "sc", Height: 100, Status: "open"})
+	mem.RemoveInvokes([]structs.QuarantinedTx{{TXID: "tx", SCID: "sc", Signer: "signer", Height: 150}}, true)
+	mem.StoreLastIndexHeight(300)
+	if err = mem.WriteToDisk(300); err != nil {
+		t.Fatal(err)
+	}
+
+	disk, err := NewDiskDB(dir, "test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.DB.Close()
+	if _, class := disk.GetSCOwnerAndClass("sc"); class != "new" {
+		t.Fatalf("class on disk %q", class)
+	}
+	if scids := disk.GetSCIDsByClass([]string{"new"}); !slices.Equal(scids, []string{"sc"}) {
+		t.Fatalf("new class on disk %v", scids)
+	}
+	if _, err := disk.GetFingerprint("sc"); err != nil {
+		t.Fatalf("fingerprint on disk: %v", err)
+	}
+	if _, err := disk.GetSwap("sc"); err != nil {
+		t.Fatalf("swap on disk: %v", err)
+	}
+	if over := disk.GetInvokesOverLimit("sc", 0); len(over) != 0 {
+		t.Fatalf("purged invokes on disk %v", over)
+	}
+	if _, err := disk.GetQuarantinedTx("tx"); err != nil {
+		t.Fatalf("quarantined on disk: %v", err)
+	}
+}
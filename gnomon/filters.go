@@ -0,0 +1,334 @@
+package gnomon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/scanner"
+
+	"github.com/deroproject/derohe/dvm"
+)
+
+// Structured filters, the "match" expressions of a class
+
+// Fields usable in match expressions
+var MatchFields = []string{"func", "sig", "var", "hash", "owner", "tag"}
+
+// Compiled match expressions by class, built with the regexes
+var matchExprs = map[string][]*filterExpr{}
+
+// What a contract is filtered on, parsed parts are filled in when first needed
+type scTarget struct {
+	SCID  string
+	Code  string
+	Keys  map[string]bool
+	Owner string
+
+	parsed    *dvm.SmartContract
+	parseDone bool
+	stripped  string
+	stripDone bool
+}
+
+func (t *scTarget) contract() *dvm.SmartContract {
+	if !t.parseDone {
+		t.parseDone = true
+		sc, _, err := dvm.ParseSmartContract(t.Code)
+		if err == nil {
+			t.parsed = &sc
+		}
+	}
+	return t.parsed
+}
+
+// Code with the comments blanked out
+func (t *scTarget) codeWithoutComments() string {
+	if !t.stripDone {
+		t.stripDone = true
+		t.stripped = stripComments(t.Code)
+	}
+	return t.stripped
+}
+
+func stripComments(code string) string {
+	var s scanner.Scanner
+	s.Init(strings.NewReader(code))
+	s.Mode = scanner.ScanIdents | scanner.ScanFloats | scanner.ScanChars | scanner.ScanStrings | scanner.ScanRawStrings | scanner.ScanComments
+	s.Error = func(*scanner.Scanner, string) {}
+	out := []byte(code)
+	for tok := s.Scan(); tok != scanner.EOF; tok = s.Scan() {
+		if tok == scanner.Comment {
+			for i := s.Position.Offset; i < s.Pos().Offset && i < len(out); i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+		}
+	}
+	return string(out)
+}
+
+// Normalized function signature, eg. "transfer(string,uint64)uint64"
+func signature(name string, params []string, ret string) string {
+	return strings.ToLower(name + "(" + strings.Join(params, ",") + ")" + ret)
+}
+
+func vtypeName(t dvm.Vtype) string {
+	switch t {
+	case dvm.Uint64:
+		return "Uint64"
+	case dvm.String:
+		return "String"
+	}
+	return ""
+}
+
+// Parses a sig: value such as "Transfer(String, Uint64) Uint64"
+func parseSignature(text string) string {
+	text = strings.ReplaceAll(text, " ", "")
+	name, rest, _ := strings.Cut(text, "(")
+	params, ret, _ := strings.Cut(rest, ")")
+	list := []string{}
+	for _, p := range strings.Split(params, ",") {
+		if p != "" {
+			list = append(list, p)
+		}
+	}
+	return signature(name, list, ret)
+}
+
+type filterExpr struct {
+	op    byte // '&', '|', '!' or 0 for a term
+	field string
+	value string
+	re    *regexp.Regexp
+	args  []*filterExpr
+}
+
+func (e *filterExpr) eval(t *scTarget) bool {
+	switch e.op {
+	case '&':
+		for _, arg := range e.args {
+			if !arg.eval(t) {
+				return false
+			}
+		}
+		return true
+	case '|':
+		for _, arg := range e.args {
+			if arg.eval(t) {
+				return true
+			}
+		}
+		return false
+	case '!':
+		return !e.args[0].eval(t)
+	}
+
+	switch e.field {
+	case "func":
+		sc := t.contract()
+		if sc == nil {
+			return false
+		}
+		_, ok := sc.Functions[e.value]
+		return ok
+	case "sig":
+		sc := t.contract()
+		if sc == nil {
+			return false
+		}
+		for name, f := range sc.Functions {
+			params := []string{}
+			for _, p := range f.Params {
+				params = append(params, vtypeName(p.Type))
+			}
+			if signature(name, params, vtypeName(f.ReturnValue.Type)) == e.value {
+				return true
+			}
+		}
+		return false
+	case "var":
+		return t.Keys[e.value]
+	case "hash":
+		sum := sha256.Sum256([]byte(t.Code))
+		return strings.EqualFold(hex.EncodeToString(sum[:]), e.value)
+	case "owner":
+		return t.Owner == e.value
+	case "tag":
+		return e.re.MatchString(t.codeWithoutComments())
+	}
+	return false
+}
+
+// Reports whether the expression has a term on field
+func (e *filterExpr) uses(field string) bool {
+	if e.op == 0 {
+		return e.field == field
+	}
+	for _, arg := range e.args {
+		if arg.uses(field) {
+			return true
+		}
+	}
+	return false
+}
+
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+func tokenizeExpr(text string) (tokens []string, err error) {
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.IndexByte("()&|!", c) != -1:
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			start := i
+			for i < len(text) && strings.IndexByte(" \t()&|!\"", text[i]) == -1 {
+				i++
+			}
+			word := text[start:i]
+			// Quoted values may hold spaces and brackets
+			if i < len(text) && text[i] == '"' {
+				end := strings.IndexByte(text[i+1:], '"')
+				if end == -1 {
+					return nil, fmt.Errorf("unclosed quote in %q", text)
+				}
+				word += text[i+1 : i+1+end]
+				i += end + 2
+			}
+			tokens = append(tokens, word)
+		}
+	}
+	return
+}
+
+// Parses a match expression
+func parseFilterExpr(text string) (*filterExpr, error) {
+	tokens, err := tokenizeExpr(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in %q", p.tokens[p.pos], text)
+	}
+	return e, nil
+}
+
+func (p *exprParser) next() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) parseOr() (*filterExpr, error) {
+	return p.parseList('|', p.parseAnd)
+}
+
+func (p *exprParser) parseAnd() (*filterExpr, error) {
+	return p.parseList('&', p.parseNot)
+}
+
+func (p *exprParser) parseList(op byte, parse func() (*filterExpr, error)) (*filterExpr, error) {
+	e, err := parse()
+	if err != nil {
+		return nil, err
+	}
+	args := []*filterExpr{e}
+	for p.next() == string(op) {
+		p.pos++
+		if e, err = parse(); err != nil {
+			return nil, err
+		}
+		args = append(args, e)
+	}
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return &filterExpr{op: op, args: args}, nil
+}
+
+func (p *exprParser) parseNot() (*filterExpr, error) {
+	tok := p.next()
+	p.pos++
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case "!":
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &filterExpr{op: '!', args: []*filterExpr{e}}, nil
+	case "(":
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return e, nil
+	case ")", "&", "|":
+		return nil, fmt.Errorf("unexpected %q", tok)
+	}
+
+	field, value, found := strings.Cut(tok, ":")
+	if !found || value == "" {
+		return nil, fmt.Errorf("term %q should be field:value", tok)
+	}
+	e := &filterExpr{field: field, value: value}
+	switch field {
+	case "func", "var", "owner", "hash":
+	case "sig":
+		e.value = parseSignature(value)
+	case "tag":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("tag %q: %v", value, err)
+		}
+		e.re = re
+	default:
+		return nil, fmt.Errorf("unknown field %q, use one of %s", field, strings.Join(MatchFields, ", "))
+	}
+	return e, nil
+}
+
+// Compiles the match expressions of a filter
+func compileMatch(filter map[string][]string) (exprs []*filterExpr, err error) {
+	for _, text := range filter["match"] {
+		e, err := parseFilterExpr(text)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+	}
+	return
+}
+
+// Reports whether any active match expression has a term on field
+func filtersUse(field string) bool {
+	for _, exprs := range matchExprs {
+		for _, e := range exprs {
+			if e.uses(field) {
+				return true
+			}
+		}
+	}
+	return false
+}
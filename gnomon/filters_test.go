@@ -0,0 +1,99 @@
+package gnomon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"testing"
+)
+
+const testFilterCode = `Function InitializePrivate() Uint64
+10 STORE("nameHdr", "app") // Transfer
+20 RETURN 0
+End Function
+
+Function Transfer(dest String, amount Uint64) Uint64
+10 RETURN 0
+End Function`
+
+func TestTokenizeExpr(t *testing.T) {
+	tests := []struct {
+		text   string
+		tokens []string
+	}{
+		{"func:A & var:b", []string{"func:A", "&", "var:b"}},
+		{"!(func:A|func:B)", []string{"!", "(", "func:A", "|", "func:B", ")"}},
+		{`sig:"Transfer(String, Uint64) Uint64" | hash:ab`, []string{"sig:Transfer(String, Uint64) Uint64", "|", "hash:ab"}},
+		{"\tfunc:A  ", []string{"func:A"}},
+	}
+	for _, test := range tests {
+		tokens, err := tokenizeExpr(test.text)
+		if err != nil || !slices.Equal(tokens, test.tokens) {
+			t.Errorf("%q: tokens %q err %v", test.text, tokens, err)
+		}
+	}
+	if _, err := tokenizeExpr(`tag:"open`); err == nil {
+		t.Error("unclosed quote accepted")
+	}
+}
+
+func TestParseFilterExprErrors(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"func:A &",
+		"(func:A",
+		"func:A)",
+		"& func:A",
+		"func",
+		"func:",
+		"code:A",
+		"tag:(",
+	} {
+		if _, err := parseFilterExpr(text); err == nil {
+			t.Errorf("%q parsed", text)
+		}
+	}
+}
+
+func TestFilterExprEval(t *testing.T) {
+	sum := sha256.Sum256([]byte(testFilterCode))
+	target := &scTarget{
+		SCID:  "sc",
+		Code:  testFilterCode,
+		Keys:  map[string]bool{"nameHdr": true},
+		Owner: "alice",
+	}
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"func:Transfer", true},
+		{"func:Burn", false},
+		{`sig:"Transfer(String, Uint64) Uint64"`, true},
+		{`sig:"transfer(string,uint64)uint64"`, true},
+		{`sig:"Transfer(Uint64) Uint64"`, false},
+		{"var:nameHdr", true},
+		{"var:telaVersion", false},
+		{"hash:" + hex.EncodeToString(sum[:]), true},
+		{"owner:alice", true},
+		{"owner:bob", false},
+		{"tag:STORE", true},
+		{`tag:"// Transfer"`, false},
+		{"func:Burn | func:Transfer", true},
+		{"func:InitializePrivate & !var:nameHdr", false},
+		{"!func:Burn & (owner:bob | var:nameHdr)", true},
+		{"!!func:Transfer", true},
+		{"func:Transfer & func:Burn | owner:alice", true},
+		{"func:Transfer & (func:Burn | owner:bob)", false},
+	}
+	for _, test := range tests {
+		e, err := parseFilterExpr(test.text)
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+			continue
+		}
+		if got := e.eval(target); got != test.want {
+			t.Errorf("%q = %v", test.text, got)
+		}
+	}
+}
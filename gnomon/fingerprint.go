@@ -0,0 +1,149 @@
+package gnomon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	sql "gnomon/db"
+	"gnomon/show"
+)
+
+// Code fingerprinting and template families
+
+// Max differing simhash bits for a contract to join an existing family
+var FamilyDistance = 3
+
+// SCs per chunk when fingerprinting contracts indexed before fingerprints existed
+var FingerprintChunkSize = 500
+
+var familyMutex sync.Mutex
+var familySimHashes map[string]uint64
+
+// Strips comments, line numbers, case and extra whitespace
+func normalizeCode(code string) string {
+	lines := []string{}
+	for _, line := range strings.Split(stripComments(code), "\n") {
+		fields := strings.Fields(strings.ToLower(line))
+		if len(fields) == 0 {
+			continue
+		}
+		if _, err := strconv.ParseUint(fields[0], 10, 64); err == nil {
+			fields = fields[1:]
+		}
+		if len(fields) != 0 {
+			lines = append(lines, strings.Join(fields, " "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Returns the normalized code hash and the similarity hash, both as hex
+func codeFingerprint(code string) (codehash string, simhash string) {
+	normalized := normalizeCode(code)
+	if normalized == "" {
+		return "", ""
+	}
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:]), strconv.FormatUint(simHash(normalized), 16)
+}
+
+// Simhash of 3 token shingles
+func simHash(normalized string) uint64 {
+	tokens := strings.FieldsFunc(normalized, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	var weights [64]int
+	shingle := 3
+	if len(tokens) < shingle {
+		shingle = len(tokens)
+	}
+	for i := 0; i+shingle <= len(tokens); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(tokens[i:i+shingle], " ")))
+		v := h.Sum64()
+		for b := range 64 {
+			if v&(1<<b) != 0 {
+				weights[b]++
+			} else {
+				weights[b]--
+			}
+		}
+	}
+	var hash uint64
+	for b := range 64 {
+		if weights[b] > 0 {
+			hash |= 1 << b
+		}
+	}
+	return hash
+}
+
+// Finds the family for a new fingerprint, new families are named after the scid
+func assignFamily(store sql.Store, scid string, codehash string, simhash string) string {
+	if codehash == "" {
+		return ""
+	}
+	if family := store.GetFamilyByCodeHash(codehash); family != "" {
+		return family
+	}
+	sim, _ := strconv.ParseUint(simhash, 16, 64)
+
+	familyMutex.Lock()
+	defer familyMutex.Unlock()
+	if familySimHashes == nil {
+		familySimHashes = map[string]uint64{}
+		for family, hash := range store.GetFamilySimHashes() {
+			familySimHashes[family], _ = strconv.ParseUint(hash, 16, 64)
+		}
+	}
+	best, bestDistance := "", FamilyDistance+1
+	for family, hash := range familySimHashes {
+		distance := bits.OnesCount64(hash ^ sim)
+		if distance < bestDistance || (distance == bestDistance && family < best) {
+			best, bestDistance = family, distance
+		}
+	}
+	if best != "" {
+		return best
+	}
+	familySimHashes[scid] = sim
+	return scid
+}
+
+// Computes and stores the fingerprint of an installed contract
+func fingerprintSC(store sql.Store, scid string, height int64, code string) error {
+	codehash, simhash := codeFingerprint(code)
+	family := assignFamily(store, scid, codehash, simhash)
+	return store.StoreFingerprint(scid, height, codehash, simhash, family)
+}
+
+// Fingerprints SCs indexed before fingerprints were added
+func fingerprintMissing() {
+	store := Backend()
+	total := 0
+	for {
+		chunk := store.GetUnfingerprintedSCIDs(FingerprintChunkSize)
+		if len(chunk) == 0 {
+			break
+		}
+		for _, meta := range chunk {
+			code, _ := store.GetInitialSCIDCode(meta.SCID)
+			codehash, simhash := codeFingerprint(code)
+			family := assignFamily(store, meta.SCID, codehash, simhash)
+			if err := store.StoreFingerprint(meta.SCID, meta.Height, codehash, simhash, family); err != nil {
+				show.NewMessage(show.Message{Text: "Fingerprint error:", Err: err})
+				return
+			}
+		}
+		total += len(chunk)
+	}
+	if total != 0 {
+		show.NewMessage(show.Message{Text: "Fingerprinted SCs:", Vars: []any{total}})
+	}
+}
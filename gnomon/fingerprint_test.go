@@ -0,0 +1,99 @@
+package gnomon
+
+import (
+	"strings"
+	"testing"
+
+	sql "gnomon/db"
+)
+
+const testTokenCode = `// Token template
+Function InitializePrivate() Uint64
+10 IF EXISTS("owner") THEN GOTO 100
+20 STORE("owner", SIGNER())
+30 STORE("nameHdr", "Token")
+40 STORE("supply", 1000000)
+50 SEND_ASSET_TO_ADDRESS(SIGNER(), 1000000, SCID())
+60 RETURN 0
+100 RETURN 1
+End Function
+
+Function Transfer(dest String, amount Uint64) Uint64
+10 IF LOAD("owner") != SIGNER() THEN GOTO 100
+20 SEND_ASSET_TO_ADDRESS(ADDRESS_RAW(dest), amount, SCID())
+30 RETURN 0
+100 RETURN 1
+End Function
+
+Function SetName(name String) Uint64
+10 IF LOAD("owner") != SIGNER() THEN GOTO 100
+20 STORE("nameHdr", name)
+30 RETURN 0
+100 RETURN 1
+End Function
+
+Function Withdraw(amount Uint64) Uint64
+10 IF LOAD("owner") != SIGNER() THEN GOTO 100
+20 SEND_DERO_TO_ADDRESS(SIGNER(), amount)
+30 RETURN 0
+100 RETURN 1
+End Function`
+
+func TestNormalizeCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"10 RETURN 0", "return 0"},
+		{"Function A() Uint64\n\n  10   STORE(\"k\", 1)  \nEnd Function", "function a() uint64\nstore(\"k\", 1)\nend function"},
+		{"10 RETURN 0 // done\n/* note\n */", "return 0"},
+		{"// only a comment", ""},
+	}
+	for _, test := range tests {
+		if got := normalizeCode(test.code); got != test.want {
+			t.Errorf("%q normalized to %q", test.code, got)
+		}
+	}
+}
+
+func TestCodeFingerprint(t *testing.T) {
+	hash, sim := codeFingerprint(testTokenCode)
+	if hash == "" || sim == "" {
+		t.Fatal("no fingerprint")
+	}
+	renumbered := strings.ReplaceAll(strings.ReplaceAll(testTokenCode, "\n10 ", "\n5  "), "// Token template", "/* Copy */")
+	if h, s := codeFingerprint(renumbered); h != hash || s != sim {
+		t.Errorf("renumbered copy fingerprint %s %s, want %s %s", h, s, hash, sim)
+	}
+	if h, _ := codeFingerprint(strings.Replace(testTokenCode, "1000000", "5", 2)); h == hash {
+		t.Error("changed code has the same hash")
+	}
+	if h, s := codeFingerprint("// nothing"); h != "" || s != "" {
+		t.Errorf("empty code fingerprint %s %s", h, s)
+	}
+}
+
+func TestAssignFamily(t *testing.T) {
+	familySimHashes = nil
+	t.Cleanup(func() { familySimHashes = nil })
+	store := sql.NewMemStore()
+	tests := []struct {
+		scid   string
+		code   string
+		family string
+	}{
+		{"a", testTokenCode, "a"},
+		{"b", strings.ReplaceAll(testTokenCode, "Token", "Other"), "a"},
+		{"c", "Function Initialize() Uint64\n10 STORE(\"x\", 1)\n20 RETURN 0\nEnd Function", "c"},
+		{"d", "// copy\n" + testTokenCode, "a"},
+		{"e", "", ""},
+	}
+	for _, test := range tests {
+		if err := fingerprintSC(store, test.scid, 10, test.code); err != nil {
+			t.Fatal(err)
+		}
+		if fp, _ := store.GetFingerprint(test.scid); fp.Family != test.family {
+			t.Errorf("%s in family %q, want %q", test.scid, fp.Family, test.family)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package gnomon
 import (
 	"encoding/hex"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"math"
@@ -16,8 +17,10 @@ import (
 	"sync/atomic"
 	"time"
 
+	"gnomon/api"
 	"gnomon/daemon"
 
+	"github.com/deroproject/derohe/block"
 	"github.com/deroproject/derohe/cryptography/crypto"
 	"github.com/deroproject/derohe/rpc"
 	"github.com/deroproject/derohe/transaction"
@@ -46,6 +49,7 @@ var diskPreferredRequests = int8(10)
 var TargetHeight = int64(0)
 var LatestTopoHeight = int64(0)
 var Sqlite = &sql.SqlStore{}
+var Postgres *sql.PgStore
 var sqlindexer = &Indexer{}
 var batchSize = int16(0)
 var firstRun = true
@@ -85,6 +89,17 @@ var standalone = false
 // Start Gnomon in standalone mode
 func Run() {
 	standalone = true
+	// Check for cli args
+	setFlags()
+	if flag.Arg(0) == "snapshot" {
+		if !runSnapshot(flag.Args()[1:]) {
+			return
+		}
+	}
+	if flag.Arg(0) == "db" {
+		RunDB(flag.Args()[1:])
+		return
+	}
 	// Use defaults
 	Start(Config, []daemon.Connection{})
 }
@@ -98,13 +113,18 @@ func Start(config Configuration, endpoints []daemon.Connection) {
 		}
 	}
 
-	if standalone {
-		// Check for cli args
-		setFlags()
-	}
-
 	// Make sure the tables are ready
 	initDB()
+	if Config.Postgres != "" {
+		var err error
+		if Postgres, err = sql.NewPostgresDB(Config.Postgres); err != nil {
+			println("Err connecting to postgres:", err.Error())
+			return
+		}
+		// Postgres holds the index, the sqlite file only keeps the local settings
+		UseMem = false
+		api.Backend = Postgres
+	}
 
 	// Set endpoints if provided
 	if len(endpoints) != 0 {
@@ -171,8 +191,7 @@ func Start(config Configuration, endpoints []daemon.Connection) {
 	println("Waking the GNOMON ...")
 
 	if UseMem {
-		filesize := int(fileSizeMB(filepath.Join(dbPathAndName())))
-		filetoobig := RamSizeMB <= filesize
+		filetoobig := !fitsInMemory(fileSizeMB(filepath.Join(dbPathAndName())))
 		if !filetoobig {
 			println("Loading db into memory")
 			batchSize = memBatchSize
@@ -206,18 +225,31 @@ func Start(config Configuration, endpoints []daemon.Connection) {
 	}
 
 	sql.StartAt = startAt
-	sql.SpamLevel = Config.SpamLevel
 	show.PreferredRequests = &daemon.PreferredRequests
 	show.Status = daemon.Status
 	InitializeFilters()
+	LoadIndexPolicy()
+	api.PolicyChanged = SetIndexPolicy
+	api.ReclassifyRequested = StartReclassify
+	api.ReclassifyProgress = GetReclassifyStatus
+	api.MemoryUsage = GetMemoryUsage
+	LoadSpamPolicy()
+	api.SpamPolicyChanged = SetSpamPolicy
+	api.QuarantineRelease = ReleaseQuarantined
+	api.QuarantineDrop = DropQuarantined
+	go fingerprintMissing()
+	go swapsMissing()
 	if reclassify {
 		ReClassify()
+	} else {
+		// Picks up an interrupted task or filters changed since the last run
+		StartReclassify(false)
 	}
 
 	var starting_height = startAt
 	intialized := true
 	// See if this is a fresh start
-	last_index, err := Sqlite.GetLastIndexHeight()
+	last_index, err := Backend().GetLastIndexHeight()
 	if err != nil {
 		intialized = false
 	}
@@ -227,6 +259,10 @@ func Start(config Configuration, endpoints []daemon.Connection) {
 
 	if !intialized {
 		starting_height = Lowest_daemon_height
+		// Leave the history to the backfill workers and follow the chain from the top
+		if backfillEnabled() && LatestTopoHeight-starting_height > BackfillChunkSize {
+			starting_height = LatestTopoHeight
+		}
 	} else {
 		if Lowest_daemon_height < last_index {
 			starting_height = last_index
@@ -239,34 +275,46 @@ func Start(config Configuration, endpoints []daemon.Connection) {
 	var ending_height = int64(-1)
 	var completed string
 	if intialized {
-		last_start, _ := Sqlite.LoadState("sessionstart")
-		completed, _ = Sqlite.LoadSetting("completed") // should be minus one maybe
+		last_start, _ := Backend().LoadState("sessionstart")
+		backfillMutex.Lock()
+		completed, _ = Backend().LoadSetting("completed") // should be minus one maybe
 		completed, starting_height, ending_height = updateCompleted(starting_height, Lowest_daemon_height, completed, last_start, int(last_index))
-		Sqlite.SaveSetting("completed", completed)
+		Backend().SaveSetting("completed", completed)
+		backfillMutex.Unlock()
+		starting_height, ending_height = planAroundClaims(starting_height, completed)
 	}
 
 	if !intialized {
-		Sqlite.SaveInitialHeight(starting_height)
-		Sqlite.SaveInitialSessionStart(starting_height)
+		Backend().SaveInitialHeight(starting_height)
+		Backend().SaveInitialSessionStart(starting_height)
 	} else {
 		if starting_height != last_index {
-			Sqlite.StoreLastIndexHeight(starting_height)
+			Backend().StoreLastIndexHeight(starting_height)
 		}
-		Sqlite.StoreSessionStart(starting_height)
+		Backend().StoreSessionStart(starting_height)
 	}
 	// Tells the indexer when the current
 	EndingHeight = ending_height
 	Started = true
+	startBackfill(starting_height, ending_height)
 	start_gnomon_indexer()
 }
 
+// Store the indexer writes to and the queries read from, postgres when configured
+func Backend() sql.Store {
+	if Postgres != nil {
+		return Postgres
+	}
+	return Sqlite
+}
+
 var EndingHeight = int64(-1)
 var FinishHeight int64
 var Started = false
 
 func start_gnomon_indexer() {
 	var starting_height int64
-	starting_height, err := Sqlite.GetLastIndexHeight()
+	starting_height, err := Backend().GetLastIndexHeight()
 	if err != nil {
 		show.NewMessage(show.Message{Text: "err: ", Err: err})
 	}
@@ -276,7 +324,7 @@ func start_gnomon_indexer() {
 		firstRun = false
 		daemon.TXIDSProcessing = []string{}
 		daemon.BatchCount = 0
-		Sqlite.TrimHeight(starting_height, EndingHeight)
+		Backend().TrimHeight(starting_height, EndingHeight)
 		if daemon.Status.ErrorCount != int64(0) {
 			show.NewMessage(show.Message{
 				Vars: []any{
@@ -292,7 +340,7 @@ func start_gnomon_indexer() {
 	daemon.AssignConnections(daemon.Status.ErrorCount != int64(0)) //might as well check/retry new connections here
 	daemon.Status.ErrorCount = 0
 
-	sqlindexer = NewSQLIndexer(Sqlite, starting_height, CustomActions)
+	sqlindexer = NewSQLIndexer(Backend(), starting_height, CustomActions)
 	show.NewMessage(show.Message{Text: "Topo Height ", Vars: []any{LatestTopoHeight}})
 	show.NewMessage(show.Message{Text: "Last Height", Vars: []any{fmt.Sprint(starting_height)}})
 
@@ -359,7 +407,7 @@ func start_gnomon_indexer() {
 	}
 
 	if count <= 120 && daemon.OK() {
-		Sqlite.StoreLastIndexHeight(TargetHeight)
+		Backend().StoreLastIndexHeight(TargetHeight)
 	}
 
 	last := LatestTopoHeight
@@ -379,16 +427,14 @@ func start_gnomon_indexer() {
 	show.NewMessage(show.Message{Text: "TargetHeight:", Vars: []any{TargetHeight}})
 
 	//maybe skip when caught up
-	show.NewMessage(show.Message{Text: "Purging spam:", Vars: []any{sql.Spammers}})
-
-	Sqlite.RidSpam()
+	sweepSpam()
 
 	var switching = false
 	if UseMem {
 		show.NewMessage(show.Message{Text: "Saving Batch...... ", Vars: []any{fileSizeMB(Sqlite.Db_path), "MB"}})
 		Sqlite.WriteToDisk(EndingHeight)
-		//Check size
-		if int64(RamSizeMB) <= fileSizeMB(Sqlite.Db_path) {
+		//Check memory, the batch is on disk so the memory db can be dropped
+		if memoryExhausted() {
 			switching = true
 			Sqlite.DB.Close()
 			show.NewMessage(show.Message{Text: "Switching to disk mode...... ", Vars: []any{TargetHeight}})
@@ -398,14 +444,17 @@ func start_gnomon_indexer() {
 	show.NewMessage(show.Message{Text: "Last:", Vars: []any{last}, ShowNow: true})
 
 	if !switching && TargetHeight == EndingHeight && EndingHeight != -1 {
-		last_start, _ := Sqlite.LoadState("sessionstart")
-		completed, _ := Sqlite.LoadSetting("completed")
+		last_start, _ := Backend().LoadState("sessionstart")
+		backfillMutex.Lock()
+		completed, _ := Backend().LoadSetting("completed")
 		completed, starting_height, EndingHeight = updateCompleted(TargetHeight, Lowest_daemon_height, completed, last_start, int(TargetHeight))
-		Sqlite.SaveSetting("completed", completed)
+		Backend().SaveSetting("completed", completed)
+		backfillMutex.Unlock()
+		starting_height, EndingHeight = planAroundClaims(starting_height, completed)
 		if starting_height != TargetHeight {
-			Sqlite.StoreLastIndexHeight(starting_height)
+			Backend().StoreLastIndexHeight(starting_height)
 		}
-		Sqlite.StoreSessionStart(starting_height)
+		Backend().StoreSessionStart(starting_height)
 	}
 	//Completed to target or swithcing to disk mode
 	if TargetHeight == last || switching {
@@ -422,7 +471,12 @@ func start_gnomon_indexer() {
 		Sqlite, err = sql.NewDiskDB(dir, filename)
 	}
 	show.NewMessage(show.Message{Text: "Saving phase over......"})
-	Sqlite.ViewTables()
+	if Postgres == nil {
+		Sqlite.ViewTables()
+	}
+	// Picks up any gaps once running in disk mode
+	last_index, _ := Backend().GetLastIndexHeight()
+	startBackfill(last_index, EndingHeight)
 	//Check again if paused
 	daemon.PauseCheck()
 	start_gnomon_indexer()
@@ -436,8 +490,6 @@ func ProcessBlock(wg *sync.WaitGroup, bheight int64) {
 	if !daemon.OK() {
 		return
 	}
-	discarding := false
-
 	result := daemon.GetBlockInfo(rpc.GetBlock_Params{
 		Height: uint64(bheight),
 	})
@@ -445,27 +497,8 @@ func ProcessBlock(wg *sync.WaitGroup, bheight int64) {
 		return
 	}
 	bl := daemon.GetBlockDeserialized(result.Blob)
-
-	if len(bl.Tx_hashes) < 1 {
-		discarding = true
-	}
-
-	var tx_str_list []string
-	var regcount = 0
-
-	for _, hash := range bl.Tx_hashes {
-		if hash.String()[:5] == "00000" {
-			regcount++
-		}
-		if hash.String() != "" {
-			tx_str_list = append(tx_str_list, hash.String())
-		}
-	}
-
-	tx_count := len(tx_str_list)
-	if tx_count == 0 || regcount > 10 {
-		discarding = true
-	}
+	storeMiners(sqlindexer, bheight, result, bl)
+	tx_str_list, discarding := blockTxIds(bl)
 	//good place to set large block flag if needed
 
 	daemon.Mutex.Lock()
@@ -506,6 +539,47 @@ func ProcessBlock(wg *sync.WaitGroup, bheight int64) {
 	}
 }
 
+// Returns the tx hashes worth requesting and whether the block can be skipped
+func blockTxIds(bl block.Block) (tx_str_list []string, discarding bool) {
+	if len(bl.Tx_hashes) < 1 {
+		discarding = true
+	}
+	var regcount = 0
+
+	for _, hash := range bl.Tx_hashes {
+		if hash.String()[:5] == "00000" {
+			regcount++
+		}
+		if hash.String() != "" {
+			tx_str_list = append(tx_str_list, hash.String())
+		}
+	}
+
+	tx_count := len(tx_str_list)
+	if tx_count == 0 || (maxRegistrations() != 0 && regcount > maxRegistrations()) {
+		discarding = true
+	}
+	return
+}
+
+// Counts the miniblock finders and the integrator of a block
+func storeMiners(indexer *Indexer, bheight int64, result rpc.GetBlock_Result, bl block.Block) {
+	var miners []string
+	for _, miner := range result.Block_Header.Miners {
+		if miner != "unknown" && miner != "" {
+			miners = append(miners, miner)
+		}
+	}
+	integrator := ""
+	if addr, err := rpc.NewAddressFromCompressedKeys(bl.Miner_TX.MinerAddress[:]); err == nil {
+		addr.Mainnet = isMainnet()
+		integrator = addr.String()
+	}
+	if _, err := indexer.SSSBackend.StoreMiners(bheight, miners, integrator); err != nil {
+		show.NewMessage(show.Message{Text: "Error storing miners: ", Vars: []any{err}})
+	}
+}
+
 var laststored = int64(0)
 
 func DoBatch(wga *sync.WaitGroup, batch daemon.Batch) {
@@ -565,10 +639,22 @@ func saveDetails(wg2 *sync.WaitGroup, tx transaction.Transaction, bheight int64,
 	if !daemon.OK() {
 		return
 	}
+	txhash := tx.GetHash().String()
+	daemon.RemoveTXs([]string{txhash})
+
+	indexTx(sqlindexer, tx, bheight, signer)
+
+	updateBlocks(daemon.Batch{
+		TxIds: []string{txhash},
+	})
+
+}
+
+// Decides if the tx should be indexed and hands it to the indexer
+func indexTx(indexer *Indexer, tx transaction.Transaction, bheight int64, signer string) {
 	var wg3 sync.WaitGroup
 	ok := true
 	txhash := tx.GetHash().String()
-	daemon.RemoveTXs([]string{txhash})
 
 	if tx.TransactionType != transaction.SC_TX { //|| (len(tx.Payloads) > 10 && tx.Payloads[0].RPCType == byte(transaction.REGISTRATION))
 		ok = false
@@ -591,12 +677,31 @@ func saveDetails(wg2 *sync.WaitGroup, tx transaction.Transaction, bheight int64,
 		}
 	}
 
+	// Names are kept even when the name service invokes are discarded
+	if tx_type == "invoke" && params.SCID == Hardcoded_SCIDS[0] {
+		indexName(indexer.SSSBackend, tx, bheight, signer)
+	}
+
 	// Discard the discardable
 	if CustomActions[params.SCID].Act == "discard" ||
 		(CustomActions[params.SCID].Act == "discard-before" && CustomActions[params.SCID].Block >= bheight) {
 		ok = false
-	} else if (slices.Contains(sql.Spammers, signer)) && params.SCID == Hardcoded_SCIDS[0] { //Not great
+	} else if tx_type == "invoke" && !invokeAllowed(params.SCID, getEntrypoint(tx)) {
 		ok = false
+	} else if tx_type == "invoke" {
+		if reason, quarantine := spamCheck(indexer.SSSBackend, txhash, tx.Fees(), params.SCID, signer, bheight); reason != "" {
+			ok = false
+			if quarantine {
+				indexer.SSSBackend.StoreQuarantined(structs.QuarantinedTx{
+					TXID:       txhash,
+					SCID:       params.SCID,
+					Signer:     signer,
+					Height:     bheight,
+					Entrypoint: getEntrypoint(tx),
+					Reason:     reason,
+				})
+			}
+		}
 	}
 
 	if ok {
@@ -612,24 +717,31 @@ func saveDetails(wg2 *sync.WaitGroup, tx transaction.Transaction, bheight int64,
 		}
 
 		wg3.Add(1)
-		go processSCs(&wg3, tx, tx_type, params, bheight, signer)
+		go processSCs(&wg3, indexer, tx, tx_type, params, bheight, signer)
 		wg3.Wait()
 	}
-
-	updateBlocks(daemon.Batch{
-		TxIds: []string{txhash},
-	})
-
 }
 
-func processSCs(wg3 *sync.WaitGroup, tx transaction.Transaction, tx_type string, params rpc.GetSC_Params, bheight int64, signer string) {
+func processSCs(wg3 *sync.WaitGroup, indexer *Indexer, tx transaction.Transaction, tx_type string, params rpc.GetSC_Params, bheight int64, signer string) {
 	defer wg3.Done()
 	if !daemon.OK() {
 		return
 	}
 	daemon.Ask("sc")
-	sc := daemon.GetSC(params) //Variables: true,
-	if !daemon.OK() {          //|| sc.Status != "OK"
+	sc, err := daemon.GetSCChecked(params) //Variables: true,
+	if !daemon.OK() {
+		return
+	}
+	// Only the daemon answering without code marks an install as failed, keep the fee that was burnt
+	if tx_type == "install" && errors.Is(err, daemon.ErrNoSC) {
+		err := indexer.SSSBackend.StoreInvalidSCIDDeploy(structs.InvalidDeploy{SCID: params.SCID, Signer: signer, Height: bheight, Fee: tx.Fees()})
+		if err != nil {
+			show.NewMessage(show.Message{Text: "Error storing invalid deploy: ", Vars: []any{err}})
+		}
+		return
+	}
+	// A failed request leaves the SC for a later pass
+	if err != nil {
 		return
 	}
 	vars, err := GetSCVariables(sc.VariableStringKeys, sc.VariableUint64Keys)
@@ -648,12 +760,23 @@ func processSCs(wg3 *sync.WaitGroup, tx transaction.Transaction, tx_type string,
 	if params.SCID != Hardcoded_SCIDS[0] { //only need the name for these
 		scdesc = daemon.GetSCDescriptionFromVars(kv)
 		scimgurl = daemon.GetSCIDImageURLFromVars(kv)
-		class, tags = getFiltered(sc.Code)
+		if tx_type == "install" {
+			// var: terms see the keys stored by the install, as when reclassifying
+			target := &scTarget{SCID: params.SCID, Code: sc.Code, Keys: map[string]bool{}, Owner: signer}
+			for key := range kv {
+				target.Keys[key] = true
+			}
+			class, tags = getFiltered(target)
+		} else {
+			// Invokes are fetched without the code, the class is the one found at install
+			_, class = Backend().GetSCOwnerAndClass(params.SCID)
+		}
 	}
-	entrypoint := ""
-	if tx.SCDATA.HasValue("entrypoint", rpc.DataString) {
-		entrypoint = tx.SCDATA.Value("entrypoint", rpc.DataString).(string)
+	// Selective indexing, classes are only known once the code is filtered
+	if tx_type == "install" && !installAllowed(params.SCID, signer, class) {
+		return
 	}
+	entrypoint := getEntrypoint(tx)
 	//mess
 	staged := structs.SCIDToIndexStage{
 		Type:       tx_type,
@@ -671,10 +794,9 @@ func processSCs(wg3 *sync.WaitGroup, tx transaction.Transaction, tx_type string,
 	//fmt.Println("staged params.scid:", params.SCID, ":", fmt.Sprint(staged.Fsi.Height))
 
 	// now add the scid to the index
-	sql.Ask()
 	// if the contract already exists, record the interaction
 
-	if err := sqlindexer.AddSCIDToIndex(staged); err != nil {
+	if err := indexer.AddSCIDToIndex(staged); err != nil {
 		show.NewMessage(show.Message{Vars: []any{err, " ", staged.TXHash, " ", staged.Fsi.Height}})
 		if strings.Contains(err.Error(), "database is locked") {
 			daemon.NewError("database", "db lock", "Adding index")
@@ -687,7 +809,6 @@ func storeHeight(bheight int64) {
 	if !daemon.OK() {
 		return
 	}
-	sql.Ask()
 	//fmt.Println("Saving LastIndexHeight: ", bheight)
 	if ok, err := sqlindexer.SSSBackend.StoreLastIndexHeight(int64(bheight)); !ok && err != nil {
 		show.NewMessage(show.Message{Text: "Error Saving LastIndexHeight: ", Vars: []any{err}})
@@ -697,6 +818,13 @@ func storeHeight(bheight int64) {
 	}
 }
 
+func getEntrypoint(tx transaction.Transaction) string {
+	if tx.SCDATA.HasValue("entrypoint", rpc.DataString) {
+		return tx.SCDATA.Value("entrypoint", rpc.DataString).(string)
+	}
+	return ""
+}
+
 func decodeTx(tx_hex string) (transaction.Transaction, error) {
 	b, err := hex.DecodeString(tx_hex)
 	if err != nil {
@@ -808,63 +936,59 @@ func getOutCounts() (int, string) {
 	return tot, text
 }
 
-func ReClassify() {
-	scids := Sqlite.GetSCIDS()
-	total := len(scids)
-	progress := 0
-	println("Reclassifying: ", total, " Smart Contracts")
-	for _, scid := range scids {
-		sc_code, _ := Sqlite.GetInitialSCIDCode(scid)
-		class, tags := getFiltered(sc_code)
-		Sqlite.UpdateSCMeta(scid, class, tags)
-		progress++
-		print("\rProgress: ", fmt.Sprintf("%.2f", 1.0/(float64(total)/float64(progress))*100.0), "%")
-	}
-	if UseMem {
-		println("\nSaving...")
-		Sqlite.BackupToDisk()
-	}
-}
-
 func InitializeFilters() {
 	println("Active regex filters:")
 	regexes = map[string]string{} //reset since it is an init process
+	matchExprs = map[string][]*filterExpr{}
 	for class, filter := range Filters {
-		for i, tag := range filter["tags"] {
-			if i == 0 {
-				regexes[class] += tag
-			} else {
-				regexes[class] += "|" + tag
-			}
+		regexes[class] = filterRegex(filter)
+		println(regexes[class])
+		exprs, err := compileMatch(filter)
+		if err != nil {
+			log.Fatalf("Invalid match for %s: %v", class, err)
 		}
-		b := false
-		i := false
-		for _, option := range filter["options"] {
-			if option == "b" {
-				b = true
-			} else if option == "i" {
-				i = true
-			}
+		if len(exprs) != 0 {
+			matchExprs[class] = exprs
+			println(class, "match:", strings.Join(filter["match"], " AND "))
 		}
-		ii := ""
-		rs := ""
-		re := ""
-		if b && i {
-			rs = `\b`
-			re = `\b`
-			ii = "i"
-		} else if b && !i {
-			rs = `\b`
-			re = `\b`
-		} else if !b && i {
-			rs = ``
-			re = ``
-			ii = "i"
-		}
-		regexes[class] = `(?` + ii + `)` + rs + `(` + regexes[class] + `)` + re
+	}
+}
 
-		println(regexes[class])
+// Builds the regex for a class filter
+func filterRegex(filter map[string][]string) (regex string) {
+	for i, tag := range filter["tags"] {
+		if i == 0 {
+			regex += tag
+		} else {
+			regex += "|" + tag
+		}
+	}
+	b := false
+	i := false
+	for _, option := range filter["options"] {
+		if option == "b" {
+			b = true
+		} else if option == "i" {
+			i = true
+		}
 	}
+	ii := ""
+	rs := ""
+	re := ""
+	if b && i {
+		rs = `\b`
+		re = `\b`
+		ii = "i"
+	} else if b && !i {
+		rs = `\b`
+		re = `\b`
+	} else if !b && i {
+		rs = ``
+		re = ``
+		ii = "i"
+	}
+	regex = `(?` + ii + `)` + rs + `(` + regex + `)` + re
+	return
 }
 
 func isOfClass(tags []string, matches []string) bool {
@@ -878,20 +1002,14 @@ func isOfClass(tags []string, matches []string) bool {
 	return false
 }
 
-func getFiltered(sc_code string) (class string, tags string) {
-	for cl, filter := range Filters {
-		matches := findMatches(sc_code, cl)
-		if len(matches) != 0 {
-			if isOfClass(filter["tags"], matches) && !strings.Contains(class, cl) { //not perfect but could be fixed with arrays...
-				class = class + "," + cl
-			}
+func getFiltered(sc *scTarget) (class string, tags string) {
+	for cl := range Filters {
+		isclass, matches := matchFilter(sc, cl)
+		if isclass && !strings.Contains(class, cl) { //not perfect but could be fixed with arrays...
+			class = class + "," + cl
 		}
-		//don't save tags for class only settings
-		options, exists := filter["options"]
-		if !exists || !slices.Contains(options, "co") {
-			for _, match := range matches {
-				tags = tags + "," + match
-			}
+		for _, match := range matches {
+			tags = tags + "," + match
 		}
 
 		class = strings.TrimPrefix(class, ",")
@@ -899,6 +1017,37 @@ func getFiltered(sc_code string) (class string, tags string) {
 	}
 	return
 }
+
+// Checks a contract against a single class filter, returns the tags to save
+func matchFilter(sc *scTarget, cl string) (isclass bool, tags []string) {
+	filter := Filters[cl]
+	var matches []string
+	if len(filter["tags"]) != 0 {
+		code := sc.Code
+		if slices.Contains(filter["options"], "nc") {
+			code = sc.codeWithoutComments()
+		}
+		matches = findMatches(code, cl)
+	}
+	if exprs, ok := matchExprs[cl]; ok {
+		isclass = true
+		for _, e := range exprs {
+			if !e.eval(sc) {
+				isclass = false
+				break
+			}
+		}
+	} else if len(matches) != 0 {
+		isclass = isOfClass(filter["tags"], matches)
+	}
+	//don't save tags for class only settings
+	options, exists := filter["options"]
+	if !exists || !slices.Contains(options, "co") {
+		tags = matches
+	}
+	return
+}
+
 func findMatches(text string, class string) (matches []string) {
 	re, err := regexp.Compile(regexes[class])
 	if err != nil {
@@ -946,27 +1095,3 @@ fmt.Println("batch.TxIds:", len(batch.TxIds))
 	}
 
 */
-// Supply true to boot from disk, returns true if memory is nearly full
-/*func memModeSelect(boot bool) bool {
-	if mbFree() < 1000 {
-		if boot {
-			UseMem = false
-			// Extract filename
-			filename := filepath.Base(Sqlite.db_path)
-			dir := filepath.Dir(Sqlite.db_path)
-			// Start disk mode
-			sqlite, _ = NewDiskDB(dir, filename)
-		}
-		return true
-	}
-	return false
-}
-
-// Supply true to boot from disk, returns true if memory is nearly full
-
-func mbFree() int64 {
-	var memStats runtime.MemStats
-	runtime.ReadMemStats(&memStats)
-	return int64(memStats.HeapIdle-memStats.HeapSys) / 1024 / 1024
-}
-*/
@@ -3,6 +3,7 @@ module gnomon
 go 1.26.0
 
 require (
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.34
 	github.com/ybbus/jsonrpc/v3 v3.1.7
 )
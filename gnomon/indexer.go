@@ -7,6 +7,7 @@ import (
 	"regexp"
 
 	sql "gnomon/db"
+	"gnomon/show"
 	"gnomon/structs"
 
 	"github.com/deroproject/derohe/cryptography/crypto"
@@ -36,12 +37,12 @@ type Indexer struct {
 	ChainHeight       int64
 	SearchFilter      []string
 	CustomActions     map[string]action
-	SSSBackend        *sql.SqlStore
+	SSSBackend        sql.Store
 	ValidatedSCs      []string
 	Status            string
 }
 
-func NewSQLIndexer(Sqls_backend *sql.SqlStore, last_indexedheight int64, CustomActions map[string]action) *Indexer {
+func NewSQLIndexer(Sqls_backend sql.Store, last_indexedheight int64, CustomActions map[string]action) *Indexer {
 	return &Indexer{
 		LastIndexedHeight: last_indexedheight,
 		CustomActions:     CustomActions,
@@ -67,6 +68,7 @@ func (indexer *Indexer) AddSCIDToIndex(scidstoadd structs.SCIDToIndexStage) (err
 	if len(scidstoadd.ScVars) != 0 && indexer.CustomActions[scidstoadd.Params.SCID].Act != "saveasinteraction" {
 
 		changed, err = indexer.SSSBackend.StoreSCIDVariableDetails(
+			scidstoadd.Params.SCID,
 			scidstoadd.TXHash,
 			scidstoadd.ScVars,
 			int64(scidstoadd.Fsi.Height),
@@ -78,8 +80,19 @@ func (indexer *Indexer) AddSCIDToIndex(scidstoadd structs.SCIDToIndexStage) (err
 		if !changed {
 			return errors.New("did not store scid/vars")
 		}
+		if isSwap(scidstoadd.Class) {
+			if err := updateSwap(indexer.SSSBackend, scidstoadd.Params.SCID, int64(scidstoadd.Fsi.Height), invokeCode(scidstoadd), scidstoadd.Entrypoint, scidstoadd.ScVars); err != nil {
+				return err
+			}
+		}
 
 		if scidstoadd.ScCode != "" && scidstoadd.Type == "install" { //or custom add maybe...
+			if err := fingerprintSC(indexer.SSSBackend, scidstoadd.TXHash, int64(scidstoadd.Fsi.Height), scidstoadd.ScCode); err != nil {
+				return err
+			}
+			if _, err := indexer.SSSBackend.StoreCodeVersion(scidstoadd.TXHash, scidstoadd.TXHash, int64(scidstoadd.Fsi.Height), scidstoadd.ScCode); err != nil {
+				return err
+			}
 			changed, err = indexer.SSSBackend.StoreOwner(
 				scidstoadd.TXHash,
 				scidstoadd.Fsi.Signer,
@@ -100,6 +113,14 @@ func (indexer *Indexer) AddSCIDToIndex(scidstoadd structs.SCIDToIndexStage) (err
 			}
 		} else if scidstoadd.Type == "invoke" {
 			//it is an invoke
+			// Catch UPDATE_SC_CODE by comparing against the stored code
+			updated, err := indexer.SSSBackend.StoreCodeVersion(scidstoadd.Params.SCID, scidstoadd.TXHash, int64(scidstoadd.Fsi.Height), invokeCode(scidstoadd))
+			if err != nil {
+				return err
+			}
+			if updated {
+				show.NewMessage(show.Message{Text: "SC code updated:", Vars: []any{scidstoadd.Params.SCID, scidstoadd.Fsi.Height}})
+			}
 			changed, err = indexer.SSSBackend.StoreSCIDInvoke(
 				scidstoadd,
 				int64(scidstoadd.Fsi.Height),
@@ -134,6 +155,21 @@ func (indexer *Indexer) AddSCIDToIndex(scidstoadd structs.SCIDToIndexStage) (err
 	return nil
 }
 
+// The code in place after an invoke. Invokes are fetched without the code, it is read
+// from the "C" variable instead, so invokes stored as interactions only have none
+func invokeCode(staged structs.SCIDToIndexStage) string {
+	if staged.ScCode != "" {
+		return staged.ScCode
+	}
+	for _, v := range staged.ScVars {
+		if key, ok := v.Key.(string); ok && key == "C" {
+			code, _ := v.Value.(string)
+			return code
+		}
+	}
+	return ""
+}
+
 // Gets SC variable details
 func GetSCVariables(keysstring map[string]any, keysuint64 map[uint64]any) (variables []*structs.SCIDVariable, err error) {
 	//balances = make(map[string]uint64)
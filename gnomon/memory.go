@@ -0,0 +1,91 @@
+package gnomon
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"gnomon/show"
+	"gnomon/structs"
+)
+
+// Memory accounting for memory mode
+
+// Share of RamSizeMB that can be used before shrinking or switching to disk mode
+var MemHighWater = 0.9
+
+// System memory to leave available, 0 to ignore it
+var MinSystemFreeMB = int64(256)
+
+func GetMemoryUsage() (usage structs.MemoryUsage) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	usage.Mode = "disk"
+	if Postgres != nil {
+		usage.Mode = "postgres"
+	}
+	usage.LimitMB = int64(RamSizeMB)
+	usage.HeapMB = int64(stats.HeapAlloc >> 20)
+	if UseMem && Sqlite != nil && Sqlite.DB != nil {
+		usage.Mode = "memory"
+		used, free := Sqlite.PageUsage()
+		usage.DBMB, usage.FreePagesMB = used>>20, free>>20
+	}
+	usage.UsedMB = usage.DBMB + usage.HeapMB
+	usage.HeadroomMB = usage.LimitMB - usage.UsedMB
+	usage.SystemFreeMB = systemFreeMB()
+	return
+}
+
+// Whether a db of db_mb can be loaded next to the current heap
+func fitsInMemory(db_mb int64) bool {
+	usage := GetMemoryUsage()
+	usage.UsedMB = db_mb + usage.HeapMB
+	return !memoryFull(usage)
+}
+
+func memoryFull(usage structs.MemoryUsage) bool {
+	if float64(usage.UsedMB) >= float64(usage.LimitMB)*MemHighWater {
+		return true
+	}
+	return MinSystemFreeMB != 0 && usage.SystemFreeMB != 0 && usage.SystemFreeMB < MinSystemFreeMB
+}
+
+// Called after a flush, shrinks the memory db when near the limit and returns true if it's still full
+func memoryExhausted() bool {
+	usage := GetMemoryUsage()
+	if !memoryFull(usage) {
+		return false
+	}
+	if usage.FreePagesMB != 0 {
+		show.NewMessage(show.Message{Text: "Shrinking memory db......", Vars: []any{usage.FreePagesMB, "MB free"}})
+		if err := Sqlite.Shrink(); err != nil {
+			show.NewMessage(show.Message{Text: "Error shrinking memory db:", Err: err})
+		}
+	}
+	debug.FreeOSMemory()
+	usage = GetMemoryUsage()
+	show.NewMessage(show.Message{Text: "Memory in use:", Vars: []any{usage.UsedMB, "MB of", usage.LimitMB, "MB"}})
+	return memoryFull(usage)
+}
+
+// MemAvailable from /proc/meminfo, 0 where it can't be read
+func systemFreeMB() int64 {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, _ := strconv.ParseInt(fields[1], 10, 64)
+			return kb >> 10
+		}
+	}
+	return 0
+}
@@ -0,0 +1,46 @@
+package gnomon
+
+import (
+	"strings"
+
+	sql "gnomon/db"
+	"gnomon/show"
+
+	"github.com/deroproject/derohe/rpc"
+	"github.com/deroproject/derohe/transaction"
+)
+
+// Name service registrations read from the invoke arguments
+
+// Records name service invokes in the names table
+func indexName(store sql.Store, tx transaction.Transaction, bheight int64, signer string) {
+	if !strings.HasPrefix(signer, "dero") && !strings.HasPrefix(signer, "deto") {
+		return
+	}
+	if !tx.SCDATA.HasValue("name", rpc.DataString) {
+		return
+	}
+	name := tx.SCDATA.Value("name", rpc.DataString).(string)
+	txid := tx.GetHash().String()
+
+	var err error
+	switch getEntrypoint(tx) {
+	case "Register":
+		if len(name) < 6 || len(name) >= 64 {
+			return
+		}
+		_, err = store.StoreName(name, signer, bheight, txid)
+	case "TransferOwnership":
+		if !tx.SCDATA.HasValue("newowner", rpc.DataString) {
+			return
+		}
+		newowner, perr := rpc.NewAddress(tx.SCDATA.Value("newowner", rpc.DataString).(string))
+		if perr != nil {
+			return
+		}
+		_, err = store.TransferName(name, signer, newowner.String(), bheight)
+	}
+	if err != nil {
+		show.NewMessage(show.Message{Text: "Name registry error:", Err: err})
+	}
+}
@@ -0,0 +1,109 @@
+package gnomon
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+
+	"gnomon/show"
+	"gnomon/structs"
+)
+
+// Selective indexing, exclude rules always win
+var indexPolicy = structs.IndexPolicy{}
+var policyMutex sync.RWMutex
+
+// Loads the saved policy from settings
+func LoadIndexPolicy() {
+	val, _ := Sqlite.LoadSetting("IndexPolicy")
+	policy := structs.IndexPolicy{}
+	if val != "" {
+		json.Unmarshal([]byte(val), &policy)
+	}
+	SetIndexPolicy(policy)
+}
+
+// Replaces the live policy, takes effect on the next indexed tx
+func SetIndexPolicy(policy structs.IndexPolicy) {
+	policyMutex.Lock()
+	indexPolicy = policy
+	policyMutex.Unlock()
+	if policyActive() {
+		show.NewMessage(show.Message{Text: "Index policy:", Vars: []any{policy.Include, policy.Exclude}})
+	}
+}
+
+func GetIndexPolicy() structs.IndexPolicy {
+	policyMutex.RLock()
+	defer policyMutex.RUnlock()
+	return indexPolicy
+}
+
+func policyActive() bool {
+	policyMutex.RLock()
+	defer policyMutex.RUnlock()
+	return len(indexPolicy.Include) != 0 || len(indexPolicy.Exclude) != 0
+}
+
+// Returns true if any rule needs the given field
+func policyUses(field string) bool {
+	policyMutex.RLock()
+	defer policyMutex.RUnlock()
+	return len(indexPolicy.Include[field]) != 0 || len(indexPolicy.Exclude[field]) != 0
+}
+
+func policyMatches(rules map[string][]string, fields map[string][]string) bool {
+	for field, values := range rules {
+		for _, value := range fields[field] {
+			if value != "" && slices.Contains(values, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Checks the contract fields against the policy
+func policyAllows(fields map[string][]string) bool {
+	policyMutex.RLock()
+	defer policyMutex.RUnlock()
+	if policyMatches(indexPolicy.Exclude, fields) {
+		return false
+	}
+	include := false
+	for _, values := range indexPolicy.Include {
+		if len(values) != 0 {
+			include = true
+		}
+	}
+	return !include || policyMatches(indexPolicy.Include, fields)
+}
+
+// Invokes use the owner and class stored when the contract was installed
+func invokeAllowed(scid string, entrypoint string) bool {
+	if !policyActive() {
+		return true
+	}
+	fields := map[string][]string{
+		"scid":       {scid},
+		"entrypoint": {entrypoint},
+	}
+	if policyUses("owner") || policyUses("class") {
+		owner, class := Backend().GetSCOwnerAndClass(scid)
+		fields["owner"] = []string{owner}
+		fields["class"] = strings.Split(class, ",")
+	}
+	return policyAllows(fields)
+}
+
+func installAllowed(scid string, owner string, class string) bool {
+	if !policyActive() {
+		return true
+	}
+	return policyAllows(map[string][]string{
+		"scid":  {scid},
+		"owner": {owner},
+		"class": strings.Split(class, ","),
+	})
+}
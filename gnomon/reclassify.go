@@ -0,0 +1,302 @@
+package gnomon
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	sql "gnomon/db"
+	"gnomon/show"
+	"gnomon/structs"
+)
+
+// Background reclassification of the classes whose filters changed
+
+// SCs per chunk
+var ReclassifyChunkSize = 500
+
+type reclassifyTask struct {
+	Filters   map[string]map[string][]string `json:"filters"`
+	Classes   []string                       `json:"classes"`
+	Stale     map[string][]string            `json:"stale"` // earlier regexes of the changed classes, for removing their tags
+	Full      bool                           `json:"full"`
+	After     string                         `json:"after"`
+	Processed int                            `json:"processed"`
+}
+
+var reclassifyMutex sync.Mutex
+var reclassifyStatus = structs.ReclassifyStatus{}
+
+// Returns the classes with different definitions in the two filter sets
+func changedClasses(from map[string]map[string][]string, to map[string]map[string][]string) (classes []string) {
+	for cl, filter := range to {
+		if !reflect.DeepEqual(from[cl], filter) {
+			classes = append(classes, cl)
+		}
+	}
+	for cl := range from {
+		if _, ok := to[cl]; !ok {
+			classes = append(classes, cl)
+		}
+	}
+	sort.Strings(classes)
+	return
+}
+
+// Adds the regexes of the given definitions to the stale list of the changed classes
+func addStale(task *reclassifyTask, filters map[string]map[string][]string) {
+	if task.Stale == nil {
+		task.Stale = map[string][]string{}
+	}
+	for _, cl := range task.Classes {
+		if filter, ok := filters[cl]; ok && len(filter["tags"]) != 0 {
+			regex := filterRegex(filter)
+			if !slices.Contains(task.Stale[cl], regex) {
+				task.Stale[cl] = append(task.Stale[cl], regex)
+			}
+		}
+	}
+}
+
+// Works out what needs reclassifying, returns false if there is nothing to do
+func planReclassify(disk *sql.SqlStore, full bool) (task reclassifyTask, ok bool) {
+	var classified map[string]map[string][]string
+	val, _ := disk.LoadSetting("ClassifiedFilters")
+	if val != "" {
+		json.Unmarshal([]byte(val), &classified)
+	}
+
+	if full {
+		task = reclassifyTask{Filters: Filters, Full: true}
+		for cl := range Filters {
+			task.Classes = append(task.Classes, cl)
+		}
+		sort.Strings(task.Classes)
+		return task, true
+	}
+
+	val, _ = disk.LoadSetting("ReclassifyTask")
+	if val != "" && json.Unmarshal([]byte(val), &task) == nil {
+		if reflect.DeepEqual(task.Filters, Filters) {
+			return task, true
+		}
+		// Filters changed again mid task, start over including the new changes
+		for _, cl := range changedClasses(task.Filters, Filters) {
+			if !slices.Contains(task.Classes, cl) {
+				task.Classes = append(task.Classes, cl)
+			}
+		}
+		sort.Strings(task.Classes)
+		addStale(&task, task.Filters)
+		task.Filters = Filters
+		task.After = ""
+		task.Processed = 0
+		return task, true
+	}
+
+	if classified == nil {
+		// Nothing to compare against, assume the db matches the current filters
+		saveClassified(disk, Filters)
+		return task, false
+	}
+	task = reclassifyTask{Filters: Filters, Classes: changedClasses(classified, Filters)}
+	if len(task.Classes) == 0 {
+		return task, false
+	}
+	addStale(&task, classified)
+	return task, true
+}
+
+func saveClassified(disk *sql.SqlStore, filters map[string]map[string][]string) {
+	bytes, _ := json.Marshal(filters)
+	disk.SaveSetting("ClassifiedFilters", string(bytes))
+}
+
+// Starts a background reclassification, returns false if one is running or there is nothing to do
+func StartReclassify(full bool) bool {
+	reclassifyMutex.Lock()
+	if reclassifyStatus.Running {
+		reclassifyMutex.Unlock()
+		return false
+	}
+	disk, err := sql.NewDiskDB(dbPathAndName())
+	if err != nil {
+		reclassifyMutex.Unlock()
+		show.NewMessage(show.Message{Text: "Reclassify db error:", Err: err})
+		return false
+	}
+	task, ok := planReclassify(disk, full)
+	if !ok {
+		reclassifyMutex.Unlock()
+		disk.DB.Close()
+		return false
+	}
+	reclassifyStatus = structs.ReclassifyStatus{Running: true, Classes: task.Classes, Processed: task.Processed}
+	reclassifyMutex.Unlock()
+
+	go func() {
+		defer disk.DB.Close()
+		runReclassify(disk, task)
+	}()
+	return true
+}
+
+// Reclassifies every SC with the current filters and waits for it to finish
+func ReClassify() {
+	reclassifyMutex.Lock()
+	if reclassifyStatus.Running {
+		reclassifyMutex.Unlock()
+		println("Reclassification already running")
+		return
+	}
+	disk, err := sql.NewDiskDB(dbPathAndName())
+	if err != nil {
+		reclassifyMutex.Unlock()
+		println("Reclassify db error:", err.Error())
+		return
+	}
+	defer disk.DB.Close()
+	task, _ := planReclassify(disk, true)
+	reclassifyStatus = structs.ReclassifyStatus{Running: true, Classes: task.Classes}
+	reclassifyMutex.Unlock()
+	runReclassify(disk, task)
+}
+
+func GetReclassifyStatus() structs.ReclassifyStatus {
+	reclassifyMutex.Lock()
+	defer reclassifyMutex.Unlock()
+	return reclassifyStatus
+}
+
+func runReclassify(disk *sql.SqlStore, task reclassifyTask) {
+	total := Backend().CountSCs()
+	reclassifyMutex.Lock()
+	reclassifyStatus.Total = total
+	reclassifyMutex.Unlock()
+	show.NewMessage(show.Message{Text: "Reclassifying:", Vars: []any{task.Classes, "in", total, "SCs"}, ShowNow: true})
+
+	stale := map[string][]*regexp.Regexp{}
+	for cl, list := range task.Stale {
+		for _, regex := range list {
+			if re, err := regexp.Compile(regex); err == nil {
+				stale[cl] = append(stale[cl], re)
+			}
+		}
+	}
+	unchanged := []*regexp.Regexp{}
+	for cl, filter := range task.Filters {
+		if !slices.Contains(task.Classes, cl) && len(filter["tags"]) != 0 {
+			if re, err := regexp.Compile(filterRegex(filter)); err == nil {
+				unchanged = append(unchanged, re)
+			}
+		}
+	}
+
+	for {
+		chunk := Backend().GetSCMetaAfter(task.After, ReclassifyChunkSize)
+		if len(chunk) == 0 {
+			break
+		}
+		for _, meta := range chunk {
+			class, tags := reclassifySC(task, meta, stale, unchanged)
+			if class == meta.Class && tags == meta.Tags {
+				continue
+			}
+			Backend().UpdateSCMeta(meta.SCID, class, tags)
+		}
+		task.After = chunk[len(chunk)-1].SCID
+		task.Processed += len(chunk)
+		bytes, _ := json.Marshal(task)
+		disk.SaveSetting("ReclassifyTask", string(bytes))
+
+		reclassifyMutex.Lock()
+		reclassifyStatus.Processed = task.Processed
+		reclassifyMutex.Unlock()
+		show.NewMessage(show.Message{Text: "Reclassify progress:", Vars: []any{task.Processed, "/", total}})
+	}
+
+	saveClassified(disk, task.Filters)
+	disk.SaveSetting("ReclassifyTask", "")
+	reclassifyMutex.Lock()
+	reclassifyStatus.Running = false
+	reclassifyMutex.Unlock()
+	show.NewMessage(show.Message{Text: "Reclassify finished:", Vars: []any{task.Processed, "SCs"}, ShowNow: true})
+}
+
+// Returns the new class and tags of an SC, only the changed classes are re-evaluated
+func reclassifySC(task reclassifyTask, meta structs.SCMeta, stale map[string][]*regexp.Regexp, unchanged []*regexp.Regexp) (class string, tags string) {
+	target := &scTarget{SCID: meta.SCID, Owner: meta.Owner}
+	needCode := task.Full
+	for _, cl := range task.Classes {
+		if _, ok := Filters[cl]; ok {
+			needCode = true
+		}
+	}
+	if needCode {
+		target.Code, _ = Backend().GetInitialSCIDCode(meta.SCID)
+		if filtersUse("var") {
+			// Keys as they were right after install
+			target.Keys = map[string]bool{}
+			install := Backend().GetSCIDVariableHeight(meta.SCID, false)
+			for _, v := range Backend().GetSCIDVariableDetailsAtTopoheight(meta.SCID, install) {
+				if key, ok := v.Key.(string); ok {
+					target.Keys[key] = true
+				}
+			}
+		}
+	}
+	if task.Full {
+		return getFiltered(target)
+	}
+
+	classes := []string{}
+	for _, cl := range strings.Split(meta.Class, ",") {
+		if cl != "" && !slices.Contains(task.Classes, cl) {
+			classes = append(classes, cl)
+		}
+	}
+	taglist := []string{}
+	for _, tag := range strings.Split(meta.Tags, ",") {
+		if tag != "" && (!matchesAny(stale, tag) || matchesList(unchanged, tag)) {
+			taglist = append(taglist, tag)
+		}
+	}
+
+	for _, cl := range task.Classes {
+		if _, ok := Filters[cl]; !ok {
+			continue
+		}
+		isclass, found := matchFilter(target, cl)
+		if isclass && !slices.Contains(classes, cl) {
+			classes = append(classes, cl)
+		}
+		for _, tag := range found {
+			if !slices.Contains(taglist, tag) {
+				taglist = append(taglist, tag)
+			}
+		}
+	}
+	return strings.Join(classes, ","), strings.Join(taglist, ",")
+}
+
+func matchesAny(stale map[string][]*regexp.Regexp, tag string) bool {
+	for _, list := range stale {
+		if matchesList(list, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesList(list []*regexp.Regexp, tag string) bool {
+	for _, re := range list {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -130,8 +130,8 @@ func bigDisplay(n int64, show string, skipreturn bool) {
 		fmt.Print("\033[" + moveup + "A")
 	}
 
-	fmt.Printf(pad + " \n")
-	fmt.Printf("   ______" + pad + " \n")
+	fmt.Print(pad + " \n")
+	fmt.Print("   ______" + pad + " \n")
 	fmt.Printf(" %v \n", lines[0])
 	fmt.Printf(" %v \n", lines[1])
 	fmt.Printf(" %v \n", lines[2])
@@ -140,8 +140,8 @@ func bigDisplay(n int64, show string, skipreturn bool) {
 	fmt.Printf(" %v \n", lines[5])
 
 	if show != "" {
-		fmt.Printf(pad + " \n")
-		fmt.Printf(show)
+		fmt.Print(pad + " \n")
+		fmt.Print(show)
 	}
 }
 
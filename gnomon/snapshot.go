@@ -0,0 +1,386 @@
+package gnomon
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gnomon/daemon"
+	sql "gnomon/db"
+
+	"github.com/deroproject/derohe/rpc"
+)
+
+// Snapshot export and import
+type SnapshotMeta struct {
+	Network       string                         `json:"network"`
+	Height        int64                          `json:"height"`
+	BlockHash     string                         `json:"blockhash"`
+	Filters       map[string]map[string][]string `json:"filters"`
+	SchemaVersion int                            `json:"schemaversion"`
+	SHA256        string                         `json:"sha256"`
+	Created       time.Time                      `json:"created"`
+}
+
+const snapshotMetaName = "snapshot.json"
+
+// Handles the snapshot cli commands, returns true if Gnomon should continue starting
+func runSnapshot(args []string) bool {
+	if Config.Postgres != "" {
+		fmt.Println("Snapshots hold the sqlite db, back up the Postgres database with its own tools")
+		return false
+	}
+	if len(args) == 0 {
+		fmt.Println("Usage: gnomon snapshot export [file] | gnomon snapshot import <file>")
+		return false
+	}
+	switch args[0] {
+	case "export":
+		file := ""
+		if len(args) > 1 {
+			file = args[1]
+		}
+		file, err := ExportSnapshot(file)
+		if err != nil {
+			fmt.Println("Snapshot export failed:", err)
+			return false
+		}
+		fmt.Println("Snapshot saved to", file)
+		return false
+	case "import":
+		if len(args) < 2 {
+			fmt.Println("Usage: gnomon snapshot import <file>")
+			return false
+		}
+		meta, err := ImportSnapshot(args[1])
+		if err != nil {
+			fmt.Println("Snapshot import failed:", err)
+			return false
+		}
+		fmt.Println("Snapshot installed, resuming from height", meta.Height)
+		return true
+	}
+	fmt.Println("Unknown snapshot command:", args[0])
+	return false
+}
+
+func snapshotNetwork() string {
+	return filepath.Base(GetDirectory())
+}
+
+// Settings that configure this node, a snapshot carries the index but never these
+var localSettings = []string{"Endpoints", "Filters", "IndexPolicy", "SpamPolicy", "SpamLevel", "Smoothing", "RamSizeMB"}
+
+// Reads the local settings from the disk db, empty when there is no db yet
+func loadLocalSettings() map[string]string {
+	settings := map[string]string{}
+	db_path, db_name := dbPathAndName()
+	if _, err := os.Stat(filepath.Join(db_path, db_name)); err != nil {
+		return settings
+	}
+	Sqlite, err := sql.NewDiskDB(db_path, db_name)
+	if err != nil {
+		return settings
+	}
+	defer Sqlite.DB.Close()
+	for _, name := range localSettings {
+		settings[name], _ = Sqlite.LoadSetting(name)
+	}
+	return settings
+}
+
+// Overwrites the local settings held by a snapshot db, missing ones are cleared
+func replaceLocalSettings(Sqlite *sql.SqlStore, settings map[string]string) {
+	for _, name := range localSettings {
+		sql.SaveSetting(Sqlite.DB, name, settings[name])
+	}
+}
+
+// Connects to the local endpoints and returns the hash of the block at height
+func snapshotBlockHash(endpoints string, height int64) (string, error) {
+	setEndpoints(endpoints)
+	daemon.AssignConnections(false)
+	daemon.InitEndpoint()
+	result := daemon.GetBlockInfo(rpc.GetBlock_Params{
+		Height: uint64(height),
+	})
+	if !daemon.OK() || result.Block_Header.Hash == "" {
+		return "", fmt.Errorf("could not get block %d from daemon", height)
+	}
+	return result.Block_Header.Hash, nil
+}
+
+// Writes a snapshot of the disk db to file, an empty file name uses gnomon-<network>-<height>.tar.gz
+func ExportSnapshot(file string) (string, error) {
+	Sqlite, err := sql.NewDiskDB(dbPathAndName())
+	if err != nil {
+		return "", err
+	}
+	endpoints, _ := Sqlite.LoadSetting("Endpoints")
+	// Copy first so the snapshot is consistent even if an indexer is running
+	temp, err := os.CreateTemp("", "gnomon-snapshot-*.db")
+	if err != nil {
+		Sqlite.DB.Close()
+		return "", err
+	}
+	temp.Close()
+	os.Remove(temp.Name())
+	defer removeDB(temp.Name())
+	err = Sqlite.VacuumInto(temp.Name())
+	Sqlite.DB.Close()
+	if err != nil {
+		return "", fmt.Errorf("copying db: %v", err)
+	}
+
+	// The metadata describes the copy, the indexer may have moved on since
+	snap, err := sql.NewDiskDB(filepath.Dir(temp.Name()), filepath.Base(temp.Name()))
+	if err != nil {
+		return "", err
+	}
+	meta, err := snapshotMeta(snap, endpoints)
+	// The importing node keeps its own endpoints, policies and filters
+	replaceLocalSettings(snap, nil)
+	snap.DB.Close()
+	if err != nil {
+		return "", err
+	}
+	if meta.SHA256, err = fileSHA256(temp.Name()); err != nil {
+		return "", err
+	}
+
+	if file == "" {
+		file = fmt.Sprintf("gnomon-%s-%d.tar.gz", meta.Network, meta.Height)
+	}
+	if err = writeSnapshot(file, meta, temp.Name()); err != nil {
+		os.Remove(file)
+		return "", err
+	}
+	return file, nil
+}
+
+// Metadata of a copied db, the block hash at its height comes from the daemon
+func snapshotMeta(Sqlite *sql.SqlStore, endpoints string) (meta SnapshotMeta, err error) {
+	height, err := Sqlite.GetLastIndexHeight()
+	if err != nil {
+		return meta, errors.New("nothing indexed yet")
+	}
+	meta = SnapshotMeta{
+		Network:       snapshotNetwork(),
+		Height:        height,
+		SchemaVersion: sql.SchemaVersion,
+		Created:       time.Now().UTC(),
+		Filters:       defaultFilters,
+	}
+	if val, _ := Sqlite.LoadSetting("Filters"); val != "" {
+		json.Unmarshal([]byte(val), &meta.Filters)
+	}
+	meta.BlockHash, err = snapshotBlockHash(endpoints, height)
+	return
+}
+
+func writeSnapshot(file string, meta SnapshotMeta, db_file string) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	metaBytes, _ := json.MarshalIndent(meta, "", "  ")
+	if err = tw.WriteHeader(&tar.Header{Name: snapshotMetaName, Mode: 0600, Size: int64(len(metaBytes)), ModTime: meta.Created}); err != nil {
+		return err
+	}
+	if _, err = tw.Write(metaBytes); err != nil {
+		return err
+	}
+
+	db, err := os.Open(db_file)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	info, err := db.Stat()
+	if err != nil {
+		return err
+	}
+	_, db_name := dbPathAndName()
+	if err = tw.WriteHeader(&tar.Header{Name: db_name, Mode: 0600, Size: info.Size(), ModTime: meta.Created}); err != nil {
+		return err
+	}
+	if _, err = io.Copy(tw, db); err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// Verifies a snapshot and installs it as the disk db keeping the local settings, the current db and its -wal/-shm files are kept as .bak
+func ImportSnapshot(file string) (meta SnapshotMeta, err error) {
+	db_path, db_name := dbPathAndName()
+	if err = os.MkdirAll(db_path, 0700); err != nil {
+		return
+	}
+	temp, err := os.CreateTemp(db_path, "snapshot-*.db")
+	if err != nil {
+		return
+	}
+	defer removeDB(temp.Name())
+	meta, err = readSnapshot(file, temp)
+	temp.Close()
+	if err != nil {
+		return
+	}
+
+	if meta.Network != snapshotNetwork() {
+		return meta, fmt.Errorf("snapshot is for %s, running %s", meta.Network, snapshotNetwork())
+	}
+	// Older snapshots are migrated when opened
+	if meta.SchemaVersion > sql.SchemaVersion {
+		return meta, fmt.Errorf("snapshot schema version %d is newer than %d", meta.SchemaVersion, sql.SchemaVersion)
+	}
+	sum, err := fileSHA256(temp.Name())
+	if err != nil {
+		return
+	}
+	if sum != meta.SHA256 {
+		return meta, errors.New("checksum mismatch")
+	}
+
+	// Check the snapshot against the daemon before replacing anything, the snapshot's
+	// own settings are never trusted
+	local := loadLocalSettings()
+	snap, err := sql.NewDiskDB(db_path, filepath.Base(temp.Name()))
+	if err != nil {
+		return
+	}
+	height, err := snap.GetLastIndexHeight()
+	if err == nil && height != meta.Height {
+		err = fmt.Errorf("snapshot db is at height %d, metadata says %d", height, meta.Height)
+	}
+	if err != nil {
+		snap.DB.Close()
+		return
+	}
+	hash, err := snapshotBlockHash(local["Endpoints"], meta.Height)
+	if err == nil && hash != meta.BlockHash {
+		err = fmt.Errorf("block hash at %d is %s on the daemon, snapshot has %s", meta.Height, hash, meta.BlockHash)
+	}
+	if err != nil {
+		snap.DB.Close()
+		return
+	}
+	replaceLocalSettings(snap, local)
+	snap.DB.Close()
+
+	full_path := filepath.Join(db_path, db_name)
+	if _, err = os.Stat(full_path); err == nil {
+		if err = moveDB(full_path, full_path+".bak"); err != nil {
+			return
+		}
+	}
+	err = moveDB(temp.Name(), full_path)
+	return
+}
+
+// sqlite keeps uncheckpointed writes in the -wal file and its index in -shm
+var dbSidecars = []string{"-wal", "-shm"}
+
+// Renames a db with its sidecar files, stale sidecars at the destination would be
+// replayed into it
+func moveDB(from string, to string) error {
+	for _, suffix := range dbSidecars {
+		if err := os.Remove(to + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	for _, suffix := range dbSidecars {
+		if err := os.Rename(from+suffix, to+suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes a db with its sidecar files
+func removeDB(file string) {
+	os.Remove(file)
+	for _, suffix := range dbSidecars {
+		os.Remove(file + suffix)
+	}
+}
+
+// Extracts the metadata and writes the db to out
+func readSnapshot(file string, out io.Writer) (meta SnapshotMeta, err error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	_, db_name := dbPathAndName()
+	found_meta, found_db := false, false
+	for {
+		var header *tar.Header
+		header, err = tr.Next()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+		switch header.Name {
+		case snapshotMetaName:
+			if err = json.NewDecoder(tr).Decode(&meta); err != nil {
+				return meta, fmt.Errorf("bad metadata: %v", err)
+			}
+			found_meta = true
+		case db_name:
+			if _, err = io.Copy(out, tr); err != nil {
+				return
+			}
+			found_db = true
+		}
+	}
+	if !found_meta || !found_db {
+		err = errors.New("snapshot is missing the metadata or db")
+	}
+	return
+}
+
+func fileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
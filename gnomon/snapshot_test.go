@@ -0,0 +1,109 @@
+package gnomon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sql "gnomon/db"
+)
+
+// The default flags hold strings until the command line is parsed
+func setTestnetFlags(t *testing.T) {
+	saved := Config.CmdFlags
+	Config.CmdFlags = map[string]any{"port": "", "mode": "testnet", "simulator": false}
+	t.Cleanup(func() { Config.CmdFlags = saved })
+}
+
+func testSnapshot(t *testing.T, meta SnapshotMeta, db []byte) string {
+	dir := t.TempDir()
+	db_file := filepath.Join(dir, "snapshot.db")
+	if err := os.WriteFile(db_file, db, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if meta.SHA256 == "" {
+		meta.SHA256, _ = fileSHA256(db_file)
+	}
+	file := filepath.Join(dir, "snapshot.tar.gz")
+	if err := writeSnapshot(file, meta, db_file); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	setTestnetFlags(t)
+	db := []byte("not really a db")
+	meta := SnapshotMeta{Network: "testnet", Height: 1234, BlockHash: "abcd", SchemaVersion: 2, Created: time.Now().UTC().Truncate(time.Second)}
+	var out bytes.Buffer
+	read, err := readSnapshot(testSnapshot(t, meta, db), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), db) {
+		t.Fatalf("db %q", out.Bytes())
+	}
+	if sum := sha256.Sum256(db); read.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("checksum %s", read.SHA256)
+	}
+	if read.Network != meta.Network || read.Height != meta.Height || read.BlockHash != meta.BlockHash ||
+		read.SchemaVersion != meta.SchemaVersion || !read.Created.Equal(meta.Created) {
+		t.Fatalf("meta %+v, want %+v", read, meta)
+	}
+}
+
+// The checks that need no daemon must refuse a snapshot before the local db is touched
+func TestImportSnapshotChecks(t *testing.T) {
+	setTestnetFlags(t)
+	t.Chdir(t.TempDir())
+	db_path, db_name := dbPathAndName()
+	os.MkdirAll(db_path, 0700)
+	existing := filepath.Join(db_path, db_name)
+	os.WriteFile(existing, []byte("local"), 0600)
+
+	valid := SnapshotMeta{Network: snapshotNetwork(), Height: 10, SchemaVersion: sql.SchemaVersion}
+	tests := []struct {
+		name string
+		meta func(SnapshotMeta) SnapshotMeta
+		err  string
+	}{
+		{"network", func(m SnapshotMeta) SnapshotMeta { m.Network = "other"; return m }, "snapshot is for other"},
+		{"schema", func(m SnapshotMeta) SnapshotMeta { m.SchemaVersion++; return m }, "is newer than"},
+		{"checksum", func(m SnapshotMeta) SnapshotMeta { m.SHA256 = strings.Repeat("0", 64); return m }, "checksum mismatch"},
+	}
+	for _, test := range tests {
+		_, err := ImportSnapshot(testSnapshot(t, test.meta(valid), []byte("remote")))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: err %v", test.name, err)
+		}
+		if data, _ := os.ReadFile(existing); string(data) != "local" {
+			t.Fatalf("%s: local db replaced", test.name)
+		}
+	}
+	if _, err := ImportSnapshot(filepath.Join(t.TempDir(), "missing.tar.gz")); err == nil {
+		t.Error("missing file imported")
+	}
+}
+
+func TestReplaceLocalSettings(t *testing.T) {
+	snap, err := sql.NewDiskDB(t.TempDir(), "snap.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.DB.Close()
+	snap.SaveSetting("Endpoints", "remote:10102")
+	snap.SaveSetting("SpamPolicy", `{"rules":{}}`)
+	snap.SaveSetting("completed", "[[0,10]]")
+
+	replaceLocalSettings(snap, map[string]string{"Endpoints": "127.0.0.1:10102"})
+	for name, want := range map[string]string{"Endpoints": "127.0.0.1:10102", "SpamPolicy": "", "completed": "[[0,10]]"} {
+		if val, _ := snap.LoadSetting(name); val != want {
+			t.Errorf("%s = %q, want %q", name, val, want)
+		}
+	}
+}
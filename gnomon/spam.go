@@ -0,0 +1,152 @@
+package gnomon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+
+	"gnomon/daemon"
+	sql "gnomon/db"
+	"gnomon/show"
+	"gnomon/structs"
+
+	"github.com/deroproject/derohe/rpc"
+)
+
+// Spam policy, checked before invokes are indexed
+var spamPolicy = structs.SpamPolicy{}
+var spamMutex sync.RWMutex
+
+// Quarantined txs being re-indexed, these skip the spam checks
+var releasing sync.Map
+
+// Loads the saved policy from settings, the default follows the SpamLevel setting
+func LoadSpamPolicy() {
+	val, _ := Sqlite.LoadSetting("SpamPolicy")
+	policy := structs.SpamPolicy{Rules: map[string]structs.SpamRule{}, MaxRegistrations: 10}
+	if val != "" {
+		json.Unmarshal([]byte(val), &policy)
+	} else if level, _ := strconv.Atoi(Config.SpamLevel); level > 0 {
+		policy.Rules[Hardcoded_SCIDS[0]] = structs.SpamRule{MaxInvokes: level}
+	}
+	SetSpamPolicy(policy)
+}
+
+// Replaces the live policy, takes effect on the next indexed tx
+func SetSpamPolicy(policy structs.SpamPolicy) {
+	if policy.Rules == nil {
+		policy.Rules = map[string]structs.SpamRule{}
+	}
+	spamMutex.Lock()
+	spamPolicy = policy
+	spamMutex.Unlock()
+	if len(policy.Rules) != 0 {
+		show.NewMessage(show.Message{Text: "Spam policy:", Vars: []any{policy.Rules}})
+	}
+}
+
+func GetSpamPolicy() structs.SpamPolicy {
+	spamMutex.RLock()
+	defer spamMutex.RUnlock()
+	return spamPolicy
+}
+
+func spamRule(scid string) (rule structs.SpamRule, ok bool) {
+	spamMutex.RLock()
+	defer spamMutex.RUnlock()
+	rule, ok = spamPolicy.Rules[scid]
+	return
+}
+
+// Registration txs allowed in a block before it is skipped
+func maxRegistrations() int {
+	spamMutex.RLock()
+	defer spamMutex.RUnlock()
+	return spamPolicy.MaxRegistrations
+}
+
+// Returns why an invoke is spam, blank if it isn't, and whether to quarantine it
+func spamCheck(store sql.Store, txid string, fee uint64, scid string, signer string, bheight int64) (reason string, quarantine bool) {
+	rule, ok := spamRule(scid)
+	if !ok || slices.Contains(rule.Allow, signer) {
+		return
+	}
+	if _, ok := releasing.Load(txid); ok {
+		return
+	}
+	if rule.MinFee != 0 && fee < rule.MinFee {
+		return fmt.Sprintf("fee %d below %d", fee, rule.MinFee), rule.Quarantine
+	}
+	// Anonymous signers can't be told apart
+	if rule.MaxInvokes == 0 || signer == "" {
+		return
+	}
+	from := int64(0)
+	if rule.Blocks != 0 {
+		from = bheight - rule.Blocks + 1
+	}
+	if store.CountSignerInvokes(scid, signer, from, bheight) >= rule.MaxInvokes {
+		if rule.Blocks != 0 {
+			return fmt.Sprintf("over %d invokes in %d blocks", rule.MaxInvokes, rule.Blocks), rule.Quarantine
+		}
+		return fmt.Sprintf("over %d invokes", rule.MaxInvokes), rule.Quarantine
+	}
+	return
+}
+
+// Removes invokes past the all time limits that slipped through a concurrent batch
+func sweepSpam() {
+	for scid, rule := range GetSpamPolicy().Rules {
+		if rule.MaxInvokes == 0 || rule.Blocks != 0 {
+			continue
+		}
+		txs := slices.DeleteFunc(Backend().GetInvokesOverLimit(scid, rule.MaxInvokes), func(tx structs.QuarantinedTx) bool {
+			return slices.Contains(rule.Allow, tx.Signer)
+		})
+		if len(txs) == 0 {
+			continue
+		}
+		for i := range txs {
+			txs[i].Reason = fmt.Sprintf("over %d invokes", rule.MaxInvokes)
+		}
+		show.NewMessage(show.Message{Text: "Purging spam:", Vars: []any{scid, len(txs)}})
+		if err := Backend().RemoveInvokes(txs, rule.Quarantine); err != nil {
+			show.NewMessage(show.Message{Text: "Spam purge error:", Err: err})
+			return
+		}
+	}
+}
+
+// Indexes a quarantined invoke as if it passed the spam checks
+func ReleaseQuarantined(txid string) error {
+	if _, err := Backend().GetQuarantinedTx(txid); err != nil {
+		return errors.New("not quarantined: " + txid)
+	}
+	r := daemon.GetTransaction(rpc.GetTransaction_Params{Tx_Hashes: []string{txid}})
+	if len(r.Txs_as_hex) == 0 || len(r.Txs) == 0 {
+		return errors.New("tx not found: " + txid)
+	}
+	tx, err := decodeTx(r.Txs_as_hex[0])
+	if err != nil {
+		return err
+	}
+	releasing.Store(txid, true)
+	defer releasing.Delete(txid)
+	if err := DropQuarantined(txid); err != nil {
+		return err
+	}
+	indexTx(sqlindexer, tx, r.Txs[0].Block_Height, r.Txs[0].Signer)
+	if UseMem {
+		// Released invokes are below the flushed height
+		return Sqlite.MarkChanged(r.Txs[0].Block_Height)
+	}
+	return nil
+}
+
+// Deletes a quarantined invoke for good
+func DropQuarantined(txid string) error {
+	return Backend().DeleteQuarantined(txid)
+}
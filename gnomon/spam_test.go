@@ -0,0 +1,91 @@
+package gnomon
+
+import (
+	"testing"
+
+	sql "gnomon/db"
+	"gnomon/structs"
+
+	"github.com/deroproject/derohe/rpc"
+)
+
+func testInvoke(scid string, txid string, signer string) structs.SCIDToIndexStage {
+	return structs.SCIDToIndexStage{
+		Type:       "invoke",
+		TXHash:     txid,
+		Fsi:        &structs.FastSyncImport{Signer: signer},
+		Params:     rpc.GetSC_Params{SCID: scid},
+		Entrypoint: "Register",
+	}
+}
+
+func TestSpamCheck(t *testing.T) {
+	store := sql.NewMemStore()
+	store.StoreSCIDInvoke(testInvoke("sc", "tx1", "alice"), 100)
+	store.StoreSCIDInvoke(testInvoke("sc", "tx2", "alice"), 150)
+
+	tests := []struct {
+		name       string
+		rule       structs.SpamRule
+		signer     string
+		fee        uint64
+		height     int64
+		reason     string
+		quarantine bool
+	}{
+		{"under limit", structs.SpamRule{MaxInvokes: 3}, "alice", 0, 160, "", false},
+		{"all time limit", structs.SpamRule{MaxInvokes: 2}, "alice", 0, 160, "over 2 invokes", false},
+		{"other signer", structs.SpamRule{MaxInvokes: 2}, "bob", 0, 160, "", false},
+		{"allowed signer", structs.SpamRule{MaxInvokes: 2, Allow: []string{"alice"}}, "alice", 0, 160, "", false},
+		{"anonymous signer", structs.SpamRule{MaxInvokes: 1}, "", 0, 160, "", false},
+		{"window excludes older", structs.SpamRule{MaxInvokes: 2, Blocks: 20}, "alice", 0, 160, "", false},
+		{"window limit", structs.SpamRule{MaxInvokes: 1, Blocks: 20}, "alice", 0, 160, "over 1 invokes in 20 blocks", false},
+		{"fee below minimum", structs.SpamRule{MinFee: 10, Quarantine: true}, "bob", 5, 160, "fee 5 below 10", true},
+		{"fee at minimum", structs.SpamRule{MinFee: 10}, "bob", 10, 160, "", false},
+	}
+	for _, test := range tests {
+		SetSpamPolicy(structs.SpamPolicy{Rules: map[string]structs.SpamRule{"sc": test.rule}})
+		reason, quarantine := spamCheck(store, "tx3", test.fee, "sc", test.signer, test.height)
+		if reason != test.reason || quarantine != test.quarantine {
+			t.Errorf("%s: reason %q quarantine %v", test.name, reason, quarantine)
+		}
+		if reason, _ := spamCheck(store, "tx3", test.fee, "other", test.signer, test.height); reason != "" {
+			t.Errorf("%s: SCID without a rule flagged: %q", test.name, reason)
+		}
+	}
+	SetSpamPolicy(structs.SpamPolicy{})
+}
+
+// Invokes of a concurrent batch can all pass the check, the sweep removes the ones past the limit
+func TestSweepSpam(t *testing.T) {
+	disk, err := sql.NewDiskDB(t.TempDir(), "test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := Sqlite
+	Sqlite = disk
+	t.Cleanup(func() {
+		Sqlite = saved
+		disk.DB.Close()
+		SetSpamPolicy(structs.SpamPolicy{})
+	})
+
+	for i, signer := range []string{"alice", "alice", "alice", "bob", "carol", "carol"} {
+		disk.StoreSCIDInvoke(testInvoke("sc", "tx"+string(rune('a'+i)), signer), int64(100+i))
+	}
+	SetSpamPolicy(structs.SpamPolicy{Rules: map[string]structs.SpamRule{
+		"sc": {MaxInvokes: 1, Quarantine: true, Allow: []string{"carol"}},
+	}})
+	sweepSpam()
+
+	quarantined := disk.GetQuarantined("sc")
+	if len(quarantined) != 2 || quarantined[0].TXID != "txb" || quarantined[1].TXID != "txc" {
+		t.Fatalf("quarantined %+v", quarantined)
+	}
+	if quarantined[0].Reason != "over 1 invokes" {
+		t.Fatalf("reason %q", quarantined[0].Reason)
+	}
+	if n := disk.CountSignerInvokes("sc", "carol", 0, 200); n != 2 {
+		t.Fatalf("carol has %d invokes, allowed signers are kept", n)
+	}
+}
@@ -52,3 +52,210 @@ type State struct {
 	}
 	sync.Mutex
 }
+
+// Selective indexing rules, keyed by "scid", "class", "owner" (deployer) or "entrypoint"
+type IndexPolicy struct {
+	Include map[string][]string `json:"include"`
+	Exclude map[string][]string `json:"exclude"`
+}
+
+var IndexPolicyFields = []string{"scid", "class", "owner", "entrypoint"}
+
+type SCMeta struct {
+	SCID   string
+	Owner  string
+	Height int64
+	Class  string
+	Tags   string
+}
+
+type Fingerprint struct {
+	SCID     string `json:"scid"`
+	Height   int64  `json:"height"`
+	CodeHash string `json:"codehash"`
+	SimHash  string `json:"simhash"`
+	Family   string `json:"family"`
+}
+
+type CodeVersion struct {
+	Height   int64  `json:"height"`
+	TXID     string `json:"txid"`
+	CodeHash string `json:"codehash"`
+	Code     string `json:"code,omitempty"`
+}
+
+// Contracts having a class or tag
+type MetaCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type CodeFamily struct {
+	Family      string `json:"family"`
+	Count       int    `json:"count"`
+	Variants    int    `json:"variants"` // distinct normalized code
+	FirstHeight int64  `json:"first_height"`
+}
+
+// Progress of a background reclassification
+type ReclassifyStatus struct {
+	Running   bool     `json:"running"`
+	Classes   []string `json:"classes"`
+	Processed int      `json:"processed"`
+	Total     int      `json:"total"`
+}
+
+// A full-text search match, field is name, descr, code or var (with its key)
+type SearchResult struct {
+	SCID    string  `json:"scid"`
+	Name    string  `json:"name"`
+	Field   string  `json:"field"`
+	Key     string  `json:"key,omitempty"`
+	Height  int64   `json:"height"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+// Size of a db table, Estimated when only the stored values could be measured
+type TableStats struct {
+	Name      string `json:"name"`
+	Rows      int64  `json:"rows"`
+	Bytes     int64  `json:"bytes"`
+	Estimated bool   `json:"estimated"`
+}
+
+// Memory use of the indexer in MB, the db is only counted in memory mode
+type MemoryUsage struct {
+	Mode         string `json:"mode"`
+	LimitMB      int64  `json:"limit_mb"`
+	DBMB         int64  `json:"db_mb"`
+	FreePagesMB  int64  `json:"free_pages_mb"`
+	HeapMB       int64  `json:"heap_mb"`
+	UsedMB       int64  `json:"used_mb"`
+	HeadroomMB   int64  `json:"headroom_mb"`
+	SystemFreeMB int64  `json:"system_free_mb"`
+}
+
+// A TELA INDEX contract, the entry point of an app
+type TelaIndex struct {
+	SCID        string `json:"scid"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	IconURL     string `json:"icon_url"`
+	DURL        string `json:"durl"`
+	Owner       string `json:"owner"`
+	Versions    int    `json:"versions"`
+}
+
+// A file of a TELA app, stored in a DOC contract
+type TelaDoc struct {
+	SCID     string `json:"scid"`
+	Path     string `json:"path"` // subDir and nameHdr
+	DocType  string `json:"doc_type"`
+	Owner    string `json:"owner"`
+	Verified bool   `json:"verified"` // content signed by the DOC owner
+	Error    string `json:"error,omitempty"`
+	Content  []byte `json:"-"`
+}
+
+// One change of a variable, Deleted when the key was removed
+type VariableChange struct {
+	Height  int64  `json:"height"`
+	TXID    string `json:"txid"`
+	Value   any    `json:"value"`
+	Deleted bool   `json:"deleted"`
+}
+
+// A G45 or NFA contract with its metadata and current owner
+type Asset struct {
+	SCID        string         `json:"scid"`
+	Standard    string         `json:"standard"` // eg. G45-AT, ART-NFA-MS1
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Image       string         `json:"image"`
+	Collection  string         `json:"collection"`
+	Creator     string         `json:"creator"`
+	Owner       string         `json:"owner"`
+	Height      int64          `json:"height"`
+	Metadata    map[string]any `json:"metadata,omitempty"`
+}
+
+// An ownership change of an asset
+type AssetTransfer struct {
+	Height     int64  `json:"height"`
+	TXID       string `json:"txid"`
+	Entrypoint string `json:"entrypoint"`
+	Signer     string `json:"signer"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+}
+
+// Order state of a StartSwap contract
+type SwapOrder struct {
+	SCID          string `json:"scid"`
+	Height        int64  `json:"height"` // last install or invoke
+	Maker         string `json:"maker"`
+	OfferAsset    string `json:"offer_asset"`
+	OfferAmount   uint64 `json:"offer_amount"`
+	RequestAsset  string `json:"request_asset"`
+	RequestAmount uint64 `json:"request_amount"`
+	Expiry        int64  `json:"expiry"`
+	Status        string `json:"status"` // open, filled, cancelled or expired
+}
+
+// A name service registration
+type Name struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Height  int64  `json:"height"` // registered at
+	TXID    string `json:"txid"`
+}
+
+// A deploy or invoke signed by an address
+type Activity struct {
+	SCID       string `json:"scid"`
+	Type       string `json:"type"` // install or invoke
+	Height     int64  `json:"height"`
+	TXID       string `json:"txid"`
+	Entrypoint string `json:"entrypoint"`
+}
+
+// Spam limits for the invokes of one SCID
+type SpamRule struct {
+	MaxInvokes int      `json:"max_invokes"` // per signer within Blocks, 0 for no limit
+	Blocks     int64    `json:"blocks"`      // window for MaxInvokes, 0 for all time
+	MinFee     uint64   `json:"min_fee"`
+	Allow      []string `json:"allow"`      // signers never treated as spam
+	Quarantine bool     `json:"quarantine"` // keep flagged invokes for review instead of dropping them
+}
+
+type SpamPolicy struct {
+	Rules            map[string]SpamRule `json:"rules"`             // by SCID
+	MaxRegistrations int                 `json:"max_registrations"` // registration txs in a block before it is skipped, 0 for no limit
+}
+
+// An invoke held back by a spam rule
+type QuarantinedTx struct {
+	TXID       string `json:"txid"`
+	SCID       string `json:"scid"`
+	Signer     string `json:"signer"`
+	Height     int64  `json:"height"`
+	Entrypoint string `json:"entrypoint"`
+	Reason     string `json:"reason"`
+}
+
+// An install that failed, with the fee burnt attempting it
+type InvalidDeploy struct {
+	SCID   string `json:"scid"`
+	Signer string `json:"signer"`
+	Height int64  `json:"height"`
+	Fee    uint64 `json:"fee"`
+}
+
+// Value of a variable at a height, nil when the key isn't set
+type VariablePoint struct {
+	Height  int64  `json:"height"`
+	Value   any    `json:"value"`
+	TXID    string `json:"txid,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
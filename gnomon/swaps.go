@@ -0,0 +1,215 @@
+package gnomon
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+
+	sql "gnomon/db"
+	"gnomon/show"
+	"gnomon/structs"
+
+	"github.com/deroproject/derohe/dvm"
+)
+
+// Swap orders of SwapClass contracts
+
+// Class of the swap contracts
+var SwapClass = "swaps"
+
+// Asset id of DERO
+const deroAsset = "0000000000000000000000000000000000000000000000000000000000000000"
+
+func isSwap(class string) bool {
+	return slices.Contains(strings.Split(class, ","), SwapClass)
+}
+
+// Variable keys StartSwap stores the order fields under
+type swapLayout struct {
+	Maker         string
+	OfferAsset    string
+	OfferAmount   string
+	RequestAsset  string
+	RequestAmount string
+	Expiry        string
+	OfferDero     bool            // the offer is the DERO sent with StartSwap
+	MakerOnly     map[string]bool // entrypoints checking the maker, closing through them cancels
+}
+
+// Reads the swap layout from the StartSwap function of the contract code
+func swapLayoutOf(code string) (layout swapLayout, err error) {
+	sc, _, err := dvm.ParseSmartContract(code)
+	if err != nil {
+		return
+	}
+	start, ok := sc.Functions["StartSwap"]
+	if !ok {
+		return layout, errors.New("no StartSwap function")
+	}
+	params := map[string]dvm.Vtype{}
+	for _, p := range start.Params {
+		params[p.Name] = p.Type
+	}
+	// ASSETVALUE(param) makes the param the offered asset
+	offered := ""
+	for _, n := range start.LineNumbers {
+		line := start.Lines[n]
+		for i := range line {
+			if line[i] == "ASSETVALUE" && i+2 < len(line) {
+				if _, ok := params[line[i+2]]; ok {
+					offered = line[i+2]
+				}
+			}
+		}
+	}
+	set := func(field *string, key string) {
+		if *field == "" {
+			*field = key
+		}
+	}
+	for _, n := range start.LineNumbers {
+		line := start.Lines[n]
+		if len(line) < 6 || line[0] != "STORE" || line[3] != "," {
+			continue
+		}
+		// Computed keys aren't order fields
+		key, err := strconv.Unquote(line[2])
+		if err != nil {
+			continue
+		}
+		value := line[4 : len(line)-1]
+		switch {
+		case slices.Contains(value, "SIGNER"):
+			set(&layout.Maker, key)
+		case slices.Contains(value, "DEROVALUE"):
+			set(&layout.OfferAmount, key)
+			layout.OfferDero = true
+		case slices.Contains(value, "ASSETVALUE"):
+			set(&layout.OfferAmount, key)
+		case slices.Contains(value, "BLOCK_TOPOHEIGHT") || slices.Contains(value, "BLOCK_TIMESTAMP"):
+			set(&layout.Expiry, key)
+		case len(value) != 1:
+		case value[0] == offered:
+			set(&layout.OfferAsset, key)
+		case params[value[0]] == dvm.String:
+			set(&layout.RequestAsset, key)
+		case params[value[0]] == dvm.Uint64:
+			set(&layout.RequestAmount, key)
+		}
+	}
+	if layout.Maker == "" {
+		return layout, errors.New("StartSwap stores no maker")
+	}
+
+	// Entrypoints comparing the signer with the stored maker
+	maker := strconv.Quote(layout.Maker)
+	layout.MakerOnly = map[string]bool{}
+	for name, f := range sc.Functions {
+		for _, n := range f.LineNumbers {
+			line := f.Lines[n]
+			if i := slices.Index(line, maker); i > 1 && line[i-2] == "LOAD" && slices.Contains(line, "SIGNER") {
+				layout.MakerOnly[name] = true
+			}
+		}
+	}
+	return
+}
+
+// Decodes the order from the contract variables, open is false while StartSwap's
+// maker key isn't stored
+func decodeSwap(scid string, height int64, layout swapLayout, variables []*structs.SCIDVariable) (order structs.SwapOrder, open bool) {
+	vars := map[string]any{}
+	for _, v := range variables {
+		if key, ok := v.Key.(string); ok {
+			vars[key] = v.Value
+		}
+	}
+	text := func(key string) string {
+		switch v := vars[key].(type) {
+		case string:
+			return v
+		case uint64:
+			return strconv.FormatUint(v, 10)
+		}
+		return ""
+	}
+	number := func(key string) uint64 {
+		switch v := vars[key].(type) {
+		case uint64:
+			return v
+		case string:
+			n, _ := strconv.ParseUint(v, 10, 64)
+			return n
+		}
+		return 0
+	}
+	asset := func(key string) string {
+		if a := text(key); a != "" && strings.Trim(a, "0") != "" {
+			return a
+		}
+		return deroAsset
+	}
+	if _, ok := vars[layout.Maker]; !ok {
+		return order, false
+	}
+
+	order = structs.SwapOrder{
+		SCID:          scid,
+		Height:        height,
+		Maker:         text(layout.Maker),
+		OfferAmount:   number(layout.OfferAmount),
+		RequestAmount: number(layout.RequestAmount),
+		Expiry:        int64(number(layout.Expiry)),
+		Status:        "open",
+	}
+	if layout.OfferAsset != "" {
+		order.OfferAsset = asset(layout.OfferAsset)
+	} else if layout.OfferDero {
+		order.OfferAsset = deroAsset
+	}
+	if layout.RequestAsset != "" {
+		order.RequestAsset = asset(layout.RequestAsset)
+	}
+	return order, true
+}
+
+// Decodes and stores the order of a swap contract. Once the maker key is gone the
+// invoked entrypoint closed the order, cancelled when only the maker can call it.
+func updateSwap(store sql.Store, scid string, height int64, code string, entrypoint string, variables []*structs.SCIDVariable) error {
+	layout, err := swapLayoutOf(code)
+	if err != nil {
+		return nil
+	}
+	order, open := decodeSwap(scid, height, layout, variables)
+	if open {
+		return store.StoreSwap(order)
+	}
+	order, err = store.GetSwap(scid)
+	if err != nil || entrypoint == "" || (order.Status != "open" && order.Status != "expired") {
+		return nil
+	}
+	order.Height = height
+	order.Status = "filled"
+	if layout.MakerOnly[entrypoint] {
+		order.Status = "cancelled"
+	}
+	return store.StoreSwap(order)
+}
+
+// Decodes swap contracts indexed before orders were kept
+func swapsMissing() {
+	store := Backend()
+	scids := store.GetUndecodedSwaps()
+	for _, scid := range scids {
+		height := store.GetSCIDVariableHeight(scid, true)
+		code, _ := store.GetSCCodeAtTopoheight(scid, height)
+		if err := updateSwap(store, scid, height, code, "", store.GetSCIDVariableDetailsAtTopoheight(scid, height)); err != nil {
+			show.NewMessage(show.Message{Text: "Swap order error:", Err: err})
+			return
+		}
+	}
+	if len(scids) != 0 {
+		show.NewMessage(show.Message{Text: "Decoded swap orders:", Vars: []any{len(scids)}})
+	}
+}
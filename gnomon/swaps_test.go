@@ -0,0 +1,99 @@
+package gnomon
+
+import (
+	"testing"
+
+	sql "gnomon/db"
+	"gnomon/structs"
+)
+
+const testSwapCode = `Function StartSwap(want String, price Uint64, blocks Uint64) Uint64
+10 STORE("seller", SIGNER())
+30 STORE("amount", DEROVALUE())
+40 STORE("want", want)
+50 STORE("price", price)
+60 STORE("until", BLOCK_TOPOHEIGHT() + blocks)
+70 RETURN 0
+End Function
+
+Function Swap() Uint64
+10 SEND_DERO_TO_ADDRESS(SIGNER(), LOAD("amount"))
+20 DELETE("seller")
+30 RETURN 0
+End Function
+
+Function Cancel() Uint64
+10 IF LOAD("seller") != SIGNER() THEN GOTO 40
+20 DELETE("seller")
+30 RETURN 0
+40 RETURN 1
+End Function`
+
+func TestSwapLayout(t *testing.T) {
+	layout, err := swapLayoutOf(testSwapCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := swapLayout{Maker: "seller", OfferAmount: "amount", RequestAsset: "want", RequestAmount: "price", Expiry: "until", OfferDero: true}
+	if layout.Maker != want.Maker || layout.OfferAmount != want.OfferAmount || layout.RequestAsset != want.RequestAsset ||
+		layout.RequestAmount != want.RequestAmount || layout.Expiry != want.Expiry || layout.OfferDero != want.OfferDero || layout.OfferAsset != "" {
+		t.Fatalf("layout %+v", layout)
+	}
+	if !layout.MakerOnly["Cancel"] || layout.MakerOnly["Swap"] {
+		t.Fatalf("maker only entrypoints %v", layout.MakerOnly)
+	}
+	if _, err := swapLayoutOf(`Function Initialize() Uint64
+10 RETURN 0
+End Function`); err == nil {
+		t.Fatal("contract without StartSwap has a layout")
+	}
+}
+
+func TestDecodeSwap(t *testing.T) {
+	layout, _ := swapLayoutOf(testSwapCode)
+	tests := []struct {
+		name      string
+		variables map[string]any
+		open      bool
+		order     structs.SwapOrder
+	}{
+		{"not started", map[string]any{"C": "code"}, false, structs.SwapOrder{}},
+		{
+			"open",
+			map[string]any{"seller": "alice", "amount": uint64(5), "want": "abcd", "price": uint64(7), "until": uint64(900)},
+			true,
+			structs.SwapOrder{SCID: "sc", Height: 10, Maker: "alice", OfferAsset: deroAsset, OfferAmount: 5, RequestAsset: "abcd", RequestAmount: 7, Expiry: 900, Status: "open"},
+		},
+		{
+			"dero requested",
+			map[string]any{"seller": "alice", "want": "0000", "price": uint64(7)},
+			true,
+			structs.SwapOrder{SCID: "sc", Height: 10, Maker: "alice", OfferAsset: deroAsset, RequestAsset: deroAsset, RequestAmount: 7, Status: "open"},
+		},
+	}
+	for _, test := range tests {
+		var variables []*structs.SCIDVariable
+		for k, v := range test.variables {
+			variables = append(variables, &structs.SCIDVariable{Key: k, Value: v})
+		}
+		order, open := decodeSwap("sc", 10, layout, variables)
+		if open != test.open || order != test.order {
+			t.Errorf("%s: open %v order %+v", test.name, open, order)
+		}
+	}
+}
+
+// Removing the maker closes the order, cancelled through an entrypoint only the maker can call
+func TestUpdateSwapCloses(t *testing.T) {
+	open := []*structs.SCIDVariable{{Key: "seller", Value: "alice"}, {Key: "price", Value: uint64(7)}}
+	closed := []*structs.SCIDVariable{{Key: "price", Value: uint64(7)}}
+	for entrypoint, status := range map[string]string{"Swap": "filled", "Cancel": "cancelled"} {
+		store := sql.NewMemStore()
+		store.StoreLastIndexHeight(30)
+		updateSwap(store, "sc", 10, testSwapCode, "StartSwap", open)
+		updateSwap(store, "sc", 20, testSwapCode, entrypoint, closed)
+		if order, err := store.GetSwap("sc"); err != nil || order.Status != status || order.Height != 20 || order.Maker != "alice" {
+			t.Errorf("%s: order %+v err %v", entrypoint, order, err)
+		}
+	}
+}
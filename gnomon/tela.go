@@ -0,0 +1,210 @@
+package gnomon
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gnomon/structs"
+
+	"github.com/deroproject/derohe/walletapi"
+)
+
+// TELA apps, an INDEX contract lists its DOC contracts in its code
+var docRefRegex = regexp.MustCompile(`STORE\(\s*"DOC(\d+)"\s*,\s*"([0-9a-fA-F]{64})"\s*\)`)
+
+// Lists the indexed TELA INDEX contracts, search matches the name, description, dURL or scid
+func TelaIndexes(search string) (indexes []structs.TelaIndex) {
+	height, _ := Backend().GetLastIndexHeight()
+	search = strings.ToLower(search)
+	for _, scid := range Backend().GetSCIDsByTags([]string{"telaVersion"}) {
+		vars := scStringVars(scid, height)
+		if _, ok := vars["docVersion"]; ok {
+			continue
+		}
+		index := structs.TelaIndex{
+			SCID:        scid,
+			Name:        vars["nameHdr"],
+			Description: vars["descrHdr"],
+			IconURL:     vars["iconURLHdr"],
+			DURL:        vars["dURL"],
+		}
+		if search != "" && !strings.Contains(strings.ToLower(index.Name+" "+index.Description+" "+index.DURL+" "+index.SCID), search) {
+			continue
+		}
+		index.Owner, _ = Backend().GetSCOwnerAndClass(scid)
+		index.Versions = len(Backend().GetSCCodeHistory(scid))
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return strings.ToLower(indexes[i].Name) < strings.ToLower(indexes[j].Name)
+	})
+	return
+}
+
+// String and uint64 variables of an SC at a height as text
+func scStringVars(scid string, height int64) map[string]string {
+	vars := map[string]string{}
+	for _, v := range Backend().GetSCIDVariableDetailsAtTopoheight(scid, height) {
+		key, ok := v.Key.(string)
+		if !ok {
+			continue
+		}
+		switch value := v.Value.(type) {
+		case string:
+			vars[key] = value
+		case uint64:
+			vars[key] = strconv.FormatUint(value, 10)
+		}
+	}
+	return vars
+}
+
+// DOC scids referenced by INDEX code, in DOC number order
+func TelaDocRefs(code string) (scids []string) {
+	type ref struct {
+		n    int
+		scid string
+	}
+	refs := []ref{}
+	seen := map[string]bool{}
+	for _, m := range docRefRegex.FindAllStringSubmatch(stripComments(code), -1) {
+		n, _ := strconv.Atoi(m[1])
+		scid := strings.ToLower(m[2])
+		if !seen[scid] {
+			seen[scid] = true
+			refs = append(refs, ref{n, scid})
+		}
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].n < refs[j].n })
+	for _, r := range refs {
+		scids = append(scids, r.scid)
+	}
+	return
+}
+
+// Resolves the files of an INDEX as they were at height, 0 for the latest version
+func AssembleTela(scid string, height int64) (docs []structs.TelaDoc, err error) {
+	if height == 0 {
+		height, _ = Backend().GetLastIndexHeight()
+	}
+	code, err := Backend().GetSCCodeAtTopoheight(scid, height)
+	if err != nil || code == "" {
+		if code, err = Backend().GetInitialSCIDCode(scid); err != nil {
+			return nil, fmt.Errorf("no code indexed for %s", scid)
+		}
+	}
+	refs := TelaDocRefs(code)
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("%s has no DOC references", scid)
+	}
+	for _, doc := range refs {
+		docs = append(docs, telaDoc(doc, height))
+	}
+	return
+}
+
+// Fetches, verifies and unpacks one DOC
+func telaDoc(scid string, height int64) (doc structs.TelaDoc) {
+	doc.SCID = scid
+	code, err := Backend().GetInitialSCIDCode(scid)
+	if err != nil || code == "" {
+		doc.Error = "DOC not indexed"
+		return
+	}
+	vars := scStringVars(scid, height)
+	name := vars["nameHdr"]
+	if name == "" {
+		doc.Error = "DOC has no nameHdr"
+		return
+	}
+	doc.Path = path.Clean("/" + path.Join(vars["subDir"], name))[1:]
+	doc.DocType = vars["docType"]
+	doc.Owner, _ = Backend().GetSCOwnerAndClass(scid)
+
+	content, ok := docContent(code)
+	if !ok {
+		doc.Error = "no content block"
+		return
+	}
+	if err := verifyDoc(doc.Owner, vars["fileCheckC"], vars["fileCheckS"], content); err != nil {
+		doc.Error = err.Error()
+	} else {
+		doc.Verified = true
+	}
+
+	doc.Content = []byte(content)
+	if strings.HasSuffix(doc.Path, ".gz") {
+		unpacked, err := gunzipBase64(content)
+		if err != nil {
+			doc.Error = "unpacking: " + err.Error()
+			return
+		}
+		doc.Path = strings.TrimSuffix(doc.Path, ".gz")
+		doc.Content = unpacked
+	}
+	return
+}
+
+// Content of the comment block after the last function of a DOC
+func docContent(code string) (string, bool) {
+	last := strings.LastIndex(code, "End Function")
+	if last == -1 {
+		return "", false
+	}
+	rest := code[last+len("End Function"):]
+	start := strings.Index(rest, "/*")
+	end := strings.LastIndex(rest, "*/")
+	if start == -1 || end < start+2 {
+		return "", false
+	}
+	content := rest[start+2 : end]
+	content = strings.TrimPrefix(strings.TrimPrefix(content, "\r"), "\n")
+	content = strings.TrimSuffix(strings.TrimSuffix(content, "\n"), "\r")
+	return content, true
+}
+
+// Checks the content was signed by the DOC owner
+func verifyDoc(owner string, c string, s string, content string) error {
+	if !strings.HasPrefix(owner, "dero") && !strings.HasPrefix(owner, "deto") {
+		return fmt.Errorf("owner unknown")
+	}
+	if c == "" || s == "" {
+		return fmt.Errorf("not signed")
+	}
+	block := pem.EncodeToMemory(&pem.Block{
+		Type:    "DERO SIGNED MESSAGE",
+		Headers: map[string]string{"Address": owner, "C": c, "S": s},
+		Bytes:   []byte(content),
+	})
+	// CheckSignature doesn't use the wallet, an empty one is enough
+	signer, _, err := new(walletapi.Wallet_Memory).CheckSignature(block)
+	if err != nil {
+		return err
+	}
+	if signer.String() != owner {
+		return fmt.Errorf("signed by %s", signer.String())
+	}
+	return nil
+}
+
+func gunzipBase64(content string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(content))
+	if err != nil {
+		return nil, err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
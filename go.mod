@@ -8,6 +8,7 @@ require gnomon v0.0.0-00010101000000-000000000000
 
 require (
 	github.com/coder/websocket v1.8.14 // indirect
+	github.com/lib/pq v1.10.9 // indirect
 	github.com/ybbus/jsonrpc v2.1.2+incompatible // indirect
 	golang.org/x/time v0.14.0 // indirect
 )
@@ -14,7 +14,6 @@ import (
 	"math"
 	"os"
 	"path/filepath"
-	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -256,9 +255,27 @@ func action(text string) {
 	case "unmute":
 		gnomon_updates_muted = false
 	case "indexes":
-		getTelaIndexes()
+		tela("list")
+	case "tela":
+		tela(value)
+	case "assets":
+		assets(value)
+	case "swaps":
+		swaps(value)
+	case "names":
+		names(value)
+	case "quarantine":
+		quarantine(value)
 	case "search":
-		searchFiltered()
+		if mode, query, _ := strings.Cut(value, " "); mode == "text" {
+			searchText(strings.Trim(query, `" `))
+		} else {
+			searchFiltered()
+		}
+	case "codehistory":
+		showCodeHistory(value)
+	case "timeline":
+		showTimeline(value)
 	// XSWD
 	case "xswd":
 		toggleXSWD()
@@ -320,7 +337,19 @@ resume
 mute - Don't receive any Gnomon updates
 unmute
 search - Search filtered classes and tags
+search text - Search contract names, descriptions, code and string variables, eg. search text "dragon egg"
+codehistory - Show code versions of an SC and diff them, eg. codehistory <scid>
+timeline - Sparkline of a uint64 variable over a height range, eg. timeline <scid> <key>
 indexes - Shows Tela indexes
+tela list - List Tela apps and their versions, eg. tela list <search>
+tela serve - Assemble and serve a Tela app locally, eg. tela serve <scid> [height]
+tela stop - Stop serving Tela apps, eg. tela stop [scid]
+assets mine - G45 and NFA assets owned by the open wallet
+assets collection - Assets of a collection, eg. assets collection <scid>
+assets history - Ownership changes of an asset, eg. assets history <scid>
+swaps - List swap orders, eg. swaps open <asset>, or inspect one with swaps <scid>
+names - Resolve a registered name or list an address's names, blank for your own
+quarantine - Review invokes held back by the spam policy, optionally for one scid
 
 -XSWD-
 xswd - Start / stop toggle for XSWD server
@@ -349,6 +378,9 @@ Gnomon advanced controls / options
 [13] Max ram usage
 [14] Show Gnomon status
 [15] Launch web api
+[16] Selective indexing policy
+[17] Spam policy
+[18] Database maintenance
 
 [0]  Return
 
@@ -380,6 +412,12 @@ Gnomon advanced controls / options
 		showGnomonStatus()
 	case "15":
 		startGnomonWebAPI()
+	case "16":
+		updateGnomonIndexPolicy()
+	case "17":
+		updateGnomonSpamPolicy()
+	case "18":
+		gnomonDBMaintenance()
 	}
 	options()
 }
@@ -708,6 +746,12 @@ func showGnomonStatus() {
 	fmt.Println("Using memory (gnomon):", gnomon.UseMem)
 	fmt.Println("Max memory usage (gnomon):", gnomon.RamSizeMB, "MB")
 	fmt.Println("Max memory usage (saved):", getGnomonMaxMem(), "MB")
+	usage := gnomon.GetMemoryUsage()
+	fmt.Println("Memory in use (gnomon):", usage.UsedMB, "MB, db", usage.DBMB, "MB, heap", usage.HeapMB, "MB")
+	fmt.Println("Memory headroom (gnomon):", usage.HeadroomMB, "MB")
+	if usage.SystemFreeMB != 0 {
+		fmt.Println("System memory available:", usage.SystemFreeMB, "MB")
+	}
 	db_name := fmt.Sprintf("sql%s.db", "GNOMON")
 	db_path := filepath.Join(GConfig.CmdFlags["mode"].(string), "gnomondb")
 	fmt.Println("File location:", filepath.Join(db_path, db_name))
@@ -730,6 +774,12 @@ func showGnomonStatus() {
 			fmt.Println("Filters applied:", f)
 		}
 	}
+	if status := gnomon.GetReclassifyStatus(); status.Running {
+		fmt.Println("Reclassifying:", status.Classes, status.Processed, "/", status.Total)
+	}
+	for _, r := range gnomon.BackfillStatus() {
+		fmt.Println("Backfilling:", r[0], "-", r[2], "at", r[1])
+	}
 	val, _ := Sqlite.LoadSetting("completed")
 	if val != "" {
 		var c [][2]int
@@ -762,12 +812,8 @@ func getGnomonDiskDB() (Sqlite *sql.SqlStore) {
 	// gnomon uses dbPathAndName()
 	db_name := fmt.Sprintf("sql%s.db", "GNOMON")
 	db_path := filepath.Join(GConfig.CmdFlags["mode"].(string), "gnomondb")
+	// Opening creates or migrates the tables
 	Sqlite, _ = sql.NewDiskDB(db_path, db_name)
-	var exists int
-	Sqlite.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name='state');").Scan(&exists)
-	if exists == 0 {
-		sql.CreateTables(Sqlite.DB)
-	}
 	return
 }
 
@@ -792,6 +838,11 @@ func updateGnomonMem() {
 	if meminmb != "" {
 		fmt.Println("Current setting in Mb:", meminmb)
 	}
+	usage := gnomon.GetMemoryUsage()
+	fmt.Println("Currently in use in Mb:", usage.UsedMB, "db:", usage.DBMB, "heap:", usage.HeapMB)
+	if usage.SystemFreeMB != 0 {
+		fmt.Println("System memory available in Mb:", usage.SystemFreeMB)
+	}
 	meminmb = getText("Enter system memory to allow Gnomon to use in Megabytes.")
 	fmt.Println("Saving value in Mb:", meminmb)
 	Sqlite.SaveSetting("RamSizeMB", meminmb)
@@ -802,6 +853,19 @@ func updateGnomonMem() {
 	}
 }
 
+// Runs the gnomon db commands against the db file
+func gnomonDBMaintenance() {
+	if !gnomon.Started {
+		println("Gnomon not started")
+		return
+	}
+	gnomon.RunDB(nil)
+	command := getText(`Enter a db command, eg. "stats" or "prune --keep 100000 --class token", blank to return:`)
+	if command != "" {
+		gnomon.RunDB(strings.Fields(command))
+	}
+}
+
 // Gets saved connections if available
 func getGnomonConnections() (endpoints []daemon.Connection) {
 	Sqlite := getGnomonDiskDB()
@@ -864,7 +928,12 @@ func startGnomonWebAPI() {
 // Gnomon filters done before startup for now
 func reclassify() {
 	if gnomon.Started {
-		println("Restart wallet and run before starting Gnomon.")
+		full := getText("Re-evaluate every class instead of only those with changed filters? (y/n)") == "y"
+		if gnomon.StartReclassify(full) {
+			fmt.Println("Reclassifying in the background.")
+		} else {
+			fmt.Println("Nothing to reclassify or a reclassification is already running.")
+		}
 		return
 	}
 	autostart := false
@@ -923,6 +992,49 @@ func updateGnomonFilters() {
 	Sqlite.SaveSetting("Filters", val)
 }
 
+// Edit which SCIDs, classes, owners or entrypoints Gnomon indexes
+func updateGnomonIndexPolicy() {
+	Sqlite := getGnomonDiskDB()
+	defer Sqlite.DB.Close()
+	policy := structs.IndexPolicy{}
+	if getText("Reset to index everything? (y/n)") != "y" {
+		val, _ := Sqlite.LoadSetting("IndexPolicy")
+		if val != "" {
+			json.Unmarshal([]byte(val), &policy)
+		}
+		updates_enabled = false
+		policy = gnomon.EditIndexPolicy(policy)
+		updates_enabled = true
+	}
+	bytes, _ := json.Marshal(policy)
+	Sqlite.SaveSetting("IndexPolicy", string(bytes))
+	if gnomon.Started {
+		fmt.Println("Updating live index policy. Contracts already indexed are kept.")
+		gnomon.SetIndexPolicy(policy)
+	}
+}
+
+func updateGnomonSpamPolicy() {
+	Sqlite := getGnomonDiskDB()
+	defer Sqlite.DB.Close()
+	policy := structs.SpamPolicy{MaxRegistrations: 10}
+	val, _ := Sqlite.LoadSetting("SpamPolicy")
+	if val != "" {
+		json.Unmarshal([]byte(val), &policy)
+	} else if gnomon.Started {
+		policy = gnomon.GetSpamPolicy()
+	}
+	updates_enabled = false
+	policy = gnomon.EditSpamPolicy(policy)
+	updates_enabled = true
+	bytes, _ := json.Marshal(policy)
+	Sqlite.SaveSetting("SpamPolicy", string(bytes))
+	if gnomon.Started {
+		fmt.Println("Updating live spam policy. All time limits also apply to indexed invokes after the next batch.")
+		gnomon.SetSpamPolicy(policy)
+	}
+}
+
 // Gets saved filters if available
 func getGnomonFilters(Filters map[string]map[string][]string) map[string]map[string][]string {
 	Sqlite := getGnomonDiskDB()
@@ -936,56 +1048,6 @@ func getGnomonFilters(Filters map[string]map[string][]string) map[string]map[str
 	return Filters
 }
 
-// Runs after startup
-func getTelaIndexes() {
-	if !gnomon.Started {
-		println("Gnomon not started")
-		return
-	}
-	fmt.Println("Showing Tela Indexes")
-	scids := gnomon.Sqlite.GetSCIDsByTags([]string{"telaVersion"})
-	height, _ := gnomon.Sqlite.GetLastIndexHeight()
-	fmt.Println("Checking at Height:", height)
-	for _, scid := range scids {
-		fmt.Println("")
-		fmt.Println("Tela Index SCID:", scid)
-
-		var hVars []*structs.SCIDVariable
-		hVars = gnomon.Sqlite.GetSCIDVariableDetailsAtTopoheight(scid, height)
-
-		for _, variable := range hVars {
-			if variable.Key == "nameHdr" ||
-				variable.Key == "iconURLHdr" ||
-				variable.Key == "descrHdr" {
-				fmt.Println(variable.Key, ":", variable.Value)
-			}
-		}
-	}
-}
-
-// Returns a list of distinct values from CSV results returned via the query
-func getDistinctFromCSV(q string, v any) (results []string) {
-	var list string
-	//sql.Ask() //applies for disk mode
-	sql.SetReady(false)
-	rows, err := gnomon.Sqlite.DB.Query(q, v)
-	sql.SetReady(true)
-	if err != nil {
-		fmt.Println(err)
-	}
-	for rows.Next() {
-		rows.Scan(&list)
-		items := strings.Split(list, ",")
-		//	fmt.Println(items)
-		for _, item := range items {
-			if !slices.Contains(results, item) {
-				results = append(results, item)
-			}
-		}
-	}
-	return
-}
-
 // Search for scs by class or tag
 func searchFiltered() {
 	if !gnomon.Started {
@@ -994,14 +1056,16 @@ func searchFiltered() {
 	}
 	scids := []string{}
 	address := ""
-	results := []string{}
-	kind := getText(`Enter "c" for class or "t" for tags or "a" to search by address, blank to use your address`)
-	if kind == "c" {
-		results = getDistinctFromCSV(`SELECT DISTINCT class FROM scs`, nil)
+	results := []structs.MetaCount{}
+	kind := getText(`Enter "c" for class, "t" for tags, "f" for contracts with the same code as an SCID, "l" to list code families or "a" for the activity of an address, blank for your own`)
+	if kind == "f" || kind == "l" {
+		scids = searchCode(kind)
+	} else if kind == "c" {
+		results = gnomon.Backend().GetClassCounts()
 		fmt.Println("Classes currently in DB:")
 
 	} else if kind == "t" {
-		results = getDistinctFromCSV(`SELECT DISTINCT tags FROM scs`, nil)
+		results = gnomon.Backend().GetTagCounts()
 		fmt.Println("Tags currently in DB:")
 
 	} else if kind == "a" {
@@ -1015,20 +1079,18 @@ func searchFiltered() {
 
 	if kind == "c" || kind == "t" {
 		for _, item := range results {
-			fmt.Println(item)
+			fmt.Println(item.Name, "-", item.Count)
 		}
 	}
-	if kind == "c" {
-		scids = gnomon.Sqlite.GetSCIDsByClass(strings.Split(getText(`Enter class or classes csv to search for:`), ","))
+	if kind == "f" || kind == "l" {
+		// already searched
+	} else if kind == "c" {
+		scids = gnomon.Backend().GetSCIDsByClass(strings.Split(getText(`Enter class or classes csv to search for:`), ","))
 	} else if kind == "t" {
-		scids = gnomon.Sqlite.GetSCIDsByTags(strings.Split(getText(`Enter tag or tags csv to search for:`), ","))
+		scids = gnomon.Backend().GetSCIDsByTags(strings.Split(getText(`Enter tag or tags csv to search for:`), ","))
 	} else if address != "" {
-		//get the results for address search
-		for scid, scowner := range gnomon.Sqlite.GetAllOwnersAndSCIDs() {
-			if scowner == address {
-				scids = append(scids, scid)
-			}
-		}
+		searchActivity(address)
+		return
 	}
 	scidcount := len(scids)
 	fmt.Println("Number of results:", len(scids))
@@ -1043,7 +1105,7 @@ func searchFiltered() {
 	}
 	start := scidcount - max
 	start = int(math.Max(0, float64(start)))
-	height, _ := gnomon.Sqlite.GetLastIndexHeight()
+	height, _ := gnomon.Backend().GetLastIndexHeight()
 	fmt.Println("Checking at Height:", height)
 	for si, scid := range scids {
 		if si < start {
@@ -1053,7 +1115,7 @@ func searchFiltered() {
 		fmt.Println("SCID:", scid, "-------------------")
 		if show_details {
 			var hVars []*structs.SCIDVariable
-			hVars = gnomon.Sqlite.GetSCIDVariableDetailsAtTopoheight(scid, height)
+			hVars = gnomon.Backend().GetSCIDVariableDetailsAtTopoheight(scid, height)
 			name := ""
 			data := ""
 			for i, variable := range hVars {
@@ -1087,6 +1149,102 @@ func searchFiltered() {
 	}
 	getText(`Press enter to continue.`)
 }
+
+// Search by code fingerprint, "f" for the same code as an SCID or "l" to pick from the code families
+func searchCode(kind string) (scids []string) {
+	if kind == "f" {
+		scid := getText(`Enter SCID:`)
+		similar := getText(`Include similar contracts from the same template family? (y/n)`) == "y"
+		fp, err := gnomon.Backend().GetFingerprint(scid)
+		if err != nil {
+			fmt.Println("No fingerprint for", scid)
+			return
+		}
+		fmt.Println("Code hash:", fp.CodeHash)
+		fmt.Println("Family:", fp.Family)
+		return gnomon.Backend().GetSCIDsWithSameCode(scid, similar)
+	}
+	families := gnomon.Backend().GetCodeFamilies()
+	max, _ := strconv.Atoi(getText(`Enter max number of families to list: `))
+	for i, family := range families {
+		if i >= max {
+			break
+		}
+		fmt.Printf("[%d] %s contracts: %d variants: %d first height: %d\n", i, family.Family, family.Count, family.Variants, family.FirstHeight)
+	}
+	i, err := strconv.Atoi(getText(`Enter a family number to list its contracts or blank to return:`))
+	if err != nil || i < 0 || i >= len(families) {
+		return
+	}
+	return gnomon.Backend().GetSCIDsByFamily(families[i].Family)
+}
+
+// Lists the code versions of an SC and shows the changes between two of them
+func showCodeHistory(scid string) {
+	if !gnomon.Started {
+		println("Gnomon not started")
+		return
+	}
+	if scid == "" {
+		scid = getText(`Enter SCID:`)
+	}
+	versions := gnomon.Backend().GetSCCodeHistory(scid)
+	if len(versions) == 0 {
+		fmt.Println("No code stored for", scid)
+		return
+	}
+	for i, version := range versions {
+		fmt.Printf("[%d] height: %d txid: %s hash: %s\n", i, version.Height, version.TXID, version.CodeHash)
+	}
+	if len(versions) == 1 {
+		fmt.Println("Code has not been updated since install.")
+		return
+	}
+	i, err := strconv.Atoi(getText(`Enter a version number to diff against the one before it, blank to return:`))
+	if err != nil || i < 1 || i >= len(versions) {
+		return
+	}
+	fmt.Println("--- height", versions[i-1].Height)
+	fmt.Println("+++ height", versions[i].Height)
+	fmt.Print(diffLines(versions[i-1].Code, versions[i].Code))
+	getText(`Press enter to continue.`)
+}
+
+// Line diff using the longest common subsequence, removed lines start with "-" and added with "+"
+func diffLines(from string, to string) (diff string) {
+	a := strings.Split(from, "\n")
+	b := strings.Split(to, "\n")
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff += "  " + a[i] + "\n"
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			diff += "+ " + b[j] + "\n"
+			j++
+		default:
+			diff += "- " + a[i] + "\n"
+			i++
+		}
+	}
+	return
+}
+
 func isAStr(value any) bool {
 	switch value.(type) {
 	case string:
@@ -1100,14 +1258,14 @@ func isAStr(value any) bool {
 func searchByClass() {
 	tag := getText("Enter tag or csv of tags")
 	tags := strings.Split(tag, ",")
-	scids := gnomon.Sqlite.GetSCIDsByTags(tags)
-	height, _ := gnomon.Sqlite.GetLastIndexHeight()
+	scids := gnomon.Backend().GetSCIDsByTags(tags)
+	height, _ := gnomon.Backend().GetLastIndexHeight()
 	fmt.Println("Checking at Height:", height)
 	for _, scid := range scids {
 		fmt.Println("")
 		fmt.Println("SCID:", scid)
 		var hVars []*structs.SCIDVariable
-		hVars = gnomon.Sqlite.GetSCIDVariableDetailsAtTopoheight(scid, height)
+		hVars = gnomon.Backend().GetSCIDVariableDetailsAtTopoheight(scid, height)
 		for _, variable := range hVars {
 			if variable.Key == "nameHdr" {
 				fmt.Println("-------------------")
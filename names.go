@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"gnomon"
+	"strings"
+
+	"github.com/deroproject/derohe/globals"
+)
+
+// names <name or address>, blank for the open wallet's names
+func names(value string) {
+	if !gnomon.Started {
+		println("Gnomon not started")
+		return
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		if dero.Wallet == nil {
+			println("No wallet open, type help for more info")
+			return
+		}
+		value = dero.Wallet.GetAddress().String()
+	}
+	if _, err := globals.ParseValidateAddress(value); err != nil {
+		address, err := gnomon.Backend().GetNameAddress(value)
+		if err != nil {
+			fmt.Println("Name not registered:", value)
+			return
+		}
+		fmt.Println(value, "is registered to", address)
+		return
+	}
+	list := gnomon.Backend().GetAddressNames(value)
+	fmt.Println("Names held by", value+":", len(list))
+	for _, n := range list {
+		fmt.Printf("%s registered at height: %d txid: %s\n", n.Name, n.Height, n.TXID)
+	}
+}
+
+// Returns the address of a name registered on the daemon, the local index can lag
+// behind transfers so the address is confirmed before use. Addresses are returned
+// unchanged, ok is false when the name doesn't resolve or isn't confirmed.
+func resolveName(receiver string) (address string, ok bool) {
+	if _, err := globals.ParseValidateAddress(receiver); err == nil {
+		return receiver, true
+	}
+	address, err := dero.Wallet.NameToAddress(receiver)
+	if err != nil {
+		fmt.Println("Could not resolve", receiver, "on the daemon:", err)
+		return "", false
+	}
+	if getText(fmt.Sprintf("%s is registered to %s, send to this address? (y/n)", receiver, address)) != "y" {
+		return "", false
+	}
+	return address, true
+}
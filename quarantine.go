@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"gnomon"
+	"strconv"
+	"strings"
+)
+
+// quarantine [scid] lists invokes held back by the spam policy for review
+func quarantine(value string) {
+	if !gnomon.Started {
+		println("Gnomon not started")
+		return
+	}
+	list := gnomon.Backend().GetQuarantined(strings.TrimSpace(value))
+	fmt.Println("Quarantined invokes:", len(list))
+	for i, tx := range list {
+		fmt.Printf("[%d] %s height: %d\n", i, tx.TXID, tx.Height)
+		fmt.Println("    scid:", tx.SCID, "entrypoint:", tx.Entrypoint)
+		fmt.Println("    signer:", tx.Signer, "reason:", tx.Reason)
+	}
+	if len(list) == 0 {
+		return
+	}
+	i, err := strconv.Atoi(getText(`Enter a number to review, blank to return:`))
+	if err != nil || i < 0 || i >= len(list) {
+		return
+	}
+	txid := list[i].TXID
+	switch getText(`Type "release" to index it, "drop" to delete it, blank to return:`) {
+	case "release":
+		if err := gnomon.ReleaseQuarantined(txid); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Released", txid)
+	case "drop":
+		if err := gnomon.DropQuarantined(txid); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Dropped", txid)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"gnomon"
+	sql "gnomon/db"
+	"strconv"
+	"strings"
+)
+
+// Matched terms are shown bold
+var highlight = strings.NewReplacer(sql.HighlightStart, "\033[1m", sql.HighlightEnd, "\033[0m", "\n", " ")
+
+// Pages through the contracts whose name, description, code or string variables match the query
+func searchText(query string) {
+	if query == "" {
+		query = getText(`Enter the words to search for:`)
+	}
+	size, _ := strconv.Atoi(getText(`Enter results per page, blank for 20:`))
+	if size <= 0 {
+		size = 20
+	}
+	for offset := 0; ; {
+		results, total := gnomon.Backend().SearchText(query, size, offset)
+		fmt.Println("Matches for", strconv.Quote(query)+":", total)
+		if total == 0 {
+			return
+		}
+		for i, r := range results {
+			field := r.Field
+			if r.Key != "" {
+				field += " " + r.Key
+			}
+			fmt.Printf("[%d] %s %s (%s) height: %d\n", offset+i, r.SCID, r.Name, field, r.Height)
+			fmt.Println("    " + highlight.Replace(r.Snippet))
+		}
+		fmt.Printf("Showing %d-%d of %d\n", offset, offset+len(results)-1, total)
+		switch getText(`Enter "n" for the next page, "p" for the previous, blank to return:`) {
+		case "n":
+			if offset+size < total {
+				offset += size
+			}
+		case "p":
+			offset = max(offset-size, 0)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"gnomon"
+	"gnomon/structs"
+	"strconv"
+	"strings"
+)
+
+// swaps [status] [asset] lists orders, swaps <scid> inspects one
+func swaps(value string) {
+	if !gnomon.Started {
+		println("Gnomon not started")
+		return
+	}
+	args := strings.Fields(value)
+	if len(args) == 1 && len(args[0]) == 64 {
+		inspectSwap(args[0])
+		return
+	}
+	status, asset := "", ""
+	for _, arg := range args {
+		if len(arg) == 64 {
+			asset = arg
+		} else {
+			status = arg
+		}
+	}
+	if value == "" {
+		status = getText(`Enter status to show (open, filled, cancelled, expired), blank for all:`)
+		asset = getText(`Enter an asset SCID to filter by, blank for all:`)
+	}
+	orders := gnomon.Backend().GetSwaps(status, asset)
+	fmt.Println("Number of orders:", len(orders))
+	for i, order := range orders {
+		fmt.Printf("[%d] %s %s\n", i, order.SCID, order.Status)
+		fmt.Println("    offers:", order.OfferAmount, assetLabel(order.OfferAsset), "for:", order.RequestAmount, assetLabel(order.RequestAsset))
+	}
+	if len(orders) == 0 {
+		return
+	}
+	i, err := strconv.Atoi(getText(`Enter a number to inspect, blank to return:`))
+	if err != nil || i < 0 || i >= len(orders) {
+		return
+	}
+	inspectSwap(orders[i].SCID)
+}
+
+func inspectSwap(scid string) {
+	order, err := gnomon.Backend().GetSwap(scid)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	showSwap(order)
+	heights := gnomon.Backend().GetSCIDInteractionHeight(scid)
+	fmt.Println("Interactions:", len(heights))
+	for _, height := range heights {
+		fmt.Println("    height:", height)
+	}
+}
+
+func showSwap(order structs.SwapOrder) {
+	fmt.Println("")
+	fmt.Println("SCID:", order.SCID, "-------------------")
+	fmt.Println("Status:", order.Status)
+	fmt.Println("Maker:", order.Maker)
+	fmt.Println("Offered:", order.OfferAmount, assetLabel(order.OfferAsset))
+	fmt.Println("Requested:", order.RequestAmount, assetLabel(order.RequestAsset))
+	if order.Expiry != 0 {
+		fmt.Println("Expiry:", order.Expiry)
+	}
+	fmt.Println("Updated at height:", order.Height)
+}
+
+func assetLabel(asset string) string {
+	switch {
+	case asset == "":
+		return "(unknown asset)"
+	case strings.Trim(asset, "0") == "":
+		return "DERO"
+	}
+	return asset
+}
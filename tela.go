@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gnomon"
+	"gnomon/structs"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Local servers of assembled TELA apps by INDEX scid
+var telaServers = map[string]*http.Server{}
+var telaMutex sync.Mutex
+
+// tela list [search], tela serve <scid> [height], tela stop [scid]
+func tela(value string) {
+	if !gnomon.Started {
+		println("Gnomon not started")
+		return
+	}
+	sub, arg, _ := strings.Cut(strings.TrimSpace(value), " ")
+	arg = strings.TrimSpace(arg)
+	switch sub {
+	case "", "list":
+		telaList(arg)
+	case "serve":
+		scid, h, _ := strings.Cut(arg, " ")
+		if scid == "" {
+			scid = getText(`Enter INDEX SCID:`)
+		}
+		height, _ := strconv.ParseInt(strings.TrimSpace(h), 10, 64)
+		serveTela(scid, height)
+	case "stop":
+		stopTela(arg)
+	default:
+		fmt.Println(`Use "tela list [search]", "tela serve <scid> [height]" or "tela stop [scid]"`)
+	}
+}
+
+func telaList(search string) {
+	indexes := gnomon.TelaIndexes(search)
+	if len(indexes) == 0 {
+		fmt.Println("No Tela indexes found")
+		return
+	}
+	for i, index := range indexes {
+		fmt.Printf("[%d] %s %s\n", i, index.Name, index.DURL)
+		fmt.Println("    SCID:", index.SCID, "versions:", index.Versions)
+		if index.Description != "" {
+			fmt.Println("    " + index.Description)
+		}
+	}
+	i, err := strconv.Atoi(getText(`Enter a number to show its versions, blank to return:`))
+	if err != nil || i < 0 || i >= len(indexes) {
+		return
+	}
+	telaVersions(indexes[i])
+}
+
+func telaVersions(index structs.TelaIndex) {
+	versions := gnomon.Backend().GetSCCodeHistory(index.SCID)
+	if len(versions) == 0 {
+		fmt.Println("No code stored for", index.SCID)
+		return
+	}
+	for i, version := range versions {
+		fmt.Printf("[%d] height: %d txid: %s docs: %d\n", i, version.Height, version.TXID, len(gnomon.TelaDocRefs(version.Code)))
+	}
+	text := getText(`Enter a version number to serve, "l" for the latest, blank to return:`)
+	if text == "l" {
+		serveTela(index.SCID, 0)
+		return
+	}
+	i, err := strconv.Atoi(text)
+	if err != nil || i < 0 || i >= len(versions) {
+		return
+	}
+	serveTela(index.SCID, versions[i].Height)
+}
+
+// Assembles the app at height and serves it on a free local port
+func serveTela(scid string, height int64) {
+	docs, err := gnomon.AssembleTela(scid, height)
+	if err != nil {
+		fmt.Println("Tela error:", err)
+		return
+	}
+	files := map[string]structs.TelaDoc{}
+	unverified := 0
+	for _, doc := range docs {
+		status := "verified"
+		if !doc.Verified {
+			status = "UNVERIFIED: " + doc.Error
+			unverified++
+		}
+		fmt.Printf("%s %s (%s) %s\n", doc.SCID, doc.Path, doc.DocType, status)
+		if doc.Path != "" && doc.Content != nil {
+			files[doc.Path] = doc
+		}
+	}
+	if _, ok := files["index.html"]; !ok {
+		fmt.Println("Tela error: app has no index.html")
+		return
+	}
+	if unverified != 0 && getText(fmt.Sprintf(`%d files failed verification, enter y to serve anyway:`, unverified)) != "y" {
+		return
+	}
+
+	stopTela(scid)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Println("Tela error:", err)
+		return
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if name == "" || strings.HasSuffix(name, "/") {
+			name += "index.html"
+		}
+		doc, ok := files[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(doc.Content))
+	})}
+	telaMutex.Lock()
+	telaServers[scid] = server
+	telaMutex.Unlock()
+	go server.Serve(listener)
+	fmt.Println("Serving", scid, "at http://"+listener.Addr().String())
+}
+
+// Stops the server of an app, or all of them when scid is blank
+func stopTela(scid string) {
+	telaMutex.Lock()
+	defer telaMutex.Unlock()
+	for id, server := range telaServers {
+		if scid == "" || id == scid {
+			server.Shutdown(context.Background())
+			delete(telaServers, id)
+			fmt.Println("Stopped serving", id)
+		}
+	}
+}
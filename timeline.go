@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"gnomon"
+	"gnomon/structs"
+	"strconv"
+	"strings"
+)
+
+// Sparkline levels, lowest first
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// Points in a sparkline
+const sparkWidth = 60
+
+// Charts a uint64 variable of an SC as a sparkline, eg. timeline <scid> <key>
+func showTimeline(value string) {
+	if !gnomon.Started {
+		println("Gnomon not started")
+		return
+	}
+	scid, key, _ := strings.Cut(strings.TrimSpace(value), " ")
+	if scid == "" {
+		scid = getText(`Enter SCID:`)
+	}
+	if key = strings.TrimSpace(key); key == "" {
+		key = getText(`Enter the variable key:`)
+	}
+	last, _ := gnomon.Backend().GetLastIndexHeight()
+	changes := gnomon.Backend().GetSCIDVariableChanges(scid, key, 0, last)
+	if len(changes) == 0 {
+		fmt.Println("No changes stored for", key)
+		return
+	}
+	from, err := strconv.ParseInt(getText(`Enter the starting height, blank for the first change:`), 10, 64)
+	if err != nil || from < 0 {
+		from = changes[0].Height
+	}
+	to, err := strconv.ParseInt(getText(`Enter the ending height, blank for the last indexed:`), 10, 64)
+	if err != nil || to > last {
+		to = last
+	}
+	if to < from {
+		fmt.Println("Nothing between", from, "and", to)
+		return
+	}
+	step := (to-from)/sparkWidth + 1
+	line, low, high, ok := sparkline(gnomon.Backend().GetSCIDVariableTimeline(scid, key, from, to, step))
+	if ok {
+		fmt.Printf("%s from %d to %d, every %d blocks\n", key, from, to, step)
+		fmt.Println(line)
+		fmt.Println("min:", low, "max:", high)
+	} else {
+		fmt.Println(key, "is not a uint64 variable, no sparkline")
+	}
+	if getText(`Enter "c" to list the changes, blank to return:`) != "c" {
+		return
+	}
+	for _, change := range changes {
+		if change.Height < from || change.Height > to {
+			continue
+		}
+		if change.Deleted {
+			fmt.Printf("height: %d txid: %s deleted\n", change.Height, change.TXID)
+		} else {
+			fmt.Printf("height: %d txid: %s value: %v\n", change.Height, change.TXID, change.Value)
+		}
+	}
+	getText(`Press enter to continue.`)
+}
+
+// One rune per point scaled between the lowest and highest value, blank where the key
+// isn't set. Not ok when a value isn't a uint64
+func sparkline(points []structs.VariablePoint) (line string, low uint64, high uint64, ok bool) {
+	var values []uint64
+	for _, point := range points {
+		if v, isUint := point.Value.(uint64); isUint {
+			values = append(values, v)
+		} else if point.Value != nil {
+			return
+		}
+	}
+	if len(values) == 0 {
+		return
+	}
+	low, high = values[0], values[0]
+	for _, v := range values {
+		low, high = min(low, v), max(high, v)
+	}
+	var b strings.Builder
+	for _, point := range points {
+		v, isUint := point.Value.(uint64)
+		switch {
+		case !isUint:
+			b.WriteRune(' ')
+		case high == low:
+			b.WriteRune(sparks[0])
+		default:
+			b.WriteRune(sparks[int(float64(v-low)/float64(high-low)*float64(len(sparks)-1)+0.5)])
+		}
+	}
+	return b.String(), low, high, true
+}
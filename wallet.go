@@ -4,7 +4,6 @@ import (
 	"encoding/hex"
 	"fmt"
 	"gnomon"
-	sql "gnomon/db"
 	"strconv"
 	"strings"
 	"time"
@@ -259,7 +258,10 @@ func sendDero() {
 	}
 	max_str := globals.FormatMoney(max_balance)
 
-	receiver := getText(`Enter Recipient's Dero Address:`)
+	receiver, ok := resolveName(getText(`Enter Recipient's Dero Address or registered name:`))
+	if !ok {
+		return
+	}
 	address, err := globals.ParseValidateAddress(receiver)
 	if err != nil || address.String() == dero.Wallet.GetAddress().String() {
 		fmt.Println("Error with recipient address. ", err)
@@ -417,7 +419,7 @@ func sendToken(input string) {
 
 	token_name := "token"
 	//if gnomon.TargetHeight > 0
-	hVars := gnomon.Sqlite.GetAllSCIDVariableDetails(scid.String())
+	hVars := gnomon.Backend().GetAllSCIDVariableDetails(scid.String())
 	for _, v := range hVars {
 		switch v.Key.(type) {
 		case string:
@@ -452,7 +454,10 @@ func sendToken(input string) {
 
 	fmt.Println("Your "+token_name+" balance:", max_balance)
 
-	receiver := getText(`Enter Token Recipient's Address:`)
+	receiver, ok := resolveName(getText(`Enter Token Recipient's Address or registered name:`))
+	if !ok {
+		return
+	}
 
 	address, err := globals.ParseValidateAddress(receiver)
 	if err != nil || address.String() == dero.Wallet.GetAddress().String() {
@@ -579,41 +584,18 @@ func tokens() {
 		return
 	}
 
-	height, err := gnomon.Sqlite.GetLastIndexHeight()
+	height, err := gnomon.Backend().GetLastIndexHeight()
 	if err != nil {
 		println("Error", err)
 	}
 	start_height := getText("Start at certain height? This can take a while. Max indexed: " + strconv.Itoa(int(height)))
 
-	scids := gnomon.Sqlite.GetSCIDsByClass([]string{"token"})
-
-	sql.SetReady(false)
-	var token_scid string
-	var install_height int
-	gnomon.Sqlite.DB.QueryRow(
-		`SELECT scid,height
-		FROM scs
-		WHERE height > ?
-		ORDER BY height ASC
-		LIMIT 1;
-		`, start_height).Scan(&token_scid, &install_height)
-	sql.SetReady(true)
-	if err != nil {
-		fmt.Println(err)
-	}
+	from, _ := strconv.ParseInt(start_height, 10, 64)
+	scids := gnomon.Backend().GetSCIDsByClassAbove([]string{"token"}, from)
 
 	total := len(scids)
-	start := false
 	counter := 0
 	for _, scid := range scids {
-		if !start {
-			total--
-			if token_scid == scid {
-				start = true
-			} else {
-				continue
-			}
-		}
 		counter++
 		print("\rScan Progress: ", fmt.Sprintf("%.2f", 1.0/(float64(total)/float64(counter))*100.0), "%")
 		scidhash := crypto.HexToHash(scid)